@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+)
+
+// buildFailure is a build that failed when keep going.
+type buildFailure struct {
+	build *builder.Build
+	err   error
+}
+
+// runBuilds starts the builds, emitting the resolved image of each successful one.
+// Up to parallelism builds run at the same time, each worker with its own build processor, created by newProcessor.
+// It stops starting builds at the first failed one, unless keep going is enabled: then it starts every build,
+// logs a summary of the failed ones at the end, and reports an error when any of them failed.
+// The results of the builds are written to the report file, if any, even when some of them failed.
+func runBuilds(newProcessor func() driverbuilder.BuildProcessor, rootOpts *RootOptions, builds ...*builder.Build) error {
+	parallelism := configOptions.Parallelism
+	if parallelism > len(builds) {
+		parallelism = len(builds)
+	}
+	if parallelism > 1 {
+		for _, b := range builds {
+			// Loading builder images modifies them: each concurrent build gets its own copy
+			b.Images = b.Images.Clone()
+		}
+	}
+
+	var mu sync.Mutex
+	var failures []buildFailure
+	results := make([]buildResult, len(builds))
+	for i, b := range builds {
+		results[i] = skippedBuildResult(b)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bp := newProcessor()
+			for i := range jobs {
+				b := builds[i]
+				mu.Lock()
+				stop := len(failures) > 0 && !configOptions.KeepGoing
+				mu.Unlock()
+				if stop {
+					continue
+				}
+				start := time.Now()
+				err := startBuild(bp, b)
+				elapsed := time.Since(start)
+				mu.Lock()
+				if err == nil {
+					err = rootOpts.emitResolvedImage(b)
+				}
+				results[i] = newBuildResult(b, err, elapsed)
+				if err != nil {
+					if configOptions.KeepGoing {
+						logger.WithField("target", b.TargetType).WithError(err).Error("build failed, going on with the next one")
+					}
+					failures = append(failures, buildFailure{build: b, err: err})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range builds {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if rootOpts.ReportFile != "" {
+		if err := writeReport(rootOpts.ReportFile, results); err != nil {
+			if len(failures) == 0 {
+				return err
+			}
+			logger.WithError(err).Error("error writing the build report")
+		} else {
+			logger.WithField("path", rootOpts.ReportFile).Info("build report available")
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	if !configOptions.KeepGoing {
+		return failures[0].err
+	}
+	for _, f := range failures {
+		logger.WithField("target", f.build.TargetType).
+			WithField("kernelrelease", f.build.KernelRelease).
+			WithField("gcc", f.build.GCCVersion).
+			WithError(f.err).
+			Error("failed build")
+	}
+	return fmt.Errorf("%d of %d builds failed", len(failures), len(builds))
+}
+
+// startBuild starts the build with the build processor.
+// In auto gcc mode, it tries the gcc versions provided by the builder images until the build succeeds,
+// moving on to the next gcc only when the build itself fails, and not the build processor.
+func startBuild(bp driverbuilder.BuildProcessor, b *builder.Build) error {
+	if b.GCCVersion != builder.GCCAuto {
+		return bp.Start(b)
+	}
+	ctx, cancel := discoveryContext()
+	candidates, err := b.GCCCandidates(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	for _, gcc := range candidates {
+		b.GCCVersion = gcc.String()
+		err = bp.Start(b)
+		if err == nil {
+			logger.WithField("target", b.TargetType).WithField("gcc", b.GCCVersion).Info("build succeeded")
+			return nil
+		}
+		var buildErr *driverbuilder.BuildError
+		if !errors.As(err, &buildErr) {
+			return err
+		}
+		logger.WithField("target", b.TargetType).WithField("gcc", b.GCCVersion).WithError(err).Warn("build failed, trying the next gcc")
+	}
+	return fmt.Errorf("build failed with every gcc version: %w", err)
+}
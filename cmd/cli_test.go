@@ -5,19 +5,30 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/acarl005/stripansi"
+	"gopkg.in/yaml.v3"
 	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type expect struct {
@@ -71,6 +82,28 @@ var tests = []testCase{
 			err: "exiting for validation errors",
 		},
 	},
+	{
+		descr: "invalid/config/proxy-missing-scheme-colon",
+		args: []string{
+			"--proxy",
+			"http//proxy:3128",
+		},
+		expect: expect{
+			out: "testdata/invalid-proxyconfig.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "invalid/config/proxy-missing-host",
+		args: []string{
+			"--proxy",
+			"http://",
+		},
+		expect: expect{
+			out: "testdata/invalid-proxyconfig.txt",
+			err: "exiting for validation errors",
+		},
+	},
 	{
 		descr: "docker/all-flags",
 		args: []string{
@@ -92,6 +125,63 @@ var tests = []testCase{
 			out: "testdata/docker-with-flags.txt",
 		},
 	},
+	{
+		descr: "docker/log-format-json",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--log-format",
+			"json",
+		},
+		expect: expect{
+			out: "testdata/docker-log-format-json.txt",
+		},
+	},
+	{
+		descr: "docker/no-color",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--no-color",
+		},
+		expect: expect{
+			out: "testdata/docker-no-color.txt",
+		},
+	},
+	{
+		descr: "docker/no-color-env",
+		env: map[string]string{
+			"NO_COLOR": "1",
+		},
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+		},
+		expect: expect{
+			out: "testdata/docker-no-color.txt",
+		},
+	},
 	{
 		descr: "docker/empty",
 		args:  []string{"docker"},
@@ -230,6 +320,251 @@ var tests = []testCase{
 			err: "exiting for validation errors",
 		},
 	},
+	{
+		descr: "docker/drivers-without-output",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--drivers",
+			"probe",
+		},
+		expect: expect{
+			out: "testdata/docker-drivers-without-output.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/invalid-drivers",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--drivers",
+			"module,bpf",
+		},
+		expect: expect{
+			out: "testdata/docker-invalid-drivers.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/missing-kernelconfig",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--kernelconfig",
+			"/tmp/driverkit-missing/.config",
+		},
+		expect: expect{
+			out: "testdata/docker-missing-kernelconfig.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/target-not-allowed",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws,rhel",
+			"--output-module",
+			"/tmp/falco-{target}.ko",
+			"-c",
+			"testdata/configs/allowed-targets.yaml",
+		},
+		expect: expect{
+			out: "testdata/docker-target-not-allowed.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/target-not-allowed-through-alias",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.19.0-6-amd64",
+			"--kernelversion",
+			"1",
+			"--target",
+			"debian",
+			"--target-alias",
+			"centos=debian",
+			"--output-module",
+			"/tmp/falco.ko",
+			"-c",
+			"testdata/configs/allowed-targets.yaml",
+		},
+		expect: expect{
+			out: "testdata/docker-target-not-allowed-through-alias.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/invalid-architecture",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"4.15.0-1057-aws",
+			"--kernelversion",
+			"59",
+			"--target",
+			"ubuntu-aws",
+			"--output-module",
+			"/tmp/falco-ubuntu-aws.ko",
+			"--architecture",
+			"x86",
+		},
+		expect: expect{
+			out: "testdata/docker-invalid-architecture.txt",
+			err: "exiting for validation errors",
+		},
+	},
+	{
+		descr: "docker/dryrun-output-json",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"3.10.0-957.el7.x86_64",
+			"--target",
+			"centos",
+			"--architecture",
+			"amd64",
+			"--output-module",
+			"/tmp/falco-centos.ko",
+			"--builderrepo",
+			testdataPath("images/images.yaml"),
+			"--dryrun-output",
+			"json",
+		},
+		expect: expect{
+			out: "testdata/docker-dryrun-output-json.txt",
+		},
+	},
+	{
+		descr: "docker/dryrun-output-table",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"3.10.0-957.el7.x86_64",
+			"--target",
+			"centos",
+			"--architecture",
+			"amd64",
+			"--output-module",
+			"/tmp/falco-centos.ko",
+			"--builderrepo",
+			testdataPath("images/images.yaml"),
+			"--gccversion",
+			">=5.0.0",
+			"--dryrun-output",
+			"table",
+		},
+		expect: expect{
+			out: "testdata/docker-dryrun-output-table.txt",
+		},
+	},
+	{
+		descr: "docker/dryrun-output-embedded",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"3.10.0-957.el7.x86_64",
+			"--target",
+			"centos",
+			"--architecture",
+			"amd64",
+			"--output-module",
+			"/tmp/falco-centos.ko",
+			"--builderrepo",
+			"",
+			"--dryrun-output",
+			"table",
+		},
+		expect: expect{
+			out: "testdata/docker-dryrun-output-embedded.txt",
+		},
+	},
+	{
+		descr: "docker/dryrun-output-multiple-targets",
+		args: []string{
+			"docker",
+			"--kernelrelease",
+			"3.10.0-957.el7.x86_64",
+			"--target",
+			"centos,amazonlinux2",
+			"--architecture",
+			"amd64",
+			"--output-module",
+			"/tmp/falco.ko",
+			"--builderrepo",
+			testdataPath("images/images.yaml"),
+			"--dryrun-output",
+			"table",
+		},
+		expect: expect{
+			out: "testdata/docker-dryrun-output-multiple-targets.txt",
+		},
+	},
+	{
+		descr: "images/resolve",
+		args: []string{
+			"images",
+			"resolve",
+			"--kernelrelease",
+			"3.10.0-957.el7.x86_64",
+			"--target",
+			"centos,amazonlinux2",
+			"--architecture",
+			"amd64",
+			"--output-module",
+			"/tmp/falco.ko",
+			"--builderrepo",
+			testdataPath("images/images.yaml"),
+			"--dryrun-output",
+			"json",
+		},
+		expect: expect{
+			out: "testdata/images-resolve.txt",
+		},
+	},
+	{
+		descr: "gcc-versions",
+		args: []string{
+			"gcc-versions",
+			"--target",
+			"centos,amazonlinux2",
+			"--architecture",
+			"amd64",
+			"--builderrepo",
+			testdataPath("images/images.yaml"),
+		},
+		expect: expect{
+			out: "testdata/gcc-versions.txt",
+		},
+	},
 	{
 		descr: "complete/docker/targets",
 		args: []string{
@@ -297,6 +632,22 @@ var tests = []testCase{
 	},
 }
 
+// testdataPath returns the absolute path of a testdata file.
+func testdataPath(name string) string {
+	p, err := filepath.Abs(filepath.Join("testdata", name))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestMain(m *testing.M) {
+	// Expected outputs are colored logs, stripped of their colors, even when tests are not run on a terminal
+	os.Setenv("CLICOLOR_FORCE", "1")
+	os.Unsetenv("NO_COLOR")
+	os.Exit(m.Run())
+}
+
 func run(t *testing.T, test testCase) {
 	// Setup
 	c := NewRootCmd()
@@ -432,3 +783,514 @@ func TestCLI(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigOptionsProcessor(t *testing.T) {
+	co := NewConfigOptions()
+	assert.Assert(t, co.SetProcessor("k8s-ic") == nil)
+	assert.Equal(t, "kubernetes-in-cluster", co.Processor)
+	assert.Assert(t, co.SetProcessor("local") == nil)
+	assert.Equal(t, "local", co.Processor)
+
+	errs := co.SetProcessor("k3s")
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "processor must be one of [docker,kubernetes,kubernetes-in-cluster,local], or one of their aliases [k8s,k8s-ic]")
+	assert.Assert(t, co.configErrors)
+}
+
+func TestConfigOptionsKubernetesTimeout(t *testing.T) {
+	co := NewConfigOptions()
+	co.Timeout = 45 * time.Second
+	assert.Assert(t, co.SetProcessor("docker") == nil)
+
+	errs := co.SetProcessor("k8s")
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "timeout must be at least 1m0s when processor is kubernetes or kubernetes-in-cluster")
+
+	co = NewConfigOptions()
+	co.ProxyURL = "http://proxy.example.com:3128"
+	assert.Assert(t, co.SetProcessor("kubernetes-in-cluster") == nil)
+}
+
+func TestConfigOptionsBuildTimeout(t *testing.T) {
+	co := NewConfigOptions()
+	assert.Assert(t, co.Validate() == nil)
+	co.BuildTimeout = 5 * time.Minute
+	assert.Assert(t, co.Validate() == nil)
+
+	co.BuildTimeout = 10 * time.Second
+	errs := co.Validate()
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "build timeout must be at least 30s")
+}
+
+func TestTimeoutValue(t *testing.T) {
+	tests := map[string]struct {
+		value       string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		"seconds":          {value: "60", expected: time.Minute},
+		"duration":         {value: "1m30s", expected: 90 * time.Second},
+		"zero":             {value: "0", expected: 0},
+		"invalid":          {value: "abc", expectedErr: true},
+		"seconds fraction": {value: "1.5", expectedErr: true},
+		"empty":            {value: "", expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var v timeoutValue
+			err := v.Set(test.value)
+			if test.expectedErr {
+				assert.Error(t, err, "timeout must be a duration (eg: 15m) or an integer number of seconds")
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expected, time.Duration(v))
+			assert.Equal(t, test.expected.String(), v.String())
+		})
+	}
+}
+
+// fakePuller pulls the images missing locally, reporting their size.
+type fakePuller struct {
+	present map[string]bool
+	sizes   map[string]int64
+	fail    string
+	pulled  []string
+}
+
+func (p *fakePuller) Pull(ctx context.Context, image string, arch string) (bool, int64, error) {
+	if image == p.fail {
+		return false, 0, errors.New("unauthorized")
+	}
+	if p.present[image+"@"+arch] {
+		return false, 0, nil
+	}
+	p.pulled = append(p.pulled, image+"@"+arch)
+	return true, p.sizes[image], nil
+}
+
+func TestPrepull(t *testing.T) {
+	images := []prepullImage{
+		{Name: "myorg/builder-centos", Arch: "amd64"},
+		{Name: "myorg/builder-any", Arch: "amd64"},
+		{Name: "myorg/builder-centos", Arch: "arm64"},
+		{Name: "myorg/builder-debian", Arch: "amd64"},
+	}
+	puller := &fakePuller{
+		present: map[string]bool{"myorg/builder-any@amd64": true},
+		sizes:   map[string]int64{"myorg/builder-centos": 300, "myorg/builder-any": 1000, "myorg/builder-debian": 0},
+	}
+	pulled, total, err := prepull(context.Background(), puller, images)
+	assert.NilError(t, err)
+	// Images already present are not counted, while pulled ones are, even when all their layers were present
+	assert.Equal(t, 3, pulled)
+	assert.Equal(t, int64(600), total)
+	assert.DeepEqual(t, []string{"myorg/builder-centos@amd64", "myorg/builder-centos@arm64", "myorg/builder-debian@amd64"}, puller.pulled)
+
+	// The pulls stop at the first failure
+	puller = &fakePuller{sizes: map[string]int64{"myorg/builder-centos": 300}, fail: "myorg/builder-any"}
+	pulled, total, err = prepull(context.Background(), puller, images)
+	assert.Error(t, err, "error pulling builder image myorg/builder-any for architecture amd64: unauthorized")
+	assert.Equal(t, 1, pulled)
+	assert.Equal(t, int64(300), total)
+	assert.DeepEqual(t, []string{"myorg/builder-centos@amd64"}, puller.pulled)
+}
+
+type failingBuildProcessor struct {
+	fail    map[string]bool
+	started []string
+}
+
+func (f *failingBuildProcessor) String() string {
+	return "failing"
+}
+
+func (f *failingBuildProcessor) Start(b *builder.Build) error {
+	f.started = append(f.started, b.TargetType.String())
+	if f.fail[b.TargetType.String()] {
+		return errors.New("build failed")
+	}
+	return nil
+}
+
+func (f *failingBuildProcessor) processor() driverbuilder.BuildProcessor {
+	return f
+}
+
+func TestRunBuildsKeepGoing(t *testing.T) {
+	prev := configOptions
+	defer func() { configOptions = prev }()
+	configOptions = NewConfigOptions()
+
+	builds := []*builder.Build{
+		{TargetType: builder.Type("centos")},
+		{TargetType: builder.Type("ubuntu")},
+		{TargetType: builder.Type("debian")},
+	}
+	rootOpts := &RootOptions{}
+
+	bp := &failingBuildProcessor{fail: map[string]bool{"ubuntu": true}}
+	assert.ErrorContains(t, runBuilds(bp.processor, rootOpts, builds...), "build failed")
+	assert.DeepEqual(t, []string{"centos", "ubuntu"}, bp.started)
+
+	configOptions.KeepGoing = true
+	bp = &failingBuildProcessor{fail: map[string]bool{"ubuntu": true, "debian": true}}
+	assert.ErrorContains(t, runBuilds(bp.processor, rootOpts, builds...), "2 of 3 builds failed")
+	assert.DeepEqual(t, []string{"centos", "ubuntu", "debian"}, bp.started)
+
+	bp = &failingBuildProcessor{}
+	assert.NilError(t, runBuilds(bp.processor, rootOpts, builds...))
+}
+
+func TestRunBuildsReport(t *testing.T) {
+	prev := configOptions
+	defer func() { configOptions = prev }()
+	configOptions = NewConfigOptions()
+
+	builds := []*builder.Build{
+		{TargetType: builder.Type("centos"), KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", ModuleFilePath: "/tmp/{target}.ko"},
+		{TargetType: builder.Type("ubuntu"), KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", ModuleFilePath: "/tmp/{target}.ko"},
+		{TargetType: builder.Type("debian"), KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", ModuleFilePath: "/tmp/{target}.ko"},
+	}
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	rootOpts := &RootOptions{ReportFile: reportFile}
+
+	// The report is written even when a build fails, reporting the builds never started
+	bp := &failingBuildProcessor{fail: map[string]bool{"ubuntu": true}}
+	assert.ErrorContains(t, runBuilds(bp.processor, rootOpts, builds...), "build failed")
+	data, err := os.ReadFile(reportFile)
+	assert.NilError(t, err)
+	var results []buildResult
+	assert.NilError(t, json.Unmarshal(data, &results))
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, "centos", results[0].Target)
+	assert.Equal(t, buildSucceeded, results[0].Status)
+	assert.Equal(t, "/tmp/centos.ko", results[0].Module)
+	assert.Equal(t, buildFailed, results[1].Status)
+	assert.Equal(t, "build failed", results[1].Error)
+	assert.Equal(t, "", results[1].Module)
+	assert.Equal(t, buildSkipped, results[2].Status)
+
+	// Yaml reports
+	rootOpts.ReportFile = filepath.Join(t.TempDir(), "report.yaml")
+	bp = &failingBuildProcessor{}
+	assert.NilError(t, runBuilds(bp.processor, rootOpts, builds...))
+	data, err = os.ReadFile(rootOpts.ReportFile)
+	assert.NilError(t, err)
+	results = nil
+	assert.NilError(t, yaml.Unmarshal(data, &results))
+	assert.Equal(t, 3, len(results))
+	for _, res := range results {
+		assert.Equal(t, buildSucceeded, res.Status)
+		assert.Equal(t, "amd64", res.Architecture)
+	}
+}
+
+func TestKubernetesPodScheduling(t *testing.T) {
+	ko := &KubeOptions{
+		NodeSelector: []string{"kubernetes.io/arch=arm64"},
+		Tolerations:  []string{"arch=arm64:NoSchedule", "dedicated", ":NoExecute"},
+		Affinity:     "nodeAffinity:\n  requiredDuringSchedulingIgnoredDuringExecution:\n    nodeSelectorTerms:\n    - matchExpressions:\n      - {key: pool, operator: In, values: [builds]}\n",
+	}
+	scheduling, err := ko.podScheduling()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string]string{"kubernetes.io/arch": "arm64"}, scheduling.NodeSelector)
+	assert.DeepEqual(t, []corev1.Toleration{
+		{Key: "arch", Operator: corev1.TolerationOpEqual, Value: "arm64", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "dedicated", Operator: corev1.TolerationOpExists},
+		{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	}, scheduling.Tolerations)
+	terms := scheduling.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	assert.Equal(t, "pool", terms[0].MatchExpressions[0].Key)
+	assert.DeepEqual(t, []string{"builds"}, terms[0].MatchExpressions[0].Values)
+
+	// The affinity can be json too
+	scheduling, err = (&KubeOptions{Affinity: `{"nodeAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 1, "preference": {}}]}}`}).podScheduling()
+	assert.NilError(t, err)
+	assert.Equal(t, int32(1), scheduling.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].Weight)
+	assert.Assert(t, scheduling.NodeSelector == nil)
+
+	tests := map[string]KubeOptions{
+		"node selector without value": {NodeSelector: []string{"kubernetes.io/arch"}},
+		"toleration without key":      {Tolerations: []string{"=arm64:NoSchedule"}},
+		"toleration wrong effect":     {Tolerations: []string{"arch=arm64:Never"}},
+		"affinity wrong field type":   {Affinity: "nodeAffinity: 1"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := test.podScheduling()
+			assert.Assert(t, err != nil)
+		})
+	}
+}
+
+func TestKubernetesPodResources(t *testing.T) {
+	ro := PodResourcesOptions{CPURequest: "500m", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "8Gi"}
+	assert.Assert(t, ro.Validate() == nil)
+	resources := ro.podResources()
+	assert.Equal(t, "500m", resources.Requests.Cpu().String())
+	assert.Equal(t, "1Gi", resources.Requests.Memory().String())
+	assert.Equal(t, "2", resources.Limits.Cpu().String())
+	assert.Equal(t, "8Gi", resources.Limits.Memory().String())
+
+	tests := map[string]struct {
+		opts PodResourcesOptions
+		err  string
+	}{
+		"invalid quantity": {
+			opts: PodResourcesOptions{CPURequest: "1core", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "8Gi"},
+			err:  "cpu request must be a kubernetes resource quantity (eg: 500m, 2Gi)",
+		},
+		"empty quantity": {
+			opts: PodResourcesOptions{CPURequest: "1", MemoryRequest: "1Gi", CPULimit: "2"},
+			err:  "memory limit must be a kubernetes resource quantity (eg: 500m, 2Gi)",
+		},
+		"request greater than limit": {
+			opts: PodResourcesOptions{CPURequest: "1", MemoryRequest: "8Gi", CPULimit: "2", MemoryLimit: "4G"},
+			err:  "memory request 8Gi must not be greater than its limit 4G",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			errs := test.opts.Validate()
+			assert.Equal(t, 1, len(errs))
+			assert.Error(t, errs[0], test.err)
+		})
+	}
+}
+
+func TestApplyOutputDir(t *testing.T) {
+	tests := map[string]struct {
+		opts   RootOptions
+		module string
+		probe  string
+		err    string
+	}{
+		"both drivers": {
+			opts:   RootOptions{KernelRelease: "5.15.0-1-generic", Architecture: "amd64", ModuleDriverName: "falco", Output: OutputOptions{Dir: "/tmp/drivers"}},
+			module: "/tmp/drivers/{target}/{arch}/{kernelrelease}/falco.ko",
+			probe:  "/tmp/drivers/{target}/{arch}/{kernelrelease}/falco.o",
+		},
+		"probe not supported": {
+			opts:   RootOptions{KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", ModuleDriverName: "falco", Output: OutputOptions{Dir: "/tmp/drivers"}},
+			module: "/tmp/drivers/{target}/{arch}/{kernelrelease}/falco.ko",
+		},
+		"requested drivers": {
+			opts:  RootOptions{KernelRelease: "5.15.0-1-generic", Architecture: "amd64", ModuleDriverName: "custom", Drivers: []string{"probe"}, Output: OutputOptions{Dir: "/tmp/drivers"}},
+			probe: "/tmp/drivers/{target}/{arch}/{kernelrelease}/custom.o",
+		},
+		"no output directory": {
+			opts:   RootOptions{KernelRelease: "5.15.0-1-generic", Architecture: "amd64", Output: OutputOptions{Module: "/tmp/falco.ko"}},
+			module: "/tmp/falco.ko",
+		},
+		"output directory along with output paths": {
+			opts: RootOptions{KernelRelease: "5.15.0-1-generic", Architecture: "amd64", Output: OutputOptions{Dir: "/tmp/drivers", Module: "/tmp/falco.ko"}},
+			err:  "the output directory cannot be set along with the output module or probe paths",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.opts.applyOutputDir()
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.module, test.opts.Output.Module)
+			assert.Equal(t, test.probe, test.opts.Output.Probe)
+		})
+	}
+}
+
+func TestApplyHost(t *testing.T) {
+	host := &builder.Host{Target: builder.TargetTypeUbuntu, KernelRelease: "5.15.0-1-generic", KernelVersion: "59", Architecture: "arm64"}
+
+	ro := &RootOptions{Architecture: "amd64", KernelVersion: "1"}
+	ro.applyHost(host, map[string]bool{})
+	assert.Equal(t, "ubuntu", ro.Target)
+	assert.Equal(t, "5.15.0-1-generic", ro.KernelRelease)
+	assert.Equal(t, "59", ro.KernelVersion)
+	assert.Equal(t, "arm64", ro.Architecture)
+
+	ro = &RootOptions{Target: "centos", KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", KernelVersion: "1"}
+	ro.applyHost(host, map[string]bool{"target": true, "kernelrelease": true, "architecture": true})
+	assert.Equal(t, "centos", ro.Target)
+	assert.Equal(t, "3.10.0-957.el7.x86_64", ro.KernelRelease)
+	assert.Equal(t, "59", ro.KernelVersion)
+	assert.Equal(t, "amd64", ro.Architecture)
+}
+
+func TestCheckAllowedTargets(t *testing.T) {
+	tests := map[string]struct {
+		target  string
+		aliases []string
+		allowed []string
+		err     string
+	}{
+		"any target":         {target: "debian"},
+		"allowed target":     {target: "centos", allowed: []string{"ubuntu", "centos"}},
+		"allowed alias":      {target: "redhat", allowed: []string{"rhel"}},
+		"ubuntu flavor":      {target: "ubuntu", allowed: []string{"ubuntu-generic"}},
+		"missing target":     {allowed: []string{"centos"}},
+		"target not allowed": {target: "centos,debian", allowed: []string{"centos"}, err: "target debian is not allowed, the allowed targets are [centos]"},
+		"requested alias":    {target: "debian", aliases: []string{"mycentos=debian"}, allowed: []string{"mycentos"}, err: "target debian is not allowed, the allowed targets are [mycentos]"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ro := &RootOptions{Target: test.target, TargetAliases: test.aliases}
+			err := ro.checkAllowedTargets(test.allowed)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestKernelConfigLoadedOnce(t *testing.T) {
+	config := "CONFIG_MODULES=y\n"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(config))
+	}))
+	ro := &RootOptions{Target: "centos", Architecture: "amd64", KernelConfig: server.URL + "/config"}
+	assert.NilError(t, ro.loadKernelConfig())
+	// Builds never fetch the kernel config again
+	server.Close()
+	for i := 0; i < 2; i++ {
+		b := ro.toBuild()
+		assert.Assert(t, b.CustomKernelConfig)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(config)), b.KernelConfigData)
+	}
+	assert.Equal(t, 1, requests)
+}
+
+func TestContainerLabels(t *testing.T) {
+	ro := &RootOptions{}
+	labels := ro.containerLabels()
+	assert.Equal(t, 1, len(labels))
+	assert.Assert(t, labels[driverbuilder.RunLabel] != "")
+	assert.Assert(t, ro.containerLabels()[driverbuilder.RunLabel] != labels[driverbuilder.RunLabel])
+
+	ro.Container.Labels = []string{"ci.job=1234", "driverkit.run=ci-1234", "empty="}
+	assert.DeepEqual(t, map[string]string{"ci.job": "1234", "driverkit.run": "ci-1234", "empty": ""}, ro.containerLabels())
+}
+
+// concurrentBuildProcessor records the builds it started, and how many of them were running at the same time.
+type concurrentBuildProcessor struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+	started []string
+	fail    map[string]bool
+}
+
+func (c *concurrentBuildProcessor) String() string {
+	return "concurrent"
+}
+
+func (c *concurrentBuildProcessor) Start(b *builder.Build) error {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.peak {
+		c.peak = c.running
+	}
+	c.started = append(c.started, b.TargetType.String())
+	c.mu.Unlock()
+
+	// Builds modify their own copy of the builder images
+	b.Images.Merge([]builder.Image{{Target: b.TargetType, Name: "builder-" + b.TargetType.String()}})
+	time.Sleep(50 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	if c.fail[b.TargetType.String()] {
+		return errors.New("build failed")
+	}
+	return nil
+}
+
+func TestRunBuildsParallelism(t *testing.T) {
+	prev := configOptions
+	defer func() { configOptions = prev }()
+	configOptions = NewConfigOptions()
+	configOptions.Parallelism = 2
+	configOptions.KeepGoing = true
+
+	images := make(builder.ImagesMap)
+	var builds []*builder.Build
+	for _, target := range []string{"centos", "ubuntu", "debian", "fedora", "photon"} {
+		builds = append(builds, &builder.Build{TargetType: builder.Type(target), Images: images})
+	}
+	bp := &concurrentBuildProcessor{fail: map[string]bool{"debian": true}}
+	var processors int
+	newProcessor := func() driverbuilder.BuildProcessor {
+		bp.mu.Lock()
+		defer bp.mu.Unlock()
+		processors++
+		return bp
+	}
+
+	assert.ErrorContains(t, runBuilds(newProcessor, &RootOptions{}, builds...), "1 of 5 builds failed")
+	assert.Equal(t, 2, processors)
+	assert.Equal(t, 2, bp.peak)
+	assert.Equal(t, 5, len(bp.started))
+	assert.Equal(t, 0, len(images))
+	for _, b := range builds {
+		assert.Equal(t, 1, len(b.Images))
+	}
+}
+
+type gccBuildProcessor struct {
+	errs    map[string]error
+	started []string
+}
+
+func (g *gccBuildProcessor) String() string {
+	return "gcc"
+}
+
+func (g *gccBuildProcessor) Start(b *builder.Build) error {
+	g.started = append(g.started, b.GCCVersion)
+	return g.errs[b.GCCVersion]
+}
+
+func TestStartBuildAutoGCC(t *testing.T) {
+	prev := configOptions
+	defer func() { configOptions = prev }()
+	configOptions = NewConfigOptions()
+
+	imagesList := filepath.Join(t.TempDir(), "images.yaml")
+	assert.NilError(t, os.WriteFile(imagesList, []byte("images:\n  - name: myorg/driverkit-builder-vanilla\n    target: vanilla\n    gcc_versions: [ 5.0.0, 8.0.0, 9.0.0 ]\n"), 0644))
+	newBuild := func() *builder.Build {
+		return &builder.Build{
+			TargetType:    "vanilla",
+			KernelRelease: "4.15.0",
+			Architecture:  "amd64",
+			GCCVersion:    builder.GCCAuto,
+			ImagesListers: []builder.ImagesLister{&builder.FileImagesLister{FilePath: imagesList}},
+		}
+	}
+	compilationErr := &driverbuilder.BuildError{Err: errors.New("compilation failed")}
+
+	// Build failures move on to the next gcc
+	bp := &gccBuildProcessor{errs: map[string]error{"8.0.0": compilationErr}}
+	b := newBuild()
+	assert.NilError(t, startBuild(bp, b))
+	assert.DeepEqual(t, []string{"8.0.0", "9.0.0"}, bp.started)
+	assert.Equal(t, "9.0.0", b.GCCVersion)
+
+	// Build processor failures do not
+	bp = &gccBuildProcessor{errs: map[string]error{"8.0.0": errors.New("pull failed")}}
+	assert.ErrorContains(t, startBuild(bp, newBuild()), "pull failed")
+	assert.DeepEqual(t, []string{"8.0.0"}, bp.started)
+
+	bp = &gccBuildProcessor{errs: map[string]error{"5.0.0": compilationErr, "8.0.0": compilationErr, "9.0.0": compilationErr}}
+	assert.ErrorContains(t, startBuild(bp, newBuild()), "build failed with every gcc version: compilation failed")
+	assert.DeepEqual(t, []string{"8.0.0", "9.0.0", "5.0.0"}, bp.started)
+}
@@ -1,29 +1,86 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/creasty/defaults"
+	"github.com/falcosecurity/driverkit/pkg/signals"
 	"github.com/falcosecurity/driverkit/validate"
 	"github.com/go-playground/validator/v10"
 	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
-var validProcessors = []string{"docker", "kubernetes", "kubernetes-in-cluster"}
-var aliasProcessors = []string{"docker", "k8s", "k8s-ic"}
+var validProcessors = validate.Processors
+var aliasProcessors = []string{"docker", "k8s", "k8s-ic", "local"}
 var configOptions *ConfigOptions
 
 // ConfigOptions represent the persistent configuration flags of driverkit.
 type ConfigOptions struct {
-	ConfigFile string
-	LogLevel   string `validate:"logrus" name:"log level" default:"info"`
-	Timeout    int    `validate:"number,min=30" default:"120" name:"timeout"`
-	ProxyURL   string `validate:"omitempty,proxy" name:"proxy url"`
-	DryRun     bool
+	ConfigFile   string
+	LogLevel     string        `validate:"logrus" name:"log level" default:"info"`
+	LogFormat    string        `validate:"logformat" name:"log format" default:"text"`
+	Timeout      time.Duration `validate:"timeout" default:"2m" name:"timeout"`
+	ProxyURL     string        `validate:"omitempty,proxy" name:"proxy url"`
+	ProxyCheck   bool
+	DryRun       bool
+	DryRunOutput string `validate:"omitempty,oneof=table json" name:"dry run output"`
+	// NoColor disables colored logs, that are only colored on terminals
+	NoColor bool
+	// BuildTimeout bounds the build step of each build, like the compilation in the builder container,
+	// while Timeout still bounds each whole build processor run; zero means no build timeout
+	BuildTimeout time.Duration `validate:"omitempty,timeout" name:"build timeout"`
+	// KeepGoing starts every build, even when some of them fail, reporting the failed ones at the end
+	KeepGoing bool
+	// Parallelism is the maximum number of builds running at the same time
+	Parallelism int `validate:"min=1" default:"1" name:"parallelism"`
+	// RegistryMaxAttempts is the number of attempts of each docker repository search, before skipping the repository
+	RegistryMaxAttempts int `validate:"min=1" default:"3" name:"registry max attempts"`
+	// HeadersMaxAttempts is the number of rounds of attempts of build scripts to download each kernel headers package,
+	// from its url and mirrors
+	HeadersMaxAttempts int `validate:"min=1" default:"3" name:"headers max attempts"`
+	// RegistrySearchLimit is the maximum number of results of each docker repository search
+	RegistrySearchLimit int `validate:"min=1,max=100" default:"100" name:"registry search limit"`
+	// PullPolicy tells when the docker processor pulls the builder image
+	PullPolicy string `validate:"oneof=Always IfNotPresent Never" default:"IfNotPresent" name:"pull policy"`
+	// AllowedTargets are the only targets builds may be requested for, like in shared deployments; any when empty.
+	// They are only read from the config file, see loadAllowedTargets
+	AllowedTargets []string
+	// Processor is the build processor, as called by the user; aliases are normalized to canonical names on validation
+	Processor string `validate:"omitempty,processor" name:"processor"`
 
 	configErrors bool
 }
 
+func init() {
+	validate.V.RegisterStructValidation(ConfigOptionsLevelValidation, ConfigOptions{})
+}
+
+// ConfigOptionsLevelValidation validates the options depending on the processor, once known.
+//
+// It reports an error when `Timeout` is too short for kubernetes pods to start,
+// and warns when `ProxyURL` is set for the `kubernetes-in-cluster` processor.
+func ConfigOptionsLevelValidation(level validator.StructLevel) {
+	opts := level.Current().Interface().(ConfigOptions)
+
+	switch opts.Processor {
+	case "kubernetes", "kubernetes-in-cluster":
+		if opts.Timeout < validate.MinKubernetesTimeout {
+			level.ReportError(opts.Timeout, "timeout", "Timeout", "timeout_with_processor_kubernetes", "")
+		}
+	}
+
+	// The proxy is also used by the build pod, where it may break the in-cluster DNS resolution
+	if opts.Processor == "kubernetes-in-cluster" && opts.ProxyURL != "" {
+		logger.WithField("proxy", opts.ProxyURL).Warn("proxy may prevent the build pod from reaching in-cluster services, like the api server")
+	}
+}
+
 // NewConfigOptions creates an instance of ConfigOptions.
 func NewConfigOptions() *ConfigOptions {
 	o := &ConfigOptions{}
@@ -36,14 +93,116 @@ func NewConfigOptions() *ConfigOptions {
 // Validate validates the ConfigOptions fields.
 func (co *ConfigOptions) Validate() []error {
 	if err := validate.V.Struct(co); err != nil {
-		errors := err.(validator.ValidationErrors)
-		errArr := []error{}
-		for _, e := range errors {
-			// Translate each error one at a time
-			errArr = append(errArr, fmt.Errorf(e.Translate(validate.T)))
+		co.configErrors = true
+		return translateErrors(err)
+	}
+	if co.ProxyCheck && co.ProxyURL != "" {
+		if err := co.checkProxy(); err != nil {
+			co.configErrors = true
+			return []error{err}
 		}
+	}
+	return nil
+}
+
+// SetProcessor sets and validates the build processor, normalizing its aliases.
+//
+// The processor is only known once the command is resolved, after Validate.
+func (co *ConfigOptions) SetProcessor(name string) []error {
+	co.Processor = name
+	if err := validate.V.StructPartial(co, "Processor"); err != nil {
 		co.configErrors = true
-		return errArr
+		return translateErrors(err)
 	}
 	return nil
 }
+
+// translateErrors translates each validation error one at a time.
+func translateErrors(err error) []error {
+	errors := err.(validator.ValidationErrors)
+	errArr := []error{}
+	for _, e := range errors {
+		errArr = append(errArr, fmt.Errorf(e.Translate(validate.T)))
+	}
+	return errArr
+}
+
+// checkProxy verifies that the proxy is reachable, dialing it within the configured timeout.
+//
+// Call it only after validation.
+func (co *ConfigOptions) checkProxy() error {
+	u, err := url.Parse(co.ProxyURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = validate.ProxySchemes[u.Scheme]
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), co.Timeout)
+	if err != nil {
+		return fmt.Errorf("proxy url is not reachable: %w", err)
+	}
+	return conn.Close()
+}
+
+// timeoutValue is a pflag.Value accepting either a duration string (eg: "15m") or an integer number of seconds.
+type timeoutValue time.Duration
+
+func (t *timeoutValue) Set(s string) error {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		*t = timeoutValue(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("timeout must be a duration (eg: 15m) or an integer number of seconds")
+	}
+	*t = timeoutValue(d)
+	return nil
+}
+
+func (t *timeoutValue) String() string {
+	return time.Duration(*t).String()
+}
+
+func (t *timeoutValue) Type() string {
+	return "duration"
+}
+
+// loadAllowedTargets sets AllowedTargets from the config file at filePath,
+// ignoring flags and environment variables, so that builds requests cannot override the policy.
+func (co *ConfigOptions) loadAllowedTargets(filePath string) error {
+	v := viper.New()
+	v.SetConfigFile(filePath)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	co.AllowedTargets = v.GetStringSlice("allowed-targets")
+	return nil
+}
+
+// discoveryContext returns the context governing the discovery of builder images,
+// canceled on standard signals or after the build timeout.
+func discoveryContext() (context.Context, context.CancelFunc) {
+	ctx := signals.WithStandardSignals(context.Background())
+	return context.WithTimeout(ctx, timeout())
+}
+
+// buildTimeout returns the timeout for the build step of each build, merging flags, environment variables and config file values.
+func buildTimeout() time.Duration {
+	var t timeoutValue
+	if err := t.Set(viper.GetString("build-timeout")); err != nil {
+		return configOptions.BuildTimeout
+	}
+	return time.Duration(t)
+}
+
+// timeout returns the timeout for the build, merging flags, environment variables and config file values.
+func timeout() time.Duration {
+	var t timeoutValue
+	if err := t.Set(viper.GetString("timeout")); err != nil {
+		return configOptions.Timeout
+	}
+	return time.Duration(t)
+}
@@ -16,7 +16,15 @@ func NewDockerCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Comman
 		Run: func(c *cobra.Command, args []string) {
 			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
 			if !configOptions.DryRun {
-				if err := driverbuilder.NewDockerBuildProcessor(viper.GetInt("timeout"), viper.GetString("proxy")).Start(rootOpts.toBuild()); err != nil {
+				labels := rootOpts.containerLabels()
+				newProcessor := func() driverbuilder.BuildProcessor {
+					return driverbuilder.NewDockerBuildProcessor(timeout(), viper.GetString("proxy"), driverbuilder.PullPolicy(configOptions.PullPolicy), rootOpts.Container.Prefix, labels)
+				}
+				if err := runBuilds(newProcessor, rootOpts, rootOpts.toBuild().PerTarget()...); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+			} else if configOptions.DryRunOutput != "" {
+				if err := dryRun(c.OutOrStdout(), configOptions.DryRunOutput, rootOpts.toBuild().PerTarget()...); err != nil {
 					logger.WithError(err).Fatal("exiting")
 				}
 			}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/olekukonko/tablewriter"
+)
+
+var validDryRunOutputs = []string{"table", "json"}
+
+// dryRunResult describes the builder image that a build would use.
+type dryRunResult struct {
+	KernelRelease string `json:"kernelrelease"`
+	Target        string `json:"target"`
+	Architecture  string `json:"architecture"`
+	Image         string `json:"image,omitempty"`
+	Match         string `json:"match,omitempty"` // either "target", for target-specific images, or "any"
+	GCCVersion    string `json:"gcc_version,omitempty"`
+	ClangVersion  string `json:"clang_version,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// dryRun resolves the builder image for each build, without ever running them,
+// and writes the results to w in the given output format.
+//
+// It reports an error when the builder image could not be resolved for any of the builds.
+func dryRun(w io.Writer, output string, builds ...*builder.Build) error {
+	ctx, cancel := discoveryContext()
+	defer cancel()
+
+	results := make([]dryRunResult, 0, len(builds))
+	failed := 0
+	for _, b := range builds {
+		res := dryRunResult{
+			KernelRelease: b.KernelRelease,
+			Target:        b.TargetType.String(),
+			Architecture:  b.Architecture,
+		}
+		image, err := b.ResolveImage(ctx)
+		if err != nil {
+			res.Error = err.Error()
+			failed++
+		} else {
+			res.Image = b.GetBuilderImage()
+			res.Match = "target"
+			if image.Target != b.TargetType {
+				res.Match = "any"
+			}
+			res.GCCVersion = b.GCCVersion
+			if image.ClangVersion.NE(semver.Version{}) {
+				res.ClangVersion = image.ClangVersion.String()
+			}
+		}
+		results = append(results, res)
+	}
+
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	default:
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Kernel Release", "Target", "Arch", "Image", "Match", "GCC", "Clang", "Error"})
+		table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+		table.SetCenterSeparator("|")
+		table.SetAutoWrapText(false)
+		for _, res := range results {
+			table.Append([]string{res.KernelRelease, res.Target, res.Architecture, res.Image, res.Match, res.GCCVersion, res.ClangVersion, res.Error})
+		}
+		table.Render()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("could not resolve a builder image for %d of %d builds", failed, len(builds))
+	}
+	return nil
+}
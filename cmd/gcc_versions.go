@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/olekukonko/tablewriter"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// NewGCCVersionsCmd creates the `driverkit gcc-versions` command.
+func NewGCCVersionsCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	gccVersionsCmd := &cobra.Command{
+		Use:   "gcc-versions",
+		Short: "List the gcc versions provided by builder images",
+		Long: "List the gcc versions provided by the builder images found in builder repos for each target and architecture, " +
+			"including the ones of \"any\" target images, in ascending order, without building anything. " +
+			"They can be passed to --gccversion; \"*\" means that an image provides every gcc. " +
+			"Only --target and --architecture are required.",
+		Run: func(c *cobra.Command, args []string) {
+			ctx, cancel := discoveryContext()
+			defer cancel()
+
+			table := tablewriter.NewWriter(c.OutOrStdout())
+			table.SetHeader([]string{"Target", "Arch", "GCC"})
+			table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+			table.SetCenterSeparator("|")
+			for _, b := range rootOpts.toBuild().PerTarget() {
+				// Every gcc is listed, regardless of the requested one
+				b.GCCVersion = ""
+				if err := b.LoadImages(ctx); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+				versions := b.GCCVersionsFor(b.TargetType)
+				gccs := make([]string, 0, len(versions)+1)
+				if b.ProvidesAnyGCC(b.TargetType) {
+					gccs = append(gccs, builder.WildcardGCC)
+				}
+				for _, v := range versions {
+					gccs = append(gccs, v.String())
+				}
+				table.Append([]string{b.TargetType.String(), b.Architecture, strings.Join(gccs, ",")})
+			}
+			table.Render()
+		},
+	}
+	// Add root flags
+	gccVersionsCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return gccVersionsCmd
+}
@@ -1,41 +1,120 @@
 package cmd
 
 import (
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"github.com/olekukonko/tablewriter"
 	logger "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"os"
+	"strings"
 )
 
 // NewImagesCmd creates the `driverkit images` command.
 func NewImagesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	var snapshotFile string
+	var snapshotDigests bool
+	var candidates bool
+	var byName bool
 	imagesCmd := &cobra.Command{
 		Use:   "images",
 		Short: "List builder images",
 		Run: func(c *cobra.Command, args []string) {
 			logger.WithField("processor", c.Name()).Info("listing images")
 			b := rootOpts.toBuild()
-			b.LoadImages()
+			if candidates && b.KernelRelease == "" {
+				logger.Fatal("--candidates requires --kernelrelease")
+			}
+			ctx, cancel := discoveryContext()
+			defer cancel()
+			if err := b.LoadImages(ctx); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
 
 			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{"Image", "Target", "Arch", "GCC"})
+			if byName {
+				table.SetHeader([]string{"Image", "Targets", "Arch", "GCC"})
+			} else {
+				table.SetHeader([]string{"Image", "Target", "Arch", "GCC", "Clang"})
+			}
 			table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
 			table.SetCenterSeparator("|")
 
-			for _, img := range b.Images {
-				data := make([]string, 4)
+			images := b.Images.Sorted()
+			if candidates {
+				images = b.CandidateImages(kernelrelease.FromString(b.KernelRelease))
+			}
+			if byName {
+				for _, named := range builder.MergeImages(images).ByName() {
+					targets := make([]string, 0, len(named.Targets))
+					for _, target := range named.Targets {
+						targets = append(targets, target.String())
+					}
+					gccs := make([]string, 0, len(named.GCCVersions)+1)
+					if named.AnyGCC {
+						gccs = append(gccs, builder.WildcardGCC)
+					}
+					for _, gcc := range named.GCCVersions {
+						gccs = append(gccs, gcc.String())
+					}
+					table.Append([]string{named.Name, strings.Join(targets, ","), b.Architecture, strings.Join(gccs, ",")})
+				}
+				images = nil
+			}
+			for _, img := range images {
+				data := make([]string, 5)
 				data[0] = img.Name
 				data[1] = img.Target.String()
 				data[2] = b.Architecture
-				data[3] = img.GCCVersion.String()
+				data[3] = img.GCCVersionString()
+				if img.ClangVersion.NE(semver.Version{}) {
+					data[4] = img.ClangVersion.String()
+				}
 				table.Append(data)
 			}
 			table.Render() // Send output
+
+			if snapshotFile != "" {
+				snapshot, err := b.ImagesSnapshot(ctx, snapshotDigests)
+				if err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+				if err = snapshot.WriteFile(snapshotFile); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+				logger.WithField("path", snapshotFile).Info("builder images snapshot available")
+			}
 		},
 	}
+	imagesCmd.Flags().StringVar(&snapshotFile, "snapshot-file", snapshotFile, "file where to save the listed builder images, to be used later as builder repo, eg: in air-gapped environments")
+	imagesCmd.Flags().BoolVar(&candidates, "candidates", candidates, "only list the builder images that could build --kernelrelease for --target, honoring their kernel range and the requested gcc and clang versions")
+	imagesCmd.Flags().BoolVar(&byName, "by-name", byName, "list each builder image name once, with all the targets and gcc versions it provides, even when described by multiple entries of the builder repos")
+	imagesCmd.Flags().BoolVar(&snapshotDigests, "snapshot-digests", snapshotDigests, "pin the builder images saved to --snapshot-file to their digests, fetched from their registries")
 	// Add root flags
 	imagesCmd.PersistentFlags().AddFlagSet(rootFlags)
+	imagesCmd.AddCommand(newImagesResolveCmd(rootOpts))
 
 	return imagesCmd
 }
+
+// newImagesResolveCmd creates the `driverkit images resolve` command.
+func newImagesResolveCmd(rootOpts *RootOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resolve",
+		Short: "Print the builder image that would be picked for the build, without running it",
+		Long: "Print the builder image that would be picked for the build, without running it, " +
+			"and whether it is a target-specific image or an \"any\" target one. " +
+			"Use --dryrun-output to choose the output format.",
+		Run: func(c *cobra.Command, args []string) {
+			output := configOptions.DryRunOutput
+			if output == "" {
+				output = "table"
+			}
+			if err := dryRun(c.OutOrStdout(), output, rootOpts.toBuild().PerTarget()...); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+		},
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 
@@ -49,6 +50,10 @@ func NewKubernetesCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Co
 			if err := kubernetesRun(cmd, args, kubefactory, rootOpts); err != nil {
 				logger.WithError(err).Fatal("exiting")
 			}
+		} else if configOptions.DryRunOutput != "" {
+			if err := dryRun(cmd.OutOrStdout(), configOptions.DryRunOutput, rootOpts.toBuild().PerTarget()...); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
 		}
 	}
 
@@ -79,6 +84,20 @@ func kubernetesRun(cmd *cobra.Command, args []string, kubefactory factory.Factor
 		return err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), clientConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, viper.GetInt("timeout"), viper.GetString("proxy"))
-	return buildProcessor.Start(b)
+	scheduling, err := kubernetesOptions.podScheduling()
+	if err != nil {
+		return err
+	}
+	if errs := kubernetesOptions.Resources.Validate(); errs != nil {
+		for _, err := range errs {
+			logger.WithError(err).Error("error validating pod resources")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+	resources := kubernetesOptions.Resources.podResources()
+
+	newProcessor := func() driverbuilder.BuildProcessor {
+		return driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), clientConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, timeout(), viper.GetString("proxy"), scheduling, resources)
+	}
+	return runBuilds(newProcessor, rootOpts, b.PerTarget()...)
 }
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/kubernetes/factory"
 	logger "github.com/sirupsen/logrus"
@@ -39,6 +41,10 @@ func NewKubernetesInClusterCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet)
 			if err = kubernetesInClusterRun(cmd, args, config, rootOpts); err != nil {
 				logger.WithError(err).Fatal("exiting")
 			}
+		} else if configOptions.DryRunOutput != "" {
+			if err := dryRun(cmd.OutOrStdout(), configOptions.DryRunOutput, rootOpts.toBuild().PerTarget()...); err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
 		}
 	}
 
@@ -53,7 +59,20 @@ func kubernetesInClusterRun(_ *cobra.Command, _ []string, kubeConfig *rest.Confi
 		return err
 	}
 
-	buildProcessor := driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), kubeConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, viper.GetInt("timeout"), viper.GetString("proxy"))
+	scheduling, err := kubernetesOptions.podScheduling()
+	if err != nil {
+		return err
+	}
+	if errs := kubernetesOptions.Resources.Validate(); errs != nil {
+		for _, err := range errs {
+			logger.WithError(err).Error("error validating pod resources")
+		}
+		return fmt.Errorf("exiting for validation errors")
+	}
+	resources := kubernetesOptions.Resources.podResources()
 
-	return buildProcessor.Start(b)
+	newProcessor := func() driverbuilder.BuildProcessor {
+		return driverbuilder.NewKubernetesBuildProcessor(kc.CoreV1(), kubeConfig, kubernetesOptions.RunAsUser, kubernetesOptions.Namespace, kubernetesOptions.ImagePullSecret, timeout(), viper.GetString("proxy"), scheduling, resources)
+	}
+	return runBuilds(newProcessor, rootOpts, b.PerTarget()...)
 }
@@ -1,6 +1,15 @@
 package cmd
 
-import flag "github.com/spf13/pflag"
+import (
+	"fmt"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/validate"
+	"github.com/go-playground/validator/v10"
+	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
 
 var kubernetesOptions = &KubeOptions{}
 
@@ -8,10 +17,97 @@ type KubeOptions struct {
 	RunAsUser       int64  `json:"runAsUser,omitempty" protobuf:"varint,2,opt,name=runAsUser" default:"0"`
 	Namespace       string `validate:"required" name:"namespace" default:"default"`
 	ImagePullSecret string `validate:"omitempty" name:"image-pull-secret" default:""`
+	// NodeSelector, Tolerations and Affinity constrain the nodes the build pods are scheduled on, see podScheduling
+	NodeSelector []string
+	Tolerations  []string
+	Affinity     string
+	Resources    PodResourcesOptions
+}
+
+// PodResourcesOptions are the resource requests and limits of the builder container of the build pods.
+type PodResourcesOptions struct {
+	CPURequest    string `default:"1000m" validate:"quantity" name:"cpu request"`
+	MemoryRequest string `default:"2000Mi" validate:"quantity" name:"memory request"`
+	CPULimit      string `default:"4" validate:"quantity" name:"cpu limit"`
+	MemoryLimit   string `default:"4G" validate:"quantity" name:"memory limit"`
 }
 
 func addKubernetesFlags(flags *flag.FlagSet) {
 	flags.StringVarP(&kubernetesOptions.Namespace, "namespace", "n", "default", "If present, the namespace scope for the pods and its config ")
 	flags.Int64Var(&kubernetesOptions.RunAsUser, "run-as-user", 0, "Pods runner user")
 	flags.StringVar(&kubernetesOptions.ImagePullSecret, "image-pull-secret", "", "ImagePullSecret")
+	flags.StringSliceVar(&kubernetesOptions.NodeSelector, "node-selector", nil, "list of node labels, in the <key>=<value> form, the build pods must be scheduled on, like the ones of the build architecture. eg: --node-selector kubernetes.io/arch=arm64")
+	flags.StringSliceVar(&kubernetesOptions.Tolerations, "toleration", nil, "list of tolerations of the build pods, in the <key>[=<value>][:<effect>] form: without a value, any value of the taint key is tolerated, and, without an effect, any effect. eg: --toleration arch=arm64:NoSchedule")
+	flags.StringVar(&kubernetesOptions.Affinity, "affinity", "", "affinity of the build pods, as json or yaml, like the affinity field of pod specs")
+	flags.StringVar(&kubernetesOptions.Resources.CPURequest, "cpu-request", "1000m", "cpu request of the build pods")
+	flags.StringVar(&kubernetesOptions.Resources.MemoryRequest, "memory-request", "2000Mi", "memory request of the build pods")
+	flags.StringVar(&kubernetesOptions.Resources.CPULimit, "cpu-limit", "4", "cpu limit of the build pods")
+	flags.StringVar(&kubernetesOptions.Resources.MemoryLimit, "memory-limit", "4G", "memory limit of the build pods: builds exceeding it are killed")
+}
+
+// Validate validates the resources, returning an error for each invalid quantity,
+// or for a request greater than its limit.
+func (ro *PodResourcesOptions) Validate() []error {
+	if err := validate.V.Struct(ro); err != nil {
+		errors := err.(validator.ValidationErrors)
+		errArr := []error{}
+		for _, e := range errors {
+			// Translate each error one at a time
+			errArr = append(errArr, fmt.Errorf(e.Translate(validate.T)))
+		}
+		return errArr
+	}
+	resources := ro.podResources()
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		request, limit := resources.Requests[name], resources.Limits[name]
+		if request.Cmp(limit) > 0 {
+			return []error{fmt.Errorf("%s request %s must not be greater than its limit %s", name, request.String(), limit.String())}
+		}
+	}
+	return nil
+}
+
+// podResources returns the resource requirements of the build pods.
+// It must only be called on validated options.
+func (ro *PodResourcesOptions) podResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(ro.CPURequest),
+			corev1.ResourceMemory: resource.MustParse(ro.MemoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(ro.CPULimit),
+			corev1.ResourceMemory: resource.MustParse(ro.MemoryLimit),
+		},
+	}
+}
+
+// podScheduling returns the scheduling constraints of the build pods.
+func (ko *KubeOptions) podScheduling() (driverbuilder.PodScheduling, error) {
+	var scheduling driverbuilder.PodScheduling
+	for _, s := range ko.NodeSelector {
+		key, value, err := driverbuilder.ParseNodeSelector(s)
+		if err != nil {
+			return scheduling, err
+		}
+		if scheduling.NodeSelector == nil {
+			scheduling.NodeSelector = make(map[string]string)
+		}
+		scheduling.NodeSelector[key] = value
+	}
+	for _, s := range ko.Tolerations {
+		t, err := driverbuilder.ParseToleration(s)
+		if err != nil {
+			return scheduling, err
+		}
+		scheduling.Tolerations = append(scheduling.Tolerations, t)
+	}
+	if ko.Affinity != "" {
+		affinity, err := driverbuilder.ParseAffinity(ko.Affinity)
+		if err != nil {
+			return scheduling, err
+		}
+		scheduling.Affinity = affinity
+	}
+	return scheduling, nil
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// NewLocalCmd creates the `driverkit local` command.
+func NewLocalCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	localCmd := &cobra.Command{
+		Use:   "local",
+		Short: "Build Falco kernel modules and eBPF probes directly on the host, using the locally installed toolchain.",
+		Run: func(c *cobra.Command, args []string) {
+			logger.WithField("processor", c.Name()).Info("driver building, it will take a few seconds")
+			if !configOptions.DryRun {
+				newProcessor := func() driverbuilder.BuildProcessor {
+					return driverbuilder.NewLocalBuildProcessor(timeout(), viper.GetString("proxy"))
+				}
+				if err := runBuilds(newProcessor, rootOpts, rootOpts.toBuild().PerTarget()...); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+			} else if configOptions.DryRunOutput != "" {
+				b := rootOpts.toBuild()
+				lister, err := driverbuilder.NewLocalImagesLister()
+				if err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+				b.ImagesListers = []builder.ImagesLister{lister}
+				if err := dryRun(c.OutOrStdout(), configOptions.DryRunOutput, b.PerTarget()...); err != nil {
+					logger.WithError(err).Fatal("exiting")
+				}
+			}
+		},
+	}
+	// Add root flags
+	localCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return localCmd
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// prepullImage is a builder image to be pulled for an architecture.
+type prepullImage struct {
+	Name string
+	Arch string
+}
+
+// NewPrepullCmd creates the `driverkit prepull` command.
+func NewPrepullCmd(rootOpts *RootOptions, rootFlags *pflag.FlagSet) *cobra.Command {
+	prepullCmd := &cobra.Command{
+		Use:   "prepull",
+		Short: "Pull the builder images needed by the builds against a docker daemon",
+		Long: "Pull the builder images needed by the builds against a docker daemon, without building anything, " +
+			"like to warm the local docker cache before many builds. " +
+			"With --kernelrelease, the builder image picked for each target is pulled, " +
+			"otherwise every builder image providing the requested gcc and clang versions for each target. " +
+			"Each image is pulled once, according to --pull-policy.",
+		Run: func(c *cobra.Command, args []string) {
+			ctx, cancel := discoveryContext()
+			defer cancel()
+
+			images, err := prepullImages(ctx, rootOpts.toBuild().PerTarget())
+			if err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+			labels := rootOpts.containerLabels()
+			bp := driverbuilder.NewDockerBuildProcessor(timeout(), viper.GetString("proxy"), driverbuilder.PullPolicy(configOptions.PullPolicy), rootOpts.Container.Prefix, labels)
+			pulled, total, err := prepull(ctx, bp, images)
+			if err != nil {
+				logger.WithError(err).Fatal("exiting")
+			}
+			logger.
+				WithField("images", len(images)).
+				WithField("pulled", pulled).
+				WithField("size", units.HumanSize(float64(total))).
+				Info("builder images prepulled")
+		},
+	}
+	// Add root flags
+	prepullCmd.PersistentFlags().AddFlagSet(rootFlags)
+
+	return prepullCmd
+}
+
+// imagePuller pulls builder images, see driverbuilder.DockerBuildProcessor.Pull.
+type imagePuller interface {
+	Pull(ctx context.Context, image string, arch string) (bool, int64, error)
+}
+
+// prepull pulls the images in order, returning how many of them were pulled
+// and the total size of the layers downloaded to pull them.
+func prepull(ctx context.Context, puller imagePuller, images []prepullImage) (int, int64, error) {
+	var pulled int
+	var total int64
+	for i, img := range images {
+		entry := logger.
+			WithField("image", img.Name).
+			WithField("arch", img.Arch).
+			WithField("progress", fmt.Sprintf("%d/%d", i+1, len(images)))
+		entry.Info("pulling builder image")
+		ok, size, err := puller.Pull(ctx, img.Name, img.Arch)
+		if err != nil {
+			return pulled, total, fmt.Errorf("error pulling builder image %s for architecture %s: %w", img.Name, img.Arch, err)
+		}
+		if !ok {
+			entry.Info("builder image already present")
+			continue
+		}
+		pulled++
+		total += size
+		entry.WithField("size", units.HumanSize(float64(size))).Info("builder image pulled")
+	}
+	return pulled, total, nil
+}
+
+// prepullImages returns the distinct builder images needed by the builds, in order:
+// the one picked for each build when its kernel release is known, or the ones it could pick otherwise.
+func prepullImages(ctx context.Context, builds []*builder.Build) ([]prepullImage, error) {
+	seen := make(map[prepullImage]bool)
+	images := make([]prepullImage, 0)
+	add := func(b *builder.Build, name string) {
+		img := prepullImage{Name: b.RegistryMirror.Rewrite(name), Arch: b.Architecture}
+		if !seen[img] {
+			seen[img] = true
+			images = append(images, img)
+		}
+	}
+	for _, b := range builds {
+		if b.KernelRelease != "" {
+			if _, err := b.ResolveImage(ctx); err != nil {
+				return nil, fmt.Errorf("error resolving the builder image for target %s: %w", b.TargetType, err)
+			}
+			add(b, b.GetBuilderImage())
+			continue
+		}
+		if err := b.LoadImages(ctx); err != nil {
+			return nil, fmt.Errorf("error loading the builder images for target %s: %w", b.TargetType, err)
+		}
+		for _, img := range b.Images.Sorted() {
+			if img.Target == b.TargetType || img.Target == "any" {
+				add(b, img.Name)
+			}
+		}
+	}
+	return images, nil
+}
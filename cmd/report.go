@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gopkg.in/yaml.v3"
+)
+
+// Build report statuses.
+const (
+	buildSucceeded = "success"
+	buildFailed    = "failure"
+	buildSkipped   = "skipped" // not started, since a previous build failed without keep going
+)
+
+// buildResult describes the outcome of a build in the build report.
+type buildResult struct {
+	Target        string  `json:"target" yaml:"target"`
+	KernelRelease string  `json:"kernelrelease" yaml:"kernelrelease"`
+	Architecture  string  `json:"architecture" yaml:"architecture"`
+	Image         string  `json:"image,omitempty" yaml:"image,omitempty"`
+	GCCVersion    string  `json:"gcc_version,omitempty" yaml:"gcc_version,omitempty"`
+	Status        string  `json:"status" yaml:"status"`
+	Duration      float64 `json:"duration_seconds" yaml:"duration_seconds"`
+	Module        string  `json:"module,omitempty" yaml:"module,omitempty"` // only for successful builds
+	Probe         string  `json:"probe,omitempty" yaml:"probe,omitempty"`   // only for successful builds
+	Error         string  `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// newBuildResult returns the result of the build, given the error it returned, if any, and how long it took.
+func newBuildResult(b *builder.Build, err error, elapsed time.Duration) buildResult {
+	image, _ := b.ResolvedBuilderImage()
+	res := buildResult{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		Architecture:  b.Architecture,
+		Image:         image,
+		GCCVersion:    b.GCCVersion,
+		Status:        buildSucceeded,
+		Duration:      elapsed.Seconds(),
+	}
+	if err != nil {
+		res.Status = buildFailed
+		res.Error = err.Error()
+		return res
+	}
+	if b.BuildsModule() {
+		res.Module = b.OutputFilePath(b.ModuleFilePath)
+	}
+	if b.BuildsProbe() {
+		res.Probe = b.OutputFilePath(b.ProbeFilePath)
+	}
+	return res
+}
+
+// skippedBuildResult returns the result of a build that was never started.
+func skippedBuildResult(b *builder.Build) buildResult {
+	return buildResult{
+		Target:        b.TargetType.String(),
+		KernelRelease: b.KernelRelease,
+		Architecture:  b.Architecture,
+		GCCVersion:    b.GCCVersion,
+		Status:        buildSkipped,
+	}
+}
+
+// writeReport writes the results of the builds to the report file, as yaml when its extension is ".yaml" or ".yml",
+// and as json otherwise.
+func writeReport(filePath string, results []buildResult) error {
+	var data []byte
+	var err error
+	switch filepath.Ext(filePath) {
+	case ".yaml", ".yml":
+		data, err = yaml.Marshal(results)
+	default:
+		data, err = json.MarshalIndent(results, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/falcosecurity/driverkit/pkg/version"
+	"github.com/falcosecurity/driverkit/validate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
@@ -21,25 +22,60 @@ import (
 
 func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c *cobra.Command, args []string) error {
 	return func(c *cobra.Command, args []string) error {
+		// Processors are the root subcommands, possibly called by their aliases
+		for _, processor := range validProcessors {
+			if c.Name() == processor && c.Parent() == c.Root() {
+				for _, err := range configOptions.SetProcessor(c.CalledAs()) {
+					logger.WithError(err).Error("error validating config options")
+				}
+			}
+		}
 		// Early exit if detect some error into config flags
 		if configOptions.configErrors {
 			return fmt.Errorf("exiting for validation errors")
 		}
+		// Options set by flags, environment variables or config file values are never detected on the running host
+		explicit := make(map[string]bool)
+		for _, name := range []string{"target", "kernelrelease", "kernelversion", "architecture"} {
+			explicit[name] = viper.IsSet(name)
+		}
 		// Merge environment variables or config file values into the RootOptions instance
 		skip := map[string]bool{ // do not merge these
-			"config":   true,
-			"timeout":  true,
-			"loglevel": true,
-			"dryrun":   true,
-			"proxy":    true,
+			"config":                true,
+			"timeout":               true,
+			"build-timeout":         true,
+			"loglevel":              true,
+			"log-format":            true,
+			"no-color":              true,
+			"dryrun":                true,
+			"dryrun-output":         true,
+			"proxy":                 true,
+			"proxy-check":           true,
+			"registry-max-attempts": true,
+			"registry-search-limit": true,
+			"headers-max-attempts":  true,
+			"keep-going":            true,
+			"parallelism":           true,
+			"pull-policy":           true,
 		}
 		nested := map[string]string{ // handle nested options in config file
-			"output-module": "output.module",
-			"output-probe":  "output.probe",
+			"output-module":       "output.module",
+			"output-probe":        "output.probe",
+			"output-dir":          "output.dir",
+			"registry-user":       "registry.user",
+			"registry-password":   "registry.password",
+			"registry-token":      "registry.token",
+			"registry-auth":       "registry.auths",
+			"registry-mirror":     "registry.mirror",
+			"images-cache-file":   "images-cache.file",
+			"images-cache-ttl":    "images-cache.ttl",
+			"images-cache-bypass": "images-cache.bypass",
+			"container-prefix":    "container.prefix",
+			"container-label":     "container.labels",
 		}
 		rootCommand.c.Flags().VisitAll(func(f *pflag.Flag) {
 			if name := f.Name; !skip[name] {
-				if name == "kernelurls" {
+				if strings.HasSuffix(f.Value.Type(), "Slice") {
 					// Slice types need special treatment when used as flags. If we call 'Set(name, value)',
 					// rather than replace, it appends. Since viper will already have the cli options set
 					// if supplied, we only need this step if rootCommand doesn't already have them e.g.
@@ -48,15 +84,18 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 						return
 					}
 					value := viper.GetStringSlice(name)
+					if nestedName, ok := nested[name]; ok && len(value) == 0 {
+						value = viper.GetStringSlice(nestedName)
+					}
 					if len(value) != 0 {
 						strValue := strings.Join(value, ",")
 						rootCommand.c.Flags().Set(name, strValue)
 					}
 				} else {
 					value := viper.GetString(name)
-					if value == "" {
+					if value == "" || !viper.IsSet(name) {
 						// fallback to nested options in config file, if any
-						if nestedName, ok := nested[name]; ok {
+						if nestedName, ok := nested[name]; ok && viper.IsSet(nestedName) {
 							value = viper.GetString(nestedName)
 						}
 					}
@@ -71,14 +110,54 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 		// Avoid sensitive info into default values help line
 		rootCommand.StripSensitive()
 
+		// Detect the running host, if requested
+		if rootOpts.Autodetect {
+			host, err := builder.DetectHost(rootOpts.targetAliases())
+			if err != nil {
+				logger.WithError(err).Error("error detecting the running host")
+				return fmt.Errorf("exiting for validation errors")
+			}
+			rootOpts.applyHost(host, explicit)
+		}
+
+		// We just use canonical target names internally
+		targets := rootOpts.targets()
+		aliases := rootOpts.targetAliases()
+		for i, target := range targets {
+			targets[i] = aliases.Resolve(target).String()
+		}
+
 		// We just use ubuntu internally
-		if strings.HasPrefix(rootOpts.Target, "ubuntu") {
-			rootOpts.Target = "ubuntu"
+		for i, target := range targets {
+			if strings.HasPrefix(target, "ubuntu") {
+				targets[i] = "ubuntu"
+			}
+		}
+		rootOpts.Target = strings.Join(targets, ",")
+
+		// We just use deb architecture names internally
+		if arch, err := kernelrelease.ParseArchitecture(rootOpts.Architecture); err == nil {
+			rootOpts.Architecture = arch.String()
 		}
 
 		// Do not block root or help command to exec disregarding the root flags validity
 		if c.Root() != c && c.Name() != "help" && c.Name() != "__complete" && c.Name() != "__completeNoDesc" && c.Name() != "completion" {
-			if errs := rootOpts.Validate(); errs != nil {
+			// Enforce the allowed targets before anything else, like loading builder images
+			if err := rootOpts.checkAllowedTargets(configOptions.AllowedTargets); err != nil {
+				logger.WithError(err).Error("error validating build options")
+				return fmt.Errorf("exiting for validation errors")
+			}
+			validateFunc := rootOpts.Validate
+			if discoveryCommands[c.Name()] {
+				validateFunc = rootOpts.ValidateDiscovery
+			} else {
+				// Lay the drivers out in the output directory, if any
+				if err := rootOpts.applyOutputDir(); err != nil {
+					logger.WithError(err).Error("error validating build options")
+					return fmt.Errorf("exiting for validation errors")
+				}
+			}
+			if errs := validateFunc(); errs != nil {
 				for _, err := range errs {
 					logger.WithError(err).Error("error validating build options")
 				}
@@ -90,6 +169,12 @@ func persistentValidateFunc(rootCommand *RootCmd, rootOpts *RootOptions) func(c
 	}
 }
 
+// discoveryCommands only discover builder images, not building anything.
+var discoveryCommands = map[string]bool{
+	"gcc-versions": true,
+	"prepull":      true,
+}
+
 // RootCmd wraps the main cobra.Command.
 type RootCmd struct {
 	c *cobra.Command
@@ -129,29 +214,81 @@ func NewRootCmd() *RootCmd {
 
 	flags.StringVarP(&configOptions.ConfigFile, "config", "c", configOptions.ConfigFile, "config file path (default $HOME/.driverkit.yaml if exists)")
 	flags.StringVarP(&configOptions.LogLevel, "loglevel", "l", configOptions.LogLevel, "log level")
-	flags.IntVar(&configOptions.Timeout, "timeout", configOptions.Timeout, "timeout in seconds")
+	flags.StringVar(&configOptions.LogFormat, "log-format", configOptions.LogFormat, "log format, one of [text,json]")
+	flags.BoolVar(&configOptions.NoColor, "no-color", configOptions.NoColor, "disable colored logs; logs are colored only when written to a terminal, and the NO_COLOR environment variable disables colors too")
+	flags.Var((*timeoutValue)(&configOptions.Timeout), "timeout", "timeout of the build, either as a duration (eg: 15m) or in seconds")
+	flags.Var((*timeoutValue)(&configOptions.BuildTimeout), "build-timeout", "timeout of the build step of each build, like the compilation in the builder container, either as a duration (eg: 10m) or in seconds; the timeout option still bounds each whole build. If not provided, only the timeout option applies")
 	flags.BoolVar(&configOptions.DryRun, "dryrun", configOptions.DryRun, "do not actually perform the action")
+	flags.BoolVar(&configOptions.KeepGoing, "keep-going", configOptions.KeepGoing, "when building for multiple targets, go on with the next builds when one fails, reporting all the failed ones at the end")
+	flags.IntVar(&configOptions.Parallelism, "parallelism", configOptions.Parallelism, "when building for multiple targets, maximum number of builds running at the same time, each one with its own build processor")
+	flags.StringVar(&configOptions.DryRunOutput, "dryrun-output", configOptions.DryRunOutput, "on dry run, print the builder image resolved for the build, one of ["+strings.Join(validDryRunOutputs, ",")+"]")
 	flags.StringVar(&configOptions.ProxyURL, "proxy", configOptions.ProxyURL, "the proxy to use to download data")
-
-	flags.StringVar(&rootOpts.Output.Module, "output-module", rootOpts.Output.Module, "filepath where to save the resulting kernel module")
-	flags.StringVar(&rootOpts.Output.Probe, "output-probe", rootOpts.Output.Probe, "filepath where to save the resulting eBPF probe")
-	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver, one of "+kernelrelease.SupportedArchs.String())
+	flags.BoolVar(&configOptions.ProxyCheck, "proxy-check", configOptions.ProxyCheck, "check that the proxy is reachable before starting the build")
+	flags.StringVar(&configOptions.PullPolicy, "pull-policy", configOptions.PullPolicy, "when the docker processor pulls the builder image, one of [Always,IfNotPresent,Never]: IfNotPresent only pulls it when not present locally for the target architecture, Never fails when it is not, like to verify that the required images are pre-loaded in air-gapped environments")
+	flags.IntVar(&configOptions.RegistrySearchLimit, "registry-search-limit", configOptions.RegistrySearchLimit, "maximum number of results of each builder repo search, up to 100; when hit, results are completed with the registry catalog, if available")
+	flags.IntVar(&configOptions.RegistryMaxAttempts, "registry-max-attempts", configOptions.RegistryMaxAttempts, "number of attempts, with exponential backoff, of each builder repo search before skipping it")
+	flags.IntVar(&configOptions.HeadersMaxAttempts, "headers-max-attempts", configOptions.HeadersMaxAttempts, "number of rounds of attempts, with exponential backoff, of the build script to download each kernel headers package from its url, then from each --headers-mirror, before failing the build")
+
+	flags.StringVar(&rootOpts.Output.Module, "output-module", rootOpts.Output.Module, "filepath where to save the resulting kernel module, that can contain the {target}, {kernelrelease}, {arch} and {gcc} placeholders")
+	flags.StringVar(&rootOpts.Output.Probe, "output-probe", rootOpts.Output.Probe, "filepath where to save the resulting eBPF probe, that can contain the {target}, {kernelrelease}, {arch} and {gcc} placeholders")
+	flags.StringVar(&rootOpts.Output.Dir, "output-dir", rootOpts.Output.Dir, "directory where to save the resulting drivers, under the {target}/{arch}/{kernelrelease} subdirectory and named after the kernel module driver name, in place of the output module and probe paths")
+	flags.StringSliceVar(&rootOpts.Drivers, "drivers", rootOpts.Drivers, "list of the drivers to build, among module and probe, skipping the compilation of the other one even when its output path is set, like in a configuration file; by default, the drivers with an output path are built")
+	flags.StringVar(&rootOpts.Architecture, "architecture", runtime.GOARCH, "target architecture for the built driver, one of "+kernelrelease.SupportedArchs.Describe())
 	flags.StringVar(&rootOpts.DriverVersion, "driverversion", rootOpts.DriverVersion, "driver version as a git commit hash or as a git tag")
+	flags.BoolVar(&rootOpts.Autodetect, "autodetect", rootOpts.Autodetect, "detect the target, kernel release, kernel version and architecture of the running host, from "+builder.HostOSReleaseFile+" and uname, to build the drivers for it; the ones explicitly set are kept")
 	flags.StringVar(&rootOpts.KernelVersion, "kernelversion", rootOpts.KernelVersion, "kernel version to build the module for, it's the numeric value after the hash when you execute 'uname -v'")
 	flags.StringVar(&rootOpts.KernelRelease, "kernelrelease", rootOpts.KernelRelease, "kernel release to build the module for, it can be found by executing 'uname -v'")
-	flags.StringVarP(&rootOpts.Target, "target", "t", rootOpts.Target, "the system to target the build for, one of ["+strings.Join(targets, ",")+"]")
+	flags.StringVarP(&rootOpts.Target, "target", "t", rootOpts.Target, "the system to target the build for, one of ["+strings.Join(targets, ",")+"], or a comma separated list of them")
+	flags.StringSliceVar(&rootOpts.TargetAliases, "target-alias", rootOpts.TargetAliases, "list of target aliases, in the <alias>=<target> form, resolved to their target both in the target flag and in builder images names, in addition to the default ones (eg: rhel=redhat). eg: --target-alias centos-stream=centos")
 	flags.StringVar(&rootOpts.KernelConfigData, "kernelconfigdata", rootOpts.KernelConfigData, "base64 encoded kernel config data: in some systems it can be found under the /boot directory, in other it is gzip compressed under /proc")
+	flags.StringVar(&rootOpts.KernelConfig, "kernelconfig", rootOpts.KernelConfig, "path or http(s) url of the kernel config (.config) to build the drivers with, possibly gzip compressed like /proc/config.gz, in place of the kernel config data and of the one of the kernel headers of any target, eg: for custom kernels")
 	flags.StringVar(&rootOpts.ModuleDeviceName, "moduledevicename", rootOpts.ModuleDeviceName, "kernel module device name (the default is falco, so the device will be under /dev/falco*)")
 	flags.StringVar(&rootOpts.ModuleDriverName, "moduledrivername", rootOpts.ModuleDriverName, "kernel module driver name, i.e. the name you see when you check installed modules via lsmod")
 	flags.StringVar(&rootOpts.BuilderImage, "builderimage", rootOpts.BuilderImage, "docker image to be used to build the kernel module and eBPF probe. If not provided, an automatically selected image will be used.")
-	flags.StringSliceVar(&rootOpts.BuilderRepos, "builderrepo", rootOpts.BuilderRepos, "list of docker repositories or yaml file (absolute path) containing builder images index with the format 'images: [ { target:<target>, name:<image-name>, gcc_versions: [ <gcc-tag> ] },...]', in descending priority order. Used to search for builder images. eg: --builderrepo myorg/driverkit --builderrepo falcosecurity/driverkit --builderrepo '/path/to/my/index.yaml'.")
-	flags.StringVar(&rootOpts.GCCVersion, "gccversion", rootOpts.GCCVersion, "enforce a specific gcc version for the build")
+	flags.BoolVar(&rootOpts.BuilderImageDigest, "builderimage-digest", rootOpts.BuilderImageDigest, "pin the automatically selected builder image to the digest its tag currently points to, logged and used in place of the tag, also when printing or saving the resolved image, so that the build can be exactly reproduced")
+	flags.BoolVar(&rootOpts.BuilderImageAny, "builderimage-prefer-any", rootOpts.BuilderImageAny, "prefer \"any\" target builder images over target-specific ones, even when a target-specific image provides the gcc; meant to validate \"any\" target images, target-specific ones are preferred by default")
+	flags.StringVar(&rootOpts.BuilderImageName, "builderimage-name", rootOpts.BuilderImageName, "name of the only builder image to be automatically selected, with or without tag, still picking the best gcc for the kernel release among the ones it provides in builder repos. eg: --builderimage-name myorg/driverkit-builder-centos")
+	flags.BoolVar(&rootOpts.BuilderImageVerify, "builderimage-verify", rootOpts.BuilderImageVerify, "inspect the automatically selected builder image in its registry before building, falling back at the next available one when it is missing")
+	flags.StringSliceVar(&rootOpts.BuilderImagesPin, "builderimage-pin", rootOpts.BuilderImagesPin, "list of builder images pinned to their digest, in the <target>_<gcc>=<name>@<digest> form, used in place of the ones found in builder repos for the same target and gcc. eg: --builderimage-pin centos_4.8.5=falcosecurity/driverkit-builder-centos-x86_64_gcc4.8.5@sha256:<hex>")
+	flags.StringSliceVar(&rootOpts.BuilderRepos, "builderrepo", rootOpts.BuilderRepos, "list of docker repositories or yaml file (absolute path) containing builder images index with the format 'images: [ { target:<target>, name:<image-name>, gcc_versions: [ <gcc-tag> ] },...]', in descending priority order. Used to search for builder images. eg: --builderrepo myorg/driverkit --builderrepo falcosecurity/driverkit --builderrepo '/path/to/my/index.yaml'. Repositories prefixed with 'oci://' are listed through the registry tags API, the ones prefixed with 'oci-layout://' are read from an OCI image layout directory.")
+	flags.StringSliceVar(&rootOpts.BuilderReposSums, "builderrepo-checksum", rootOpts.BuilderReposSums, "list of expected sha256 checksums of yaml images list builder repos, files or urls, in the <repo>=sha256:<hex> form: each file is verified before being parsed, failing on mismatch, like when it was tampered with or truncated; included files are not verified. eg: --builderrepo-checksum '/path/to/my/index.yaml=sha256:<hex>'")
+	flags.StringSliceVar(&rootOpts.BuilderReposPrio, "builderrepo-priority", rootOpts.BuilderReposPrio, "list of builder repos priorities, in the <repo>=<priority> form: when multiple builder repos provide the same image, the one with the highest priority wins, regardless of the builderrepo order. Repos without priority have priority 0. eg: --builderrepo-priority '/path/to/my/index.yaml=10'")
+	flags.BoolVar(&rootOpts.BuilderReposStrict, "builderrepo-strict", rootOpts.BuilderReposStrict, "fail when a yaml builder images index defines an unknown target or field, instead of skipping it with a warning")
+	flags.BoolVar(&rootOpts.BuilderReposEmbedded, "builderrepo-embedded", rootOpts.BuilderReposEmbedded, "when no builder repo is given, or none of them provides any builder image, like when they cannot be reached, use the official builder images known by this driverkit version, listed in its embedded images list")
+	flags.StringVar(&rootOpts.BuilderPattern, "builderpattern", rootOpts.BuilderPattern, "go template rendering the regex used to match builder images names in docker repositories, receiving .Target and .Arch and providing 'target' and 'gccVers' named groups. If not provided, the default falcosecurity builder images naming is used.")
+	flags.StringVar(&rootOpts.BuilderExclude, "builderrepo-exclude", rootOpts.BuilderExclude, "regex matching the names of builder images to ignore, even when found in builder repos, like deprecated ones (eg: '-deprecated$')")
+	flags.StringVar(&rootOpts.GCCVersion, "gccversion", rootOpts.GCCVersion, "enforce a specific gcc version, a major (and minor) gcc version picking the highest available one (eg: '9'), a gcc version range (eg: '>=9.0.0 <11.0.0'), or a comma separated list of them, allowing any of them (eg: '8,9'), for the build; 'auto' tries the available gcc versions, starting from the best-match one, until the build succeeds")
+	flags.StringSliceVar(&rootOpts.GCCDeny, "gcc-deny", rootOpts.GCCDeny, "list of gcc versions, major (and minor) gcc versions or gcc version ranges never used for the build, like the ones known to mis-compile modules; builder images only providing a denied gcc are skipped. eg: --gcc-deny 9.3.0 --gcc-deny '>=12.0.0 <12.2.0'")
+	flags.StringVar(&rootOpts.MinGCC, "min-gcc", rootOpts.MinGCC, "minimum gcc version, or major (and minor) gcc version, of the build, enforced as a policy: builder images only providing lower gcc versions are skipped, and the build fails when the target could only be built with one of them. eg: --min-gcc 8")
+	flags.StringVar(&rootOpts.GCCRulesFile, "gcc-rules", rootOpts.GCCRulesFile, "yaml file mapping kernel releases to the gcc version to build them with, used when gccversion is not provided, in the format 'rules: [ { kernel_release: <regex>, kernel_version: <range>, gcc: <gcc-version> },...]'. The first matching rule wins; when none matches, the gcc version is picked as usual")
+	flags.BoolVar(&rootOpts.VerifyGCC, "verify-gcc", rootOpts.VerifyGCC, "verify, in the build environment before compiling the kernel module, that the installed gcc is the gcc version selected for the build, failing the build on mismatch, like when a builder images list drifted from the actual images")
+	flags.BoolVar(&rootOpts.GCCNearest, "gcc-nearest", rootOpts.GCCNearest, "fallback at the nearest available gcc version when the enforced one is not provided by any builder image")
+	flags.StringVar(&rootOpts.ClangVersion, "clangversion", rootOpts.ClangVersion, "enforce a specific clang version, or a clang version range, for the eBPF probe build")
+	flags.BoolVar(&rootOpts.PrintResolvedImage, "print-resolved-image", rootOpts.PrintResolvedImage, "print the builder image used for the build")
+	flags.StringVar(&rootOpts.ResolvedImageFile, "resolved-image-file", rootOpts.ResolvedImageFile, "file where to append the builder image used for the build, along with target, kernel release and gcc version")
+	flags.StringVar(&rootOpts.ReportFile, "report-file", rootOpts.ReportFile, "file where to write the results of the builds, as yaml when its extension is .yaml or .yml, or as json: for each target, kernel release and architecture, the builder image and gcc version, the status (one of success, failure, skipped), the duration and the output files; it is written even when builds fail")
 
 	flags.StringSliceVar(&rootOpts.KernelUrls, "kernelurls", nil, "list of kernel header urls (e.g. --kernelurls <URL1> --kernelurls <URL2> --kernelurls \"<URL3>,<URL4>\")")
+	flags.StringSliceVar(&rootOpts.HeadersMirrors, "headers-mirror", rootOpts.HeadersMirrors, "list of base urls of mirrors, in order, the build script downloads kernel headers packages from when their url fails, like when it is rate-limited: their scheme and host are replaced by the ones of the mirror, whose path is prepended to theirs; the url that ultimately served them is logged. eg: --headers-mirror https://mirror.example.com/ubuntu-cache")
+	flags.StringSliceVar(&rootOpts.KernelHeadersURLs, "kernelheaders-url", rootOpts.KernelHeadersURLs, "list of custom kernel headers packages of kernel releases, in the <kernelrelease>=<url>[#sha256=<hex>] form, like the ones of custom kernels stored in an artifact store: the ones of the kernel release are downloaded in place of the ones derived from the target, unless --kernelurls are given, and verified against their checksum, if any, by the build script. eg: --kernelheaders-url '5.10.0-custom=https://artifacts.example.com/linux-headers-5.10.0-custom.deb#sha256=<hex>'")
 
 	flags.StringVar(&rootOpts.Repo.Org, "repo-org", rootOpts.Repo.Org, "repository github organization")
 	flags.StringVar(&rootOpts.Repo.Name, "repo-name", rootOpts.Repo.Name, "repository github name")
 
+	flags.StringVar(&rootOpts.Registry.User, "registry-user", rootOpts.Registry.User, "username used to search builder images in private registries")
+	flags.StringVar(&rootOpts.Registry.Password, "registry-password", rootOpts.Registry.Password, "password used to search builder images in private registries")
+	flags.StringVar(&rootOpts.Registry.Token, "registry-token", rootOpts.Registry.Token, "bearer token used to search builder images in private registries, in place of username and password")
+	flags.StringSliceVar(&rootOpts.Registry.Auths, "registry-auth", rootOpts.Registry.Auths, "list of credentials of specific builder repos or registries, in the <repo>=<user>:<password> or <repo>=<token> form, used in place of the registry user, password and token for them. eg: --registry-auth registry.gitlab.com/myorg=deployer:secret")
+	flags.StringVar(&rootOpts.Registry.Mirror, "registry-mirror", rootOpts.Registry.Mirror, "registry host, like 'mirror.example.com:5000', used in place of Docker Hub to search and pull builder images")
+	flags.StringSliceVar(&rootOpts.Registry.MirrorRepos, "registry-mirror-repos", rootOpts.Registry.MirrorRepos, "Docker Hub repositories prefixes, like 'falcosecurity/', whose images are rewritten to the registry mirror. If not provided, every Docker Hub image is rewritten.")
+
+	flags.StringVar(&rootOpts.ImagesCache.File, "images-cache-file", rootOpts.ImagesCache.File, "json file where to persist builder images found in docker repositories, to be reused by subsequent runs")
+	flags.DurationVar(&rootOpts.ImagesCache.TTL, "images-cache-ttl", rootOpts.ImagesCache.TTL, "time to live of cached builder images, 0 means that they never expire")
+	flags.BoolVar(&rootOpts.ImagesCache.Bypass, "images-cache-bypass", rootOpts.ImagesCache.Bypass, "ignore cached builder images and search docker repositories again")
+
+	flags.StringVar(&rootOpts.Container.Prefix, "container-prefix", rootOpts.Container.Prefix, "prefix of the names of the containers created by the docker processor, followed by a random id")
+	flags.StringSliceVar(&rootOpts.Container.Labels, "container-label", rootOpts.Container.Labels, "list of labels, in the <key>=<value> form, set on the containers created by the docker processor, along with the driverkit.run (a random id of the run, unless overridden), driverkit.target and driverkit.kernelrelease ones, so that they can be found with 'docker ps --filter label=<key>=<value>'. eg: --container-label ci.job=1234")
+
 	viper.BindPFlags(flags)
 
 	// Flag annotations and custom completions
@@ -167,7 +304,10 @@ func NewRootCmd() *RootCmd {
 	rootCmd.AddCommand(NewKubernetesCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewKubernetesInClusterCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewDockerCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewLocalCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewImagesCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewGCCVersionsCmd(rootOpts, flags))
+	rootCmd.AddCommand(NewPrepullCmd(rootOpts, flags))
 	rootCmd.AddCommand(NewCompletionCmd())
 
 	ret.StripSensitive()
@@ -223,11 +363,8 @@ func Start() {
 }
 
 func init() {
-	logger.SetFormatter(&logger.TextFormatter{
-		ForceColors:            true,
-		DisableLevelTruncation: false,
-		DisableTimestamp:       true,
-	})
+	validate.DisableLogColors(false)
+	logger.SetFormatter(validate.LogFormatters["text"])
 
 	cobra.OnInitialize(initConfig)
 }
@@ -240,6 +377,7 @@ func initConfig() {
 		}
 		// configOptions.configErrors should be true here
 	}
+	validate.DisableLogColors(configOptions.NoColor)
 	if configOptions.ConfigFile != "" {
 		viper.SetConfigFile(configOptions.ConfigFile)
 	} else {
@@ -261,6 +399,10 @@ func initConfig() {
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		logger.WithField("file", viper.ConfigFileUsed()).Info("using config file")
+		if err = configOptions.loadAllowedTargets(viper.ConfigFileUsed()); err != nil {
+			logger.WithField("file", viper.ConfigFileUsed()).WithError(err).Error("error loading the allowed targets")
+			configOptions.configErrors = true
+		}
 	} else {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
 			// Config file not found, ignore ...
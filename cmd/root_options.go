@@ -1,20 +1,56 @@
 package cmd
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"github.com/creasty/defaults"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"github.com/falcosecurity/driverkit/validate"
 	"github.com/go-playground/validator/v10"
 	logger "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // OutputOptions wraps the two drivers that driverkit builds.
 type OutputOptions struct {
-	Module string `validate:"required_without=Probe,filepath,omitempty,endswith=.ko" name:"output module path"`
-	Probe  string `validate:"required_without=Module,filepath,omitempty,endswith=.o" name:"output probe path"`
+	Module string `validate:"required_without=Probe,filepath,omitempty,outputfilepath,endswith=.ko" name:"output module path"`
+	Probe  string `validate:"required_without=Module,filepath,omitempty,outputfilepath,endswith=.o" name:"output probe path"`
+	// Dir is the directory where to lay the drivers out by target, architecture and kernel release, in place of Module and Probe
+	Dir string `name:"output directory"`
+}
+
+// RegistryOptions contains the credentials used to search builder images in private registries.
+type RegistryOptions struct {
+	User     string `validate:"required_with=Password" name:"registry user"`
+	Password string `name:"registry password"`
+	Token    string `name:"registry token"`
+	// Auths are the credentials of specific repos or registries, in the <repo>=<user>:<password> or <repo>=<token> form
+	Auths []string `validate:"omitempty,dive,registryauth" name:"registry auths"`
+	// Mirror is the registry host used in place of Docker Hub, optionally only for MirrorRepos
+	Mirror      string   `validate:"omitempty,registryhost" name:"registry mirror"`
+	MirrorRepos []string `name:"registry mirror repos"`
+}
+
+// ImagesCacheOptions configures the caching of builder images found in docker repositories.
+type ImagesCacheOptions struct {
+	File   string        `validate:"omitempty,filepath" name:"images cache file"`
+	TTL    time.Duration `default:"1h" name:"images cache ttl"`
+	Bypass bool
+}
+
+// ContainerOptions configures the containers created by the docker processor, to tell them apart on shared docker hosts.
+type ContainerOptions struct {
+	Prefix string `default:"driverkit" validate:"containerprefix" name:"container prefix"`
+	// Labels are set on the containers, in the <key>=<value> form, along with the run id, target and kernel release
+	Labels []string `validate:"omitempty,dive,containerlabel" name:"container labels"`
 }
 
 type RepoOptions struct {
@@ -24,20 +60,52 @@ type RepoOptions struct {
 
 // RootOptions ...
 type RootOptions struct {
-	Architecture     string   `validate:"required,architecture" name:"architecture"`
-	DriverVersion    string   `default:"master" validate:"eq=master|sha1|semver" name:"driver version"`
-	KernelVersion    string   `default:"1" validate:"omitempty" name:"kernel version"`
-	ModuleDriverName string   `default:"falco" validate:"max=60" name:"kernel module driver name"`
-	ModuleDeviceName string   `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
-	KernelRelease    string   `validate:"required,ascii" name:"kernel release"`
-	Target           string   `validate:"required,target" name:"target"`
-	KernelConfigData string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
-	BuilderImage     string   `validate:"omitempty,imagename" name:"builder image"`
-	BuilderRepos     []string `default:"[\"docker.io/falcosecurity/driverkit\"]" validate:"omitempty" name:"docker repositories to look for builder images or absolute path pointing to a yaml file containing builder image index"`
-	GCCVersion       string   `validate:"omitempty,semvertolerant" name:"gcc version"`
-	KernelUrls       []string `name:"kernel header urls"`
-	Repo             RepoOptions
-	Output           OutputOptions
+	Architecture       string   `validate:"required,architecture" name:"architecture"`
+	DriverVersion      string   `default:"master" validate:"eq=master|sha1|semver" name:"driver version"`
+	KernelVersion      string   `default:"1" validate:"omitempty" name:"kernel version"`
+	ModuleDriverName   string   `default:"falco" validate:"max=60" name:"kernel module driver name"`
+	ModuleDeviceName   string   `default:"falco" validate:"excludes=/,max=255" name:"kernel module device name"`
+	KernelRelease      string   `validate:"required,ascii" name:"kernel release"`
+	Target             string   `validate:"required,target" name:"target"`
+	TargetAliases      []string `validate:"omitempty,dive,targetalias" name:"target aliases"`
+	Autodetect         bool
+	KernelConfigData   string   `validate:"omitempty,base64" name:"kernel config data"` // fixme > tag "name" does not seem to work when used at struct level, but works when used at inner level
+	KernelConfig       string   `name:"kernel config"`
+	BuilderImage       string   `validate:"omitempty,imagename" name:"builder image"`
+	BuilderImageName   string   `validate:"omitempty,imagename" name:"builder image name"`
+	BuilderImagesPin   []string `validate:"omitempty,dive,pinnedimage" name:"pinned builder images"`
+	BuilderImageVerify bool
+	BuilderImageDigest bool
+	BuilderImageAny    bool
+	BuilderRepos       []string `default:"[\"docker.io/falcosecurity/driverkit\"]" validate:"omitempty" name:"docker repositories to look for builder images or absolute path pointing to a yaml file containing builder image index"`
+	BuilderReposPrio   []string `validate:"omitempty,dive,repopriority" name:"builder repos priority"`
+	BuilderReposSums   []string `validate:"omitempty,dive,repochecksum" name:"builder repos checksums"`
+	BuilderReposStrict bool
+	// BuilderReposEmbedded enables the builder images list embedded in driverkit, when no builder repo provides any image
+	BuilderReposEmbedded bool   `default:"true"`
+	BuilderPattern       string `validate:"omitempty,imagepattern" name:"builder images pattern"`
+	BuilderExclude       string `validate:"omitempty,regex" name:"builder images exclude pattern"`
+	GCCVersion           string `validate:"omitempty,gccversion" name:"gcc version"`
+	GCCNearest           bool
+	VerifyGCC            bool
+	GCCDeny              []string `validate:"omitempty,dive,semvertolerant|semverrange" name:"denied gcc versions"`
+	GCCRulesFile         string   `validate:"omitempty,filepath" name:"gcc rules file"`
+	MinGCC               string   `validate:"omitempty,semvertolerant" name:"minimum gcc version"`
+	ClangVersion         string   `validate:"omitempty,semvertolerant|semverrange" name:"clang version"`
+	PrintResolvedImage   bool
+	ResolvedImageFile    string   `validate:"omitempty,filepath" name:"resolved image file"`
+	ReportFile           string   `validate:"omitempty,filepath" name:"report file"`
+	KernelUrls           []string `name:"kernel header urls"`
+	KernelHeadersURLs    []string `validate:"omitempty,dive,kernelheadersurl" name:"kernel headers urls"`
+	HeadersMirrors       []string `validate:"omitempty,dive,url" name:"headers mirrors"`
+	Repo                 RepoOptions
+	Registry             RegistryOptions
+	ImagesCache          ImagesCacheOptions
+	Container            ContainerOptions
+	Output               OutputOptions
+	Drivers              []string `validate:"omitempty,dive,oneof=module probe" name:"drivers"` // empty builds the drivers with an output path
+
+	kernelConfig []byte // loaded from KernelConfig once, on validation
 }
 
 func init() {
@@ -65,6 +133,29 @@ func (ro *RootOptions) Validate() []error {
 		return errArr
 	}
 
+	if ro.GCCRulesFile != "" {
+		if _, err := builder.LoadGCCRules(ro.GCCRulesFile); err != nil {
+			return []error{err}
+		}
+	}
+
+	if ro.KernelConfig != "" {
+		if ro.KernelConfigData != "" {
+			return []error{fmt.Errorf("the kernel config cannot be set along with the kernel config data")}
+		}
+		if err := ro.loadKernelConfig(); err != nil {
+			return []error{err}
+		}
+	}
+
+	for _, repoChecksum := range ro.BuilderReposSums {
+		// Already validated
+		repo, _, _ := builder.ParseRepoChecksum(repoChecksum)
+		if !strings.HasPrefix(repo, "/") && !builder.IsURLRepo(repo) {
+			return []error{fmt.Errorf("builder repo checksums are only supported by yaml images lists, as files or urls: %s", repo)}
+		}
+	}
+
 	// check that the kernel versions supports at least one of probe and module
 	kr := kernelrelease.FromString(ro.KernelRelease)
 	kr.Architecture = kernelrelease.Architecture(ro.Architecture)
@@ -72,6 +163,118 @@ func (ro *RootOptions) Validate() []error {
 		return []error{fmt.Errorf("both module and probe are not supported by given options")}
 	}
 
+	// check that the requested drivers have an output path and are supported
+	for _, driver := range ro.Drivers {
+		switch {
+		case driver == builder.DriverModule && ro.Output.Module == "":
+			return []error{fmt.Errorf("the kernel module is requested by drivers, but its output path is not set")}
+		case driver == builder.DriverProbe && ro.Output.Probe == "":
+			return []error{fmt.Errorf("the eBPF probe is requested by drivers, but its output path is not set")}
+		case driver == builder.DriverModule && !kr.SupportsModule():
+			return []error{fmt.Errorf("the kernel module is requested by drivers, but it is not supported by kernel release %s", ro.KernelRelease)}
+		case driver == builder.DriverProbe && !kr.SupportsProbe():
+			return []error{fmt.Errorf("the eBPF probe is requested by drivers, but it is not supported by kernel release %s", ro.KernelRelease)}
+		}
+	}
+
+	return nil
+}
+
+// applyOutputDir sets the output paths of the drivers in the output directory, if any,
+// under the "{target}/{arch}/{kernelrelease}" subdirectory, named after the kernel module driver name.
+// The drivers requested by Drivers are laid out, otherwise the ones supported by the kernel release.
+func (ro *RootOptions) applyOutputDir() error {
+	if ro.Output.Dir == "" {
+		return nil
+	}
+	if ro.Output.Module != "" || ro.Output.Probe != "" {
+		return fmt.Errorf("the output directory cannot be set along with the output module or probe paths")
+	}
+	if info, err := os.Stat(ro.Output.Dir); err == nil && !info.IsDir() {
+		return fmt.Errorf("the output directory is not a directory: %s", ro.Output.Dir)
+	}
+
+	kr := kernelrelease.FromString(ro.KernelRelease)
+	kr.Architecture = kernelrelease.Architecture(ro.Architecture)
+	builds := func(driver string, supported bool) bool {
+		if len(ro.Drivers) == 0 {
+			return supported
+		}
+		for _, d := range ro.Drivers {
+			if d == driver {
+				return true
+			}
+		}
+		return false
+	}
+	dir := filepath.Join(ro.Output.Dir, "{target}", "{arch}", "{kernelrelease}")
+	if builds(builder.DriverModule, kr.SupportsModule()) {
+		ro.Output.Module = filepath.Join(dir, ro.ModuleDriverName+".ko")
+	}
+	if builds(builder.DriverProbe, kr.SupportsProbe()) {
+		ro.Output.Probe = filepath.Join(dir, ro.ModuleDriverName+".o")
+	}
+	return nil
+}
+
+// applyHost sets the target, kernel release, kernel version and architecture to the ones of the running host,
+// except the explicitly set ones, keyed by flag name.
+func (ro *RootOptions) applyHost(host *builder.Host, explicit map[string]bool) {
+	fields := logger.Fields{}
+	if !explicit["target"] {
+		ro.Target = host.Target.String()
+		fields["target"] = ro.Target
+	}
+	if !explicit["kernelrelease"] {
+		ro.KernelRelease = host.KernelRelease
+		fields["kernelrelease"] = ro.KernelRelease
+	}
+	if !explicit["kernelversion"] {
+		ro.KernelVersion = host.KernelVersion
+		fields["kernelversion"] = ro.KernelVersion
+	}
+	if !explicit["architecture"] {
+		ro.Architecture = host.Architecture
+		fields["arch"] = ro.Architecture
+	}
+	logger.WithFields(fields).Info("running host detected")
+}
+
+// checkAllowedTargets fails when any of the targets is not among the allowed ones, if any,
+// resolved through the DefaultTargetAliases only, since the TargetAliases come with the build request.
+func (ro *RootOptions) checkAllowedTargets(allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	permitted := make(map[string]bool)
+	for _, target := range allowed {
+		target = builder.DefaultTargetAliases.Resolve(target).String()
+		if strings.HasPrefix(target, "ubuntu") {
+			target = "ubuntu"
+		}
+		permitted[target] = true
+	}
+	for _, target := range ro.targets() {
+		// Missing targets are reported by validation
+		if target != "" && !permitted[target] {
+			return fmt.Errorf("target %s is not allowed, the allowed targets are [%s]", target, strings.Join(allowed, ","))
+		}
+	}
+	return nil
+}
+
+// ValidateDiscovery validates the RootOptions used to discover builder images,
+// ignoring the ones only needed to build, like the kernel release and the output paths.
+func (ro *RootOptions) ValidateDiscovery() []error {
+	if err := validate.V.StructExcept(ro, "KernelRelease", "Output"); err != nil {
+		errors := err.(validator.ValidationErrors)
+		errArr := []error{}
+		for _, e := range errors {
+			// Translate each error one at a time
+			errArr = append(errArr, fmt.Errorf(e.Translate(validate.T)))
+		}
+		return errArr
+	}
 	return nil
 }
 
@@ -80,6 +283,9 @@ func (ro *RootOptions) Validate() []error {
 // Call it only after validation.
 func (ro *RootOptions) Log() {
 	fields := logger.Fields{}
+	if ro.Output.Dir != "" {
+		fields["output-dir"] = ro.Output.Dir
+	}
 	if ro.Output.Module != "" {
 		fields["output-module"] = ro.Output.Module
 	}
@@ -87,6 +293,9 @@ func (ro *RootOptions) Log() {
 		fields["output-probe"] = ro.Output.Probe
 
 	}
+	if len(ro.Drivers) > 0 {
+		fields["drivers"] = ro.Drivers
+	}
 	if ro.DriverVersion != "" {
 		fields["driverversion"] = ro.DriverVersion
 	}
@@ -100,23 +309,48 @@ func (ro *RootOptions) Log() {
 		fields["target"] = ro.Target
 	}
 	fields["arch"] = ro.Architecture
+	if ro.KernelConfig != "" {
+		fields["kernelconfig"] = ro.KernelConfig
+	}
 	if len(ro.KernelUrls) > 0 {
 		fields["kernelurls"] = ro.KernelUrls
 	}
 	fields["repo-org"] = ro.Repo.Org
 	fields["repo-name"] = ro.Repo.Name
+	if ro.Registry.User != "" {
+		fields["registry-user"] = ro.Registry.User
+	}
+	if ro.Registry.Mirror != "" {
+		fields["registry-mirror"] = ro.Registry.Mirror
+	}
 
 	logger.WithFields(fields).Debug("running with options")
 }
 
+// loadKernelConfig loads the kernel config file or url, used by every build.
+func (ro *RootOptions) loadKernelConfig() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+	kernelConfig, err := builder.LoadKernelConfig(ctx, ro.KernelConfig, viper.GetString("proxy"), timeout())
+	if err != nil {
+		return err
+	}
+	ro.kernelConfig = kernelConfig
+	return nil
+}
+
 func (ro *RootOptions) toBuild() *builder.Build {
 	kernelConfigData := ro.KernelConfigData
+	if ro.kernelConfig != nil {
+		// Loaded on validation
+		kernelConfigData = base64.StdEncoding.EncodeToString(ro.kernelConfig)
+	}
 	if len(kernelConfigData) == 0 {
 		kernelConfigData = "bm8tZGF0YQ==" // no-data
 	}
 
 	build := &builder.Build{
-		TargetType:       builder.Type(ro.Target),
+		TargetType:       builder.Type(ro.targets()[0]),
 		DriverVersion:    ro.DriverVersion,
 		KernelVersion:    ro.KernelVersion,
 		KernelRelease:    ro.KernelRelease,
@@ -124,60 +358,227 @@ func (ro *RootOptions) toBuild() *builder.Build {
 		KernelConfigData: kernelConfigData,
 		ModuleFilePath:   ro.Output.Module,
 		ProbeFilePath:    ro.Output.Probe,
+		Drivers:          ro.Drivers,
 		ModuleDriverName: ro.ModuleDriverName,
 		ModuleDeviceName: ro.ModuleDeviceName,
 		GCCVersion:       ro.GCCVersion,
+		GCCNearest:       ro.GCCNearest,
+		GCCDeny:          ro.GCCDeny,
+		MinGCC:           ro.MinGCC,
+		VerifyGCC:        ro.VerifyGCC,
+		ClangVersion:     ro.ClangVersion,
 		BuilderImage:     ro.BuilderImage,
 		BuilderRepos:     ro.BuilderRepos,
-		KernelUrls:       ro.KernelUrls,
-		RepoOrg:          ro.Repo.Org,
-		RepoName:         ro.Repo.Name,
-		Images:           make(builder.ImagesMap),
+		ImagePattern:     ro.BuilderPattern,
+		ImageExclude:     ro.BuilderExclude,
+		ImageName:        ro.BuilderImageName,
+		PreferAnyImages:  ro.BuilderImageAny,
+		RegistryAuth: builder.RegistryAuth{
+			Username: ro.Registry.User,
+			Password: ro.Registry.Password,
+			Token:    ro.Registry.Token,
+		},
+		RegistryAuths: ro.registryAuths(),
+		RegistryMirror: builder.RegistryMirror{
+			Host:  ro.Registry.Mirror,
+			Repos: ro.Registry.MirrorRepos,
+		},
+		RegistryMaxAttempts: viper.GetInt("registry-max-attempts"),
+		RegistrySearchLimit: viper.GetInt("registry-search-limit"),
+		HeadersMirrors:      ro.HeadersMirrors,
+		HeadersMaxAttempts:  viper.GetInt("headers-max-attempts"),
+		RegistryProxy:       viper.GetString("proxy"),
+		BuildTimeout:        buildTimeout(),
+		TargetAliases:       ro.targetAliases(),
+		ImagesCache: builder.ImagesCache{
+			File:   ro.ImagesCache.File,
+			TTL:    ro.ImagesCache.TTL,
+			Bypass: ro.ImagesCache.Bypass,
+		},
+		KernelUrls: ro.KernelUrls,
+		RepoOrg:    ro.Repo.Org,
+		RepoName:   ro.Repo.Name,
+		Images:     make(builder.ImagesMap),
+	}
+	for _, target := range ro.targets() {
+		build.Targets = append(build.Targets, builder.Type(target))
+	}
+	if ro.BuilderImageVerify {
+		build.ImageInspector = &builder.RegistryImageInspector{Auth: build.RegistryAuth, Auths: build.RegistryAuths}
+	}
+	if ro.BuilderImageDigest {
+		build.DigestResolver = &builder.RegistryImageInspector{Auth: build.RegistryAuth, Auths: build.RegistryAuths}
+	}
+	build.CustomKernelConfig = ro.kernelConfig != nil
+	if ro.GCCRulesFile != "" {
+		// Already validated
+		build.GCCRules, _ = builder.LoadGCCRules(ro.GCCRulesFile)
+	}
+	for _, kernelHeadersURL := range ro.KernelHeadersURLs {
+		// Already validated
+		h, _ := builder.ParseKernelHeadersURL(kernelHeadersURL)
+		build.KernelHeadersURLs = append(build.KernelHeadersURLs, h)
+	}
+	for _, pinnedImage := range ro.BuilderImagesPin {
+		// Already validated
+		image, _ := builder.ParsePinnedImage(pinnedImage)
+		build.PinnedImages = append(build.PinnedImages, image)
 	}
 
-	// loop over BuilderRepos to constuct the list ImagesListers based on the value of the builderRepo, if it's a local path, add FileImagesLister, otherwise add RepoImagesLister
+	priorities := make(map[string]int, len(ro.BuilderReposPrio))
+	for _, repoPriority := range ro.BuilderReposPrio {
+		// Already validated
+		repo, priority, _ := builder.ParseRepoPriority(repoPriority)
+		priorities[repo] = priority
+	}
+
+	checksums := make(map[string]string, len(ro.BuilderReposSums))
+	for _, repoChecksum := range ro.BuilderReposSums {
+		// Already validated
+		repo, checksum, _ := builder.ParseRepoChecksum(repoChecksum)
+		checksums[repo] = checksum
+	}
+
+	// loop over BuilderRepos to constuct the list ImagesListers based on the value of the builderRepo, if it's a local path, add FileImagesLister, if it's an http(s) url, add URLImagesLister, if it has the oci:// scheme, add TagsImagesLister, if it has the oci-layout:// scheme, add OCILayoutImagesLister, otherwise add RepoImagesLister
 	for _, builderRepo := range build.BuilderRepos {
+		if builderRepo == "" {
+			continue
+		}
+		var imagesLister builder.ImagesLister
 		if strings.HasPrefix(builderRepo, "/") {
-			build.ImagesListers = append(build.ImagesListers, &builder.FileImagesLister{FilePath: builderRepo})
+			imagesLister = &builder.FileImagesLister{FilePath: builderRepo, Strict: ro.BuilderReposStrict, Aliases: build.TargetAliases, Checksum: checksums[builderRepo]}
+		} else if builder.IsURLRepo(builderRepo) {
+			imagesLister = &builder.URLImagesLister{URL: builderRepo, Proxy: viper.GetString("proxy"), Timeout: timeout(), Strict: ro.BuilderReposStrict, Aliases: build.TargetAliases, Checksum: checksums[builderRepo]}
+		} else if strings.HasPrefix(builderRepo, builder.OCILayoutRepoScheme) {
+			imagesLister = builder.NewOCILayoutImagesLister(builderRepo, build)
+		} else if strings.HasPrefix(builderRepo, builder.TagsRepoScheme) {
+			imagesLister = builder.NewTagsImagesLister(builderRepo, build)
 		} else {
-			build.ImagesListers = append(build.ImagesListers, builder.NewRepoImagesLister(builderRepo, build))
+			imagesLister = builder.NewRepoImagesLister(builderRepo, build)
+		}
+		if priority, ok := priorities[builderRepo]; ok {
+			imagesLister = &builder.PrioritizedImagesLister{ImagesLister: imagesLister, Priority: priority}
 		}
+		build.ImagesListers = append(build.ImagesListers, imagesLister)
+	}
+	// Last resort: the official builder images known at build time, when no other builder repo provides any image
+	if ro.BuilderReposEmbedded {
+		build.ImagesListers = append(build.ImagesListers, &builder.LastResortImagesLister{ImagesLister: &builder.EmbeddedImagesLister{Architecture: build.Architecture}})
 	}
 
 	// attempt the build in case it comes from an invalid config
 	kr := build.KernelReleaseFromBuildConfig()
 	if len(build.ModuleFilePath) > 0 && !kr.SupportsModule() {
 		build.ModuleFilePath = ""
-		logger.Warningf("Skipping build attempt of module for unsupported kernel version %s", kr.String())
+		logger.WithField("kernelrelease", kr.String()).Warning("Skipping build attempt of module for unsupported kernel version")
 	}
 	if len(build.ProbeFilePath) > 0 && !kr.SupportsProbe() {
 		build.ProbeFilePath = ""
-		logger.Warningf("Skipping build attempt of probe for unsupported kernel version %s", kr.String())
+		logger.WithField("kernelrelease", kr.String()).Warning("Skipping build attempt of probe for unsupported kernel version")
 	}
 
 	return build
 }
 
+// emitResolvedImage prints the builder image used by the build and/or appends it to the resolved image file,
+// when requested.
+//
+// Call it only after a successful build.
+func (ro *RootOptions) emitResolvedImage(b *builder.Build) error {
+	if !ro.PrintResolvedImage && ro.ResolvedImageFile == "" {
+		return nil
+	}
+	image := b.GetBuilderImage()
+	if ro.PrintResolvedImage {
+		fmt.Fprintln(os.Stdout, image)
+	}
+	if ro.ResolvedImageFile != "" {
+		f, err := os.OpenFile(ro.ResolvedImageFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err = fmt.Fprintf(f, "%s %s %s %s\n", b.TargetType, b.KernelRelease, b.GCCVersion, image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerLabels returns the labels of the containers created by the docker processor during the run:
+// a new run id, and the container labels, possibly overriding it.
+//
+// Call it only after validation.
+func (ro *RootOptions) containerLabels() map[string]string {
+	labels := map[string]string{driverbuilder.RunLabel: string(uuid.NewUUID())}
+	for _, label := range ro.Container.Labels {
+		key, value, _ := driverbuilder.ParseContainerLabel(label)
+		labels[key] = value
+	}
+	return labels
+}
+
+// targets returns the targets to build for, since Target can be a comma separated list.
+func (ro *RootOptions) targets() []string {
+	return strings.Split(ro.Target, ",")
+}
+
+// hasTarget returns whether any of targets is among the targets to build for.
+func (ro *RootOptions) hasTarget(targets ...builder.Type) bool {
+	for _, t := range ro.targets() {
+		for _, target := range targets {
+			if t == target.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RootOptionsLevelValidation validates KernelConfigData and Target at the same time.
 //
 // It reports an error when `KernelConfigData` is empty and `Target` is `vanilla`.
 func RootOptionsLevelValidation(level validator.StructLevel) {
 	opts := level.Current().Interface().(RootOptions)
 
-	if opts.Target == builder.TargetTypeVanilla.String() ||
-		opts.Target == builder.TargetTypeMinikube.String() ||
-		opts.Target == builder.TargetTypeFlatcar.String() {
-		if len(opts.KernelConfigData) == 0 {
+	if opts.hasTarget(builder.TargetTypeVanilla, builder.TargetTypeMinikube, builder.TargetTypeFlatcar) {
+		if len(opts.KernelConfigData) == 0 && opts.KernelConfig == "" {
 			level.ReportError(opts.KernelConfigData, "kernelConfigData", "KernelConfigData", "required_kernelconfigdata_with_target_vanilla", "")
 		}
 	}
 
-	if opts.KernelVersion == "" && (opts.Target == builder.TargetTypeUbuntu.String()) {
+	if opts.KernelVersion == "" && opts.hasTarget(builder.TargetTypeUbuntu) {
 		level.ReportError(opts.KernelVersion, "kernelVersion", "KernelVersion", "required_kernelversion_with_target_ubuntu", "")
 	}
 
 	// Target redhat requires a valid build image (has to be registered in order to download packages)
-	if opts.Target == builder.TargetTypeRedhat.String() && opts.BuilderImage == "" {
+	if opts.hasTarget(builder.TargetTypeRedhat) && opts.BuilderImage == "" {
 		level.ReportError(opts.BuilderImage, "builderimage", "builderimage", "required_builderimage_with_target_redhat", "")
 	}
 }
+
+// targetAliases returns the target aliases, in addition to the builder.DefaultTargetAliases.
+func (ro *RootOptions) targetAliases() builder.TargetAliases {
+	aliases := make(builder.TargetAliases, len(ro.TargetAliases))
+	for _, targetAlias := range ro.TargetAliases {
+		// Invalid ones are reported by validation
+		if alias, target, err := builder.ParseTargetAlias(targetAlias); err == nil {
+			aliases[alias] = target
+		}
+	}
+	return aliases
+}
+
+// registryAuths returns the credentials of specific repos or registries, keyed by repo or registry host.
+func (ro *RootOptions) registryAuths() builder.RegistryAuths {
+	if len(ro.Registry.Auths) == 0 {
+		return nil
+	}
+	auths := make(builder.RegistryAuths, len(ro.Registry.Auths))
+	for _, registryAuth := range ro.Registry.Auths {
+		// Already validated
+		repo, auth, _ := builder.ParseRegistryAuth(registryAuth)
+		auths[repo] = auth
+	}
+	return auths
+}
@@ -2,29 +2,92 @@ package builder
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 )
 
+// GCCAuto is the GCCVersion making build processors users try the gcc versions provided by the builder images,
+// in the GCCCandidates order, until the build succeeds.
+// Otherwise, it is handled like an empty GCCVersion, picking the best-match gcc.
+const GCCAuto = "auto"
+
+// Drivers built by driverkit, see Build.Drivers.
+const (
+	DriverModule = "module"
+	DriverProbe  = "probe"
+)
+
 // Build contains the info about the on-going build.
 type Build struct {
-	TargetType       Type
-	KernelConfigData string
-	KernelRelease    string
-	KernelVersion    string
-	DriverVersion    string
-	Architecture     string
-	ModuleFilePath   string
-	ProbeFilePath    string
-	ModuleDriverName string
-	ModuleDeviceName string
-	BuilderImage     string
-	BuilderRepos     []string
-	ImagesListers    []ImagesLister
-	KernelUrls       []string
-	GCCVersion       string
-	RepoOrg          string
-	RepoName         string
-	Images           ImagesMap
+	TargetType          Type
+	Targets             []Type // all the targets to build for, sharing builder images; see PerTarget
+	KernelConfigData    string
+	CustomKernelConfig  bool // KernelConfigData is a custom kernel config, applied to the kernel headers of every target
+	KernelRelease       string
+	KernelVersion       string
+	DriverVersion       string
+	Architecture        string
+	ModuleFilePath      string
+	ProbeFilePath       string
+	Drivers             []string // drivers to build, among DriverModule and DriverProbe; empty builds the ones with an output file path, see BuildsModule and BuildsProbe
+	ModuleDriverName    string
+	ModuleDeviceName    string
+	BuilderImage        string
+	BuilderRepos        []string
+	ImagePattern        string // pattern used to match builder images names in BuilderRepos; see ImageRegexes
+	ImageExclude        string // regex matching the names of builder images to ignore, whatever lister provides them
+	ImageName           string // name of the only builder images to pick from, like "myorg/driverkit-builder-centos", still selecting the best gcc they provide
+	PreferAnyImages     bool   // prefer "any" target images over target-specific ones, like to validate them; target-specific images are preferred by default
+	RegistryAuth        RegistryAuth
+	RegistryAuths       RegistryAuths  // credentials of specific repos or registries, used in place of RegistryAuth for them
+	RegistryMaxAttempts int            // number of attempts of each docker repository search; see RepoImagesLister
+	RegistrySearchLimit int            // maximum number of results of each docker repository search, up to MaxRegistrySearchLimit
+	RegistryMirror      RegistryMirror // mirror used to search and pull Docker Hub images
+	RegistryProxy       string         // proxy url used to search builder repos, either through the docker daemon or the registry API
+	TargetAliases       TargetAliases  // alternative names of targets, used when matching builder images
+	ImagesCache         ImagesCache
+	DriverWriter        DriverWriter // receives the built drivers; nil writes them to ModuleFilePath and ProbeFilePath, see Writer
+	ImagesListers       []ImagesLister
+	PinnedImages        []Image        // images pinned to their digest for their target and gcc, used in place of the listed ones; see ParsePinnedImage
+	ImageInspector      ImageInspector // when set, the picked builder image is inspected, falling back at the next candidate when not available
+	DigestResolver      DigestResolver // when set, the picked builder image is pinned to the digest its tag currently points to
+	BuildTimeout        time.Duration  // timeout of the build step of each processor run, like the compilation in the builder container; zero means none
+	HeadersMirrors      []string       // base urls of the mirrors kernel headers packages are downloaded from when their url fails, in order; see MirrorURL
+	HeadersMaxAttempts  int            // number of rounds of attempts to download each kernel headers package from its url and mirrors, with an exponential backoff
+	KernelUrls          []string
+	KernelHeadersURLs   []KernelHeadersURL // custom kernel headers packages; the ones of KernelRelease are used, unless KernelUrls are set, in place of the ones derived by the builder
+	GCCVersion          string             // either a gcc version, a gcc version range, like ">=9.0.0 <11.0.0", a comma separated list of them, like "8,9", or GCCAuto
+	GCCNearest          bool               // fallback at the nearest gcc when the requested GCCVersion is not provided by any image
+	GCCDeny             []string           // gcc versions, partial versions or ranges never used for builds, like the ones known to mis-compile modules
+	MinGCC              string             // minimum gcc version of the builds, like "8", enforced as a policy: lower gcc versions are never used
+	VerifyGCC           bool               // fail the build script before compiling the kernel module when the gcc of the builder image is not GCCVersion
+	ClangVersion        string             // either a clang version or a clang version range, enforced when building the eBPF probe
+	RepoOrg             string
+	RepoName            string
+	Images              ImagesMap
+	ListersStats        []ListerStats // filled by LoadImages, in the same order as the listers were loaded
+
+	// OnImageSelected, when set, is called each time a builder image is selected for target and gcc,
+	// where fallback tells whether the image is not a target-specific one providing exactly gcc,
+	// like an "any" target image or one providing the nearest gcc. Returning an error rejects the image, failing the build.
+	OnImageSelected func(target Type, gcc semver.Version, chosen Image, fallback bool) error
+
+	// GCCRules set GCCVersion, when empty, to the gcc of the first rule matching the kernel release, see LoadGCCRules;
+	// when no rule matches, the gcc version is picked as usual
+	GCCRules []GCCRule
+
+	discardedImages []Image // images not providing the requested gcc or clang versions
+	deniedImages    []Image // images only providing a denied gcc, see GCCDeny
+	belowMinImages  []Image // images only providing a gcc lower than MinGCC
+
+	listed *listedImages // images of the ImagesListers, loaded once, see LoadImages
 }
 
 func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
@@ -33,6 +96,147 @@ func (b *Build) KernelReleaseFromBuildConfig() kernelrelease.KernelRelease {
 	return kv
 }
 
+// BuildsModule returns whether the kernel module is built: when requested by Drivers or,
+// without Drivers, when ModuleFilePath is set.
+func (b *Build) BuildsModule() bool {
+	return b.builds(DriverModule, b.ModuleFilePath)
+}
+
+// BuildsProbe returns whether the eBPF probe is built: when requested by Drivers or,
+// without Drivers, when ProbeFilePath is set.
+func (b *Build) BuildsProbe() bool {
+	return b.builds(DriverProbe, b.ProbeFilePath)
+}
+
+func (b *Build) builds(driver string, filePath string) bool {
+	if len(b.Drivers) == 0 {
+		return filePath != ""
+	}
+	for _, d := range b.Drivers {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
+
+// DriverWriter receives the drivers built by build processors.
+type DriverWriter interface {
+	// WriteModule is given the content of the kernel module.
+	WriteModule(r io.Reader) error
+	// WriteProbe is given the content of the eBPF probe.
+	WriteProbe(r io.Reader) error
+}
+
+// Writer returns the writer of the built drivers: DriverWriter, if set,
+// or one writing them to the ModuleFilePath and ProbeFilePath files, see OutputFilePath.
+func (b *Build) Writer() DriverWriter {
+	if b.DriverWriter != nil {
+		return b.DriverWriter
+	}
+	return fileDriverWriter{b: b}
+}
+
+// fileDriverWriter writes the drivers of a build to its output files.
+type fileDriverWriter struct {
+	b *Build
+}
+
+func (w fileDriverWriter) WriteModule(r io.Reader) error {
+	return writeFile(w.b.OutputFilePath(w.b.ModuleFilePath), r)
+}
+
+func (w fileDriverWriter) WriteProbe(r io.Reader) error {
+	return writeFile(w.b.OutputFilePath(w.b.ProbeFilePath), r)
+}
+
+// writeFile writes the driver to the file path, creating its parent directories.
+func writeFile(filePath string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// targets returns the targets to load builder images for.
+func (b *Build) targets() []Type {
+	if len(b.Targets) == 0 {
+		return []Type{b.TargetType}
+	}
+	return b.Targets
+}
+
+// PerTarget returns a Build for each of the targets, sharing the loaded builder images.
+// When building for multiple targets, the target is appended to the output file names,
+// like "/tmp/falco-centos.ko".
+func (b *Build) PerTarget() []*Build {
+	targets := b.targets()
+	builds := make([]*Build, 0, len(targets))
+	for _, target := range targets {
+		tb := *b
+		tb.TargetType = target
+		tb.discardedImages = nil
+		tb.deniedImages = nil
+		tb.belowMinImages = nil
+		if len(targets) > 1 {
+			tb.ModuleFilePath = withTarget(b.ModuleFilePath, target)
+			tb.ProbeFilePath = withTarget(b.ProbeFilePath, target)
+		}
+		builds = append(builds, &tb)
+	}
+	return builds
+}
+
+// withTarget appends the target to the file name of filePath, before its extension,
+// unless filePath already contains the target placeholder, see OutputPlaceholders.
+func withTarget(filePath string, target Type) string {
+	if filePath == "" || strings.Contains(filePath, "{target}") {
+		return filePath
+	}
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + "-" + target.String() + ext
+}
+
+// OutputPlaceholders are the placeholders the output file paths can contain, like "/tmp/{target}_{kernelrelease}_{arch}_{gcc}.ko",
+// resolved from the build by OutputFilePath: {arch} is the architecture of the build, like "amd64",
+// and {gcc} the gcc version provided by the selected builder image.
+var OutputPlaceholders = []string{"{target}", "{kernelrelease}", "{arch}", "{gcc}"}
+
+var outputPlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ValidateOutputFilePath returns an error when filePath contains unknown placeholders, see OutputPlaceholders.
+func ValidateOutputFilePath(filePath string) error {
+	for _, placeholder := range outputPlaceholderRegex.FindAllString(filePath, -1) {
+		known := false
+		for _, p := range OutputPlaceholders {
+			known = known || placeholder == p
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %s in output file path %s, expected any of %s", placeholder, filePath, strings.Join(OutputPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// OutputFilePath resolves the placeholders of filePath, either ModuleFilePath or ProbeFilePath, see OutputPlaceholders.
+// It must be called once the gcc version of the build is fixed, like after Script.
+func (b *Build) OutputFilePath(filePath string) string {
+	return strings.NewReplacer(
+		"{target}", b.TargetType.String(),
+		"{kernelrelease}", b.KernelRelease,
+		"{arch}", b.Architecture,
+		"{gcc}", b.GCCVersion,
+	).Replace(filePath)
+}
+
 func (b *Build) toGithubRepoArchive() string {
 	return fmt.Sprintf("https://github.com/%s/%s/archive", b.RepoOrg, b.RepoName)
 }
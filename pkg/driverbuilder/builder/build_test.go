@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPerTarget(t *testing.T) {
+	b := &Build{TargetType: "centos", ModuleFilePath: "/tmp/falco.ko", ProbeFilePath: "/tmp/falco.o"}
+	builds := b.PerTarget()
+	assert.Equal(t, 1, len(builds))
+	assert.Equal(t, "/tmp/falco.ko", builds[0].ModuleFilePath)
+	assert.Equal(t, "/tmp/falco.o", builds[0].ProbeFilePath)
+
+	b = &Build{TargetType: "centos", Targets: []Type{"centos", "ubuntu"}, ModuleFilePath: "/tmp/falco.ko", Images: ImagesMap{}}
+	builds = b.PerTarget()
+	assert.Equal(t, 2, len(builds))
+	assert.Equal(t, Type("centos"), builds[0].TargetType)
+	assert.Equal(t, "/tmp/falco-centos.ko", builds[0].ModuleFilePath)
+	assert.Equal(t, Type("ubuntu"), builds[1].TargetType)
+	assert.Equal(t, "/tmp/falco-ubuntu.ko", builds[1].ModuleFilePath)
+	assert.Equal(t, "", builds[1].ProbeFilePath)
+
+	// Loaded images are shared between targets
+	builds[0].Images["any_8.0.0"] = Image{}
+	assert.Equal(t, 1, len(builds[1].Images))
+}
+
+func TestOutputFilePath(t *testing.T) {
+	b := &Build{TargetType: "centos", KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", GCCVersion: "4.8.0"}
+	assert.Equal(t, "/tmp/centos_3.10.0-957.el7.x86_64_amd64_4.8.0.ko", b.OutputFilePath("/tmp/{target}_{kernelrelease}_{arch}_{gcc}.ko"))
+	assert.Equal(t, "/tmp/falco.ko", b.OutputFilePath("/tmp/falco.ko"))
+
+	assert.NilError(t, ValidateOutputFilePath("/tmp/{target}_{kernelrelease}_{arch}_{gcc}.ko"))
+	assert.NilError(t, ValidateOutputFilePath("/tmp/falco.ko"))
+	assert.ErrorContains(t, ValidateOutputFilePath("/tmp/{target}_{kernelversion}.ko"), "unknown placeholder {kernelversion}")
+
+	// The target is not appended to paths already containing it
+	b = &Build{Targets: []Type{"centos", "ubuntu"}, ModuleFilePath: "/tmp/{target}_{gcc}.ko"}
+	builds := b.PerTarget()
+	assert.Equal(t, "/tmp/{target}_{gcc}.ko", builds[1].ModuleFilePath)
+	assert.Equal(t, "/tmp/ubuntu_.ko", builds[1].OutputFilePath(builds[1].ModuleFilePath))
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	b := &Build{TargetType: "centos", Architecture: "amd64", ModuleFilePath: filepath.Join(dir, "falco-{arch}.ko"), ProbeFilePath: filepath.Join(dir, "{target}", "falco.o")}
+	assert.NilError(t, b.Writer().WriteModule(strings.NewReader("module")))
+	assert.NilError(t, b.Writer().WriteProbe(strings.NewReader("probe")))
+	module, err := os.ReadFile(filepath.Join(dir, "falco-amd64.ko"))
+	assert.NilError(t, err)
+	assert.Equal(t, "module", string(module))
+	probe, err := os.ReadFile(filepath.Join(dir, "centos", "falco.o"))
+	assert.NilError(t, err)
+	assert.Equal(t, "probe", string(probe))
+
+	w := fileDriverWriter{b: &Build{}}
+	b.DriverWriter = w
+	assert.Equal(t, DriverWriter(w), b.Writer())
+}
+
+func TestBuildsDrivers(t *testing.T) {
+	tests := map[string]struct {
+		build  Build
+		module bool
+		probe  bool
+	}{
+		"output paths":        {build: Build{ModuleFilePath: "/tmp/falco.ko", ProbeFilePath: "/tmp/falco.o"}, module: true, probe: true},
+		"module output path":  {build: Build{ModuleFilePath: "/tmp/falco.ko"}, module: true},
+		"probe only":          {build: Build{ModuleFilePath: "/tmp/falco.ko", ProbeFilePath: "/tmp/falco.o", Drivers: []string{DriverProbe}}, probe: true},
+		"both without paths":  {build: Build{Drivers: []string{DriverModule, DriverProbe}}, module: true, probe: true},
+		"module without path": {build: Build{ProbeFilePath: "/tmp/falco.o", Drivers: []string{DriverModule}}, module: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.module, test.build.BuildsModule())
+			assert.Equal(t, test.probe, test.build.BuildsProbe())
+		})
+	}
+}
@@ -2,14 +2,15 @@ package builder
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
-	"log"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -65,8 +66,14 @@ type MinimumURLsBuilder interface {
 	MinimumURLs() int
 }
 
-func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
-	t := template.New(b.Name())
+// Script generates the build script for the builder;
+// ctx governs the discovery of builder images.
+func Script(ctx context.Context, b Builder, c Config, kr kernelrelease.KernelRelease) (string, error) {
+	customURLs, checksums, err := c.kernelHeadersURLs()
+	if err != nil {
+		return "", err
+	}
+	t := template.New(b.Name()).Funcs(template.FuncMap{"download": c.downloadFunc(checksums), "kernelconfig": c.kernelConfigFunc()})
 	parsed, err := t.Parse(b.TemplateScript())
 	if err != nil {
 		return "", err
@@ -78,7 +85,9 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 	}
 
 	var urls []string
-	if c.KernelUrls == nil {
+	if c.KernelUrls == nil && len(customURLs) > 0 {
+		urls, err = c.resolvingURLs(customURLs)
+	} else if c.KernelUrls == nil {
 		urls, err = b.URLs(c, kr)
 		if err != nil {
 			return "", err
@@ -87,10 +96,10 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 		// Otherwise, it is up to the builder to return an error
 		if len(urls) > 0 {
 			// Check (and filter) existing kernels before continuing
-			urls, err = getResolvingURLs(urls)
+			urls, err = c.resolvingURLs(urls)
 		}
 	} else {
-		urls, err = getResolvingURLs(c.KernelUrls)
+		urls, err = c.resolvingURLs(c.KernelUrls)
 	}
 	if err != nil {
 		return "", err
@@ -100,6 +109,11 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 		return "", fmt.Errorf("not enough headers packages found; expected %d, found %d", minimumURLs, len(urls))
 	}
 
+	err = c.setGCCVersion(ctx, b, kr)
+	if err != nil {
+		return "", err
+	}
+
 	td := b.TemplateData(c, kr, urls)
 	if tdErr, ok := td.(error); ok {
 		return "", tdErr
@@ -110,7 +124,13 @@ func Script(b Builder, c Config, kr kernelrelease.KernelRelease) (string, error)
 	if err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	script := buf.String()
+	if c.VerifyGCC && c.BuildsModule() {
+		// Verify gcc right after the shebang, before downloading anything
+		shebang, rest, _ := strings.Cut(script, "\n")
+		script = shebang + "\n" + gccCheckScript("/usr/bin/gcc-"+c.GCCVersion, c.GCCVersion) + rest
+	}
+	return script, nil
 }
 
 type GCCVersionRequestor interface {
@@ -149,96 +169,304 @@ func mustParseTolerant(gccStr string) semver.Version {
 }
 
 // Algorithm.
+// * if user set no gccversion, take the one of the first gcc rule matching the kernel release, if any
 // * always load images (note that it loads only images that provide gccversion, if set by user)
-// * if user set a fixed gccversion, we are good to go
-// * otherwise, try to fix the best-match gcc version provided by any of the loaded images;
+// * if user set a fixed gccversion, we are good to go, unless nearest mode is enabled
+// * if user set a major (and minor) gccversion only, like "9", pick the highest matching gcc
+// * otherwise, try to fix the best-match gcc version provided by any of the loaded images
+// (that are already filtered by the gcc version range, if set by user);
 // see below for algorithm explanation
-func (b *Build) setGCCVersion(builder Builder, kr kernelrelease.KernelRelease) {
-	b.LoadImages()
-
-	if len(b.GCCVersion) > 0 {
-		// If set from user, go on
-		return
+func (b *Build) setGCCVersion(ctx context.Context, builder Builder, kr kernelrelease.KernelRelease) error {
+	if b.GCCVersion == "" {
+		if gcc, ok := b.gccFromRules(); ok {
+			logger.WithField("kernelrelease", b.KernelRelease).WithField("gcc", gcc).Debug("gcc version set by gcc rules")
+			b.GCCVersion = gcc
+		}
+	}
+	if err := b.LoadImages(ctx); err != nil {
+		// Gcc policy violations are reported below, naming the gcc that would have been used
+		var policyErr *GCCPolicyError
+		if !errors.As(err, &policyErr) {
+			return err
+		}
 	}
 
-	b.GCCVersion = "8" // default value
-
-	// if builder implements "GCCVersionRequestor" interface -> use it
-	// Else, fetch the best builder available from the kernelrelease version
-	// using the deadly simple defaultGCC() algorithm
-	// Always returns the nearest one
 	var targetGCC semver.Version
-	if bb, ok := builder.(GCCVersionRequestor); ok {
-		targetGCC = bb.GCCVersion(kr)
+	if isExactVersion(b.GCCVersion) {
+		gccVersion := mustParseTolerant(b.GCCVersion)
+		if !b.GCCNearest {
+			if b.belowMinGCC(gccVersion) {
+				return &GCCPolicyError{Target: b.TargetType, GCCVersion: gccVersion, MinGCC: b.MinGCC}
+			}
+			if b.deniesGCC(gccVersion) {
+				// Images providing every gcc must not provide it either
+				return b.imageNotFound(b.TargetType, b.GCCVersion)
+			}
+			// If set from user, go on, as long as an image provides it
+			image, ok := b.findInspectedImage(ctx, b.TargetType, gccVersion)
+			if !ok {
+				return b.imageNotFound(b.TargetType, b.GCCVersion)
+			}
+			if err := b.imageSelected(b.TargetType, gccVersion, image); err != nil {
+				return err
+			}
+			return b.pinDigest(ctx, image)
+		}
+		targetGCC = gccVersion
+	} else if _, partial := partialVersionRange(b.GCCVersion); partial {
+		// User set a major (and minor) gcc version only: loaded images all provide it,
+		// pick the highest one
+		versions := b.GCCVersionsFor(b.TargetType)
+		if len(versions) == 0 {
+			return b.imageNotFound(b.TargetType, b.GCCVersion)
+		}
+		targetGCC = versions[len(versions)-1]
+	}
+	// Otherwise, user set a gcc version range, the auto gcc mode, or no gcc at all
+
+	if targetGCC.EQ(semver.Version{}) && b.GCCVersion == "" {
+		// The default gcc declared by builder images for the target wins over the one picked for the kernel release
+		if gcc, ok := b.targetDefaultGCC(b.TargetType); ok {
+			logger.WithField("target", b.TargetType).WithField("gcc", gcc.String()).Debug("gcc version set by the builder images default")
+			targetGCC = gcc
+		}
 	}
-	// If builder implements GCCVersionRequestor but returns an empty semver.Version
-	// it means that it does not want to manage this kernelrelease,
-	// and instead wants to fallback to default algorithm
 	if targetGCC.EQ(semver.Version{}) {
-		targetGCC = defaultGCC(kr)
+		// if builder implements "GCCVersionRequestor" interface -> use it
+		// Else, fetch the best builder available from the kernelrelease version
+		// using the deadly simple defaultGCC() algorithm
+		// Always returns the nearest one
+		if bb, ok := builder.(GCCVersionRequestor); ok {
+			targetGCC = bb.GCCVersion(kr)
+		}
+		// If builder implements GCCVersionRequestor but returns an empty semver.Version
+		// it means that it does not want to manage this kernelrelease,
+		// and instead wants to fallback to default algorithm
+		if targetGCC.EQ(semver.Version{}) {
+			targetGCC = defaultGCC(kr)
+		}
+	}
+	// Gcc versions lower than the minimum one are never used, not even through images providing every gcc:
+	// the lowest compliant gcc is targeted instead, as long as any image provides a compliant gcc
+	if b.belowMinGCC(targetGCC) {
+		minGCC := mustParseTolerant(b.MinGCC)
+		if _, ok := b.findImage(b.Images, b.TargetType, minGCC); !ok {
+			if err := b.gccPolicyViolation(b.TargetType, targetGCC.String()); err != nil {
+				return err
+			}
+		}
+		targetGCC = minGCC
 	}
+	// Denied gcc versions are never used, not even through images providing every gcc
+	allowedGCC, ok := b.allowedGCC(b.TargetType, targetGCC)
+	if !ok {
+		return b.imageNotFound(b.TargetType, targetGCC.String())
+	}
+	targetGCC = allowedGCC
 
-	// Step 1:
 	// If we are able to either find a specific-target image,
 	// or "any" target image that provide desired gcc,
 	// we are over.
-	image, ok := b.Images.findImage(b.TargetType, targetGCC)
-	if ok {
+	// Otherwise, findImage falls back at the image that provides the nearest gcc.
+	image, ok := b.findInspectedImage(ctx, b.TargetType, targetGCC)
+	if !ok {
+		return b.imageNotFound(b.TargetType, targetGCC.String())
+	}
+	if err := b.imageSelected(b.TargetType, targetGCC, image); err != nil {
+		return err
+	}
+	if err := b.pinDigest(ctx, image); err != nil {
+		return err
+	}
+	if image.AnyGCC {
+		// The image provides the target gcc too, as long as the user allows it
+		if b.GCCVersion != "" && b.GCCVersion != GCCAuto && !isExactVersion(b.GCCVersion) && !matchesVersion(b.GCCVersion, targetGCC) {
+			return b.imageNotFound(b.TargetType, b.GCCVersion)
+		}
+		b.GCCVersion = targetGCC.String()
+	} else {
 		b.GCCVersion = image.GCCVersion.String()
+	}
+	entry := logger.WithField("targetGCC", targetGCC.String()).WithField("foundGCC", b.GCCVersion)
+	if b.GCCVersion != targetGCC.String() {
+		entry.Warn("target gcc not available, building with the found gcc")
 	} else {
-		// Step 2:
-		// Build the list of "proposed" GCC versions,
-		// that is, the list of available gccs from images
-		// for each builder image
-		proposedGCCs := make([]semver.Version, 0)
-		for _, img := range b.Images {
-			proposedGCCs = append(proposedGCCs, img.GCCVersion)
-			logger.WithField("image", img.Name).
-				WithField("targetGCC", targetGCC.String()).
-				Debug("proposedGCC=", img.GCCVersion.String())
-		}
+		entry.Debug("found gcc")
+	}
+	return nil
+}
 
-		// Now, sort versions and fetch
-		// the nearest gcc, that is also < targetGCC
-		semver.Sort(proposedGCCs)
-		lastGCC := proposedGCCs[0]
-		for _, gcc := range proposedGCCs {
-			if gcc.GT(targetGCC) {
-				break
+// imageSelected notifies the OnImageSelected callback, if any, that image was selected for target and gcc.
+// The image is a fallback when it is not a target-specific image providing exactly gcc.
+func (b *Build) imageSelected(target Type, gcc semver.Version, image Image) error {
+	if b.OnImageSelected == nil {
+		return nil
+	}
+	fallback := image.Target != target || image.AnyGCC || image.GCCVersion.NE(gcc)
+	if err := b.OnImageSelected(target, gcc, image, fallback); err != nil {
+		return fmt.Errorf("builder image %s rejected: %w", image.Name, err)
+	}
+	return nil
+}
+
+// GCCCandidates returns the gcc versions to try in auto gcc mode, see GCCAuto:
+// the best-match gcc for the kernel release comes first, followed by the other gcc versions
+// provided by the builder images for the target, from the nearest one.
+// Between equally near gcc versions, the ones provided by the image of the best-match gcc come first, see ImagesMap.ByName,
+// so that the image already pulled is reused.
+func (b *Build) GCCCandidates(ctx context.Context) ([]semver.Version, error) {
+	v, err := Factory(b.TargetType)
+	if err != nil {
+		return nil, err
+	}
+	gccVersion := b.GCCVersion
+	defer func() { b.GCCVersion = gccVersion }()
+	if err = b.setGCCVersion(ctx, v, b.KernelReleaseFromBuildConfig()); err != nil {
+		return nil, err
+	}
+	best := mustParseTolerant(b.GCCVersion)
+
+	candidates := []semver.Version{best}
+	others := make([]semver.Version, 0)
+	for _, version := range b.GCCVersionsFor(b.TargetType) {
+		if version.NE(best) {
+			others = append(others, version)
+		}
+	}
+	bundled := make(map[string]bool)
+	if bestImage, ok := b.ResolvedImage(b.TargetType, best); ok {
+		for _, named := range b.Images.ByName() {
+			if named.Name == bestImage.Name {
+				for _, v := range named.GCCVersions {
+					bundled[v.String()] = true
+				}
 			}
-			lastGCC = gcc
 		}
-		b.GCCVersion = lastGCC.String()
 	}
-	logger.WithField("targetGCC", targetGCC.String()).
-		Debug("foundGCC=", b.GCCVersion)
+	sort.SliceStable(others, func(i, j int) bool {
+		di, dj := gccDistance(others[i], best), gccDistance(others[j], best)
+		if di == dj {
+			return bundled[others[i].String()] && !bundled[others[j].String()]
+		}
+		return di < dj
+	})
+	return append(candidates, others...), nil
 }
 
-func (b *Build) GetBuilderImage() string {
-	imageTag := "latest"
-	if len(b.BuilderImage) > 0 {
-		customNames := strings.Split(b.BuilderImage, ":")
-		if customNames[0] != "auto" {
-			// BuilderImage MUST have requested GCC installed inside
-			return b.BuilderImage
-		}
+// ResolvedImage returns the loaded builder image that provides gcc for target,
+// falling back at the one providing the nearest gcc.
+// It must be called after images have been loaded.
+func (b *Build) ResolvedImage(target Type, gcc semver.Version) (Image, bool) {
+	return b.findImage(b.Images, target, gcc)
+}
+
+// ResolveImage loads the builder images and fixes the gcc version for the build,
+// returning the builder image that would be used, without generating the build script.
+func (b *Build) ResolveImage(ctx context.Context) (Image, error) {
+	v, err := Factory(b.TargetType)
+	if err != nil {
+		return Image{}, err
+	}
+	if err = b.setGCCVersion(ctx, v, b.KernelReleaseFromBuildConfig()); err != nil {
+		return Image{}, err
+	}
+	image, ok := b.ResolvedImage(b.TargetType, mustParseTolerant(b.GCCVersion))
+	if !ok {
+		return Image{}, b.imageNotFound(b.TargetType, b.GCCVersion)
+	}
+	return image, nil
+}
 
-		// Updated image tag if "auto:tag" is passed
-		if len(customNames) > 1 {
-			imageTag = customNames[1]
-		} else {
-			imageTag = "latest"
+// DefaultBuildCommand is the command running the build script inside builder images,
+// unless they provide their own one.
+var DefaultBuildCommand = []string{"/bin/bash", "/driverkit/driverkit.sh"}
+
+// GetBuildCommand returns the command running the build script inside the builder image:
+// the one provided by the resolved builder image, if any, or DefaultBuildCommand.
+func (b *Build) GetBuildCommand() []string {
+	command := DefaultBuildCommand
+	if !b.hasCustomBuilderImage() {
+		if image, ok := b.ResolvedImage(b.TargetType, mustParseTolerant(b.GCCVersion)); ok && len(image.BuildCommand) > 0 {
+			command = image.BuildCommand
 		}
 	}
+	return append([]string{}, command...)
+}
+
+// hasCustomBuilderImage returns whether the user set the builder image, instead of letting driverkit pick it.
+func (b *Build) hasCustomBuilderImage() bool {
+	return len(b.BuilderImage) > 0 && strings.Split(b.BuilderImage, ":")[0] != "auto"
+}
+
+func (b *Build) GetBuilderImage() string {
+	if b.hasCustomBuilderImage() {
+		// BuilderImage MUST have requested GCC installed inside
+		return b.BuilderImage
+	}
 
 	// NOTE: here below we are already sure that we are going
 	// to find an image, because setGCCVersion()
 	// has already set an existent gcc version
 	// (ie: one provided by an image) for us
-	image, _ := b.Images.findImage(b.TargetType, mustParseTolerant(b.GCCVersion))
+	image, _ := b.ResolvedImage(b.TargetType, mustParseTolerant(b.GCCVersion))
+	return b.taggedImageName(image)
+}
+
+// ResolvedBuilderImage returns the builder image used by the build, like GetBuilderImage,
+// and whether it was resolved, unlike when the build failed before picking it.
+func (b *Build) ResolvedBuilderImage() (string, bool) {
+	if b.hasCustomBuilderImage() {
+		return b.BuilderImage, true
+	}
+	gcc, err := semver.ParseTolerant(b.GCCVersion)
+	if err != nil {
+		return "", false
+	}
+	if _, ok := b.ResolvedImage(b.TargetType, gcc); !ok {
+		return "", false
+	}
+	return b.GetBuilderImage(), true
+}
+
+// taggedImageName returns the name of a builder image, tagged with the "auto:tag" BuilderImage tag, if any,
+// or "latest", unless already tagged or pinned to a digest.
+func (b *Build) taggedImageName(image Image) string {
+	if image.Digest != "" {
+		return image.Name + "@" + image.Digest
+	}
+	if hasTag(image.Name) {
+		// Images loaded from repository tags are already tagged
+		return image.Name
+	}
+	imageTag := "latest"
+	// Updated image tag if "auto:tag" is passed
+	if customNames := strings.Split(b.BuilderImage, ":"); customNames[0] == "auto" && len(customNames) > 1 {
+		imageTag = customNames[1]
+	}
 	return image.Name + ":" + imageTag
 }
 
+// hasTag returns whether the image name, like "ghcr.io/falcosecurity/driverkit:builder", provides a tag.
+func hasTag(name string) bool {
+	return strings.Contains(name[strings.LastIndex(name, "/")+1:], ":")
+}
+
+// sameImageName returns whether the image names refer to the same image, regardless of their tags or digests,
+// and of the "docker.io/" registry, like "docker.io/myorg/driverkit-builder-centos:latest" and "myorg/driverkit-builder-centos".
+// Images loaded from repository tags also match their repository name, like "ghcr.io/myorg/driverkit".
+func sameImageName(name, other string) bool {
+	untagged := func(name string) string {
+		if i := strings.Index(name, "@"); i >= 0 {
+			name = name[:i]
+		}
+		if hasTag(name) {
+			name = name[:strings.LastIndex(name, ":")]
+		}
+		return strings.TrimPrefix(name, "docker.io/")
+	}
+	return strings.TrimPrefix(name, "docker.io/") == strings.TrimPrefix(other, "docker.io/") || untagged(name) == untagged(other)
+}
+
 // Factory returns a builder for the given target.
 func Factory(target Type) (Builder, error) {
 	b, ok := BuilderByTarget[target]
@@ -249,28 +477,25 @@ func Factory(target Type) (Builder, error) {
 }
 
 func (c Config) toTemplateData(b Builder, kr kernelrelease.KernelRelease) commonTemplateData {
-	c.setGCCVersion(b, kr)
 	return commonTemplateData{
 		DriverBuildDir:    DriverDirectory,
 		ModuleDownloadURL: fmt.Sprintf("%s/%s.tar.gz", c.DownloadBaseURL, c.DriverVersion),
 		ModuleDriverName:  c.DriverName,
 		ModuleFullPath:    ModuleFullPath,
-		BuildModule:       len(c.ModuleFilePath) > 0,
-		BuildProbe:        len(c.ProbeFilePath) > 0,
+		BuildModule:       c.BuildsModule(),
+		BuildProbe:        c.BuildsProbe(),
 		GCCVersion:        c.GCCVersion,
 	}
 }
 
-func resolveURLReference(u string) string {
+func resolveURLReference(u string) (string, error) {
 	uu, err := url.Parse(u)
 	if err != nil {
-		log.Fatal(err)
-	}
-	base, err := url.Parse(uu.Host)
-	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return base.ResolveReference(uu).String()
+	// Resolving an absolute url against itself only removes its dot segments,
+	// while its host, parsed as a url, fails when it has a port
+	return uu.ResolveReference(uu).String(), nil
 }
 
 func getResolvingURLs(urls []string) ([]string, error) {
@@ -281,12 +506,11 @@ func getResolvingURLs(urls []string) ([]string, error) {
 		// neither it is expected, because they are effectively valid urls),
 		// resolve the absolute one.
 		// HEAD would fail otherwise.
-		u = resolveURLReference(u)
-		res, err := http.Head(u)
+		u, err := resolveURLReference(u)
 		if err != nil {
-			continue
+			return nil, err
 		}
-		if res.StatusCode == http.StatusOK {
+		if urlResolves(u) {
 			results = append(results, u)
 			logger.WithField("url", u).Debug("kernel header url found")
 		}
@@ -296,3 +520,36 @@ func getResolvingURLs(urls []string) ([]string, error) {
 	}
 	return results, nil
 }
+
+// resolvingURLs is like getResolvingURLs, also keeping the urls only served by one of their HeadersMirrors,
+// since build scripts download them from their mirrors too.
+func (b *Build) resolvingURLs(urls []string) ([]string, error) {
+	var results []string
+	for _, u := range urls {
+		u, err := resolveURLReference(u)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range b.mirrorURLs(u) {
+			if urlResolves(candidate) {
+				results = append(results, u)
+				logger.WithField("url", u).WithField("mirror", candidate).Debug("kernel header url found")
+				break
+			}
+		}
+	}
+	if len(results) == 0 {
+		return nil, HeadersNotFoundErr
+	}
+	return results, nil
+}
+
+// urlResolves returns whether u is found.
+func urlResolves(u string) bool {
+	res, err := http.Head(u)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK
+}
@@ -1,6 +1,8 @@
 package builder
 
 import (
+	"context"
+	"errors"
 	"github.com/blang/semver"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"testing"
@@ -90,3 +92,83 @@ func TestDefaultGCC(t *testing.T) {
 		}
 	}
 }
+
+func TestHasTag(t *testing.T) {
+	tests := map[string]bool{
+		"falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0":            false,
+		"localhost:5000/falcosecurity/driverkit-builder":                 false,
+		"ghcr.io/falcosecurity/driverkit:builder-any-x86_64_gcc8.0.0":    true,
+		"localhost:5000/driverkit:driverkit-builder-any-x86_64_gcc8.0.0": true,
+	}
+	for name, expected := range tests {
+		if hasTag(name) != expected {
+			t.Fatalf("hasTag(%s) != %v", name, expected)
+		}
+	}
+}
+
+func TestResolveImagePartialGCC(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("9.1.0"), Name: "centos-builder-9.1"},
+		{Target: "any", GCCVersion: semver.MustParse("9.3.0"), Name: "any-builder-9.3"},
+		{Target: "any", GCCVersion: semver.MustParse("10.2.0"), Name: "any-builder-10.2"},
+	}
+	tests := map[string]string{
+		"9":     "centos-builder-9.1", // the nearest gcc of the same major of the target wins over "any" target images
+		"9.1":   "centos-builder-9.1",
+		"10":    "any-builder-10.2",
+		"9.1.0": "centos-builder-9.1",
+	}
+	for gcc, expected := range tests {
+		b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: "3.10.0-957.el7.x86_64", GCCVersion: gcc, ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+		image, err := b.ResolveImage(context.Background())
+		if err != nil {
+			t.Fatalf("ResolveImage() with gcc %s failed: %v", gcc, err)
+		}
+		if image.Name != expected {
+			t.Fatalf("ResolveImage() with gcc %s = %s, expected %s", gcc, image.Name, expected)
+		}
+	}
+
+	b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: "3.10.0-957.el7.x86_64", GCCVersion: "8", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	if _, err := b.ResolveImage(context.Background()); !errors.Is(err, ErrNoImages) {
+		t.Fatalf("ResolveImage() with gcc 8 returned %v, expected ErrNoImages", err)
+	}
+}
+
+func TestResolveImageGCCList(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.0"), Name: "centos-builder-4.8"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.3.0"), Name: "centos-builder-8.3"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.1.0"), Name: "centos-builder-9.1"},
+		{Target: "any", GCCVersion: semver.MustParse("9.3.0"), Name: "any-builder-9.3"},
+		{Target: "any", GCCVersion: semver.MustParse("11.2.0"), Name: "any-builder-11.2"},
+	}
+	tests := map[string]struct {
+		kernelrelease string
+		gcc           string
+		expected      string
+	}{
+		"lowest allowed for old kernels":  {"3.10.0-957.el7.x86_64", "8,9", "centos-builder-8.3"},
+		"best match allowed":              {"4.18.0-348.el8.x86_64", "8,9", "centos-builder-8.3"},
+		"nearest lower allowed":           {"5.14.0-70.el9.x86_64", "8,9", "centos-builder-9.1"},
+		"exact version and major allowed": {"5.14.0-70.el9.x86_64", "9.3.0, 4", "any-builder-9.3"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: test.kernelrelease, GCCVersion: test.gcc, ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+			image, err := b.ResolveImage(context.Background())
+			if err != nil {
+				t.Fatalf("ResolveImage() with gcc %s failed: %v", test.gcc, err)
+			}
+			if image.Name != test.expected {
+				t.Fatalf("ResolveImage() with gcc %s = %s, expected %s", test.gcc, image.Name, test.expected)
+			}
+			for _, img := range b.Images {
+				if !matchesVersion(test.gcc, img.GCCVersion) {
+					t.Fatalf("LoadImages() with gcc %s loaded %s, whose gcc is not in the list", test.gcc, img.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/blang/semver"
+)
+
+// gccCheckScript returns the bash lines failing the build script when gcc, the compiler the kernel module is built with,
+// is not the gcc version selected for the build, like when the gcc versions claimed by the builder images list
+// drifted from the actual contents of the image. Only the components set in the selected version are compared:
+// "8.0.0" is matched by any gcc 8, while "4.8.5" is only matched by gcc 4.8.5.
+func gccCheckScript(gcc string, gccVersion string) string {
+	prefix := gccVersion
+	if v, err := semver.ParseTolerant(gccVersion); err == nil {
+		prefix = fmt.Sprintf("%d", v.Major)
+		if v.Minor != 0 || v.Patch != 0 {
+			prefix += fmt.Sprintf(".%d", v.Minor)
+		}
+		if v.Patch != 0 {
+			prefix += fmt.Sprintf(".%d", v.Patch)
+		}
+	}
+	return fmt.Sprintf(`# Verify the gcc of the builder image
+if ! gcc_version=$(%[1]s -dumpfullversion -dumpversion); then
+  echo "gcc %[2]s not found in the builder image at %[4]s" >&2
+  exit 1
+fi
+if [[ "$gcc_version" != %[3]s && "$gcc_version" != %[3]s.* ]]; then
+  echo "gcc mismatch: gcc %[2]s is expected, but the builder image provides gcc $gcc_version at %[4]s" >&2
+  exit 1
+fi
+`, shellQuote(gcc), gccVersion, shellQuote(prefix), gcc)
+}
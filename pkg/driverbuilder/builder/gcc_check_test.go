@@ -0,0 +1,50 @@
+package builder
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestGCCCheckScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	dir := t.TempDir()
+
+	tests := map[string]struct {
+		installed string // empty when gcc is missing
+		expected  string
+		err       string
+	}{
+		"major match":     {installed: "8.3.0", expected: "8.0.0"},
+		"exact match":     {installed: "4.8.5", expected: "4.8.5"},
+		"minor match":     {installed: "4.8.5", expected: "4.8.0"},
+		"major mismatch":  {installed: "10.2.1", expected: "8.0.0", err: "gcc mismatch: gcc 8.0.0 is expected, but the builder image provides gcc 10.2.1"},
+		"prefix mismatch": {installed: "80.1.0", expected: "8.0.0", err: "gcc mismatch"},
+		"patch mismatch":  {installed: "4.8.2", expected: "4.8.5", err: "gcc mismatch"},
+		"gcc not found":   {expected: "9.0.0", err: "gcc 9.0.0 not found in the builder image"},
+		"major only":      {installed: "11", expected: "11"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			gcc := filepath.Join(dir, "gcc-"+test.expected)
+			os.Remove(gcc)
+			if test.installed != "" {
+				assert.NilError(t, os.WriteFile(gcc, []byte("#!/bin/bash\necho "+test.installed+"\n"), 0755))
+			}
+			out, err := exec.Command("bash", "-euo", "pipefail", "-c", gccCheckScript(gcc, test.expected)+"echo verified").CombinedOutput()
+			if test.err != "" {
+				assert.Assert(t, err != nil)
+				assert.Assert(t, strings.Contains(string(out), test.err), string(out))
+				return
+			}
+			assert.NilError(t, err, string(out))
+			assert.Equal(t, "verified\n", string(out))
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	"gopkg.in/yaml.v3"
+)
+
+// GCCRule sets the gcc version of the builds for the kernel releases it matches, like the gcc a kernel family builds best with.
+// A rule matches a kernel release when it matches all of its KernelRelease and KernelVersion, at least one of them being set.
+type GCCRule struct {
+	// KernelRelease is a regex matching the whole kernel release, like `\.el7\.`
+	KernelRelease string `yaml:"kernel_release,omitempty"`
+	// KernelVersion is a kernel version range, like ">=5.15.0 <6.0.0", or a partial kernel version, like "4.18"
+	KernelVersion string `yaml:"kernel_version,omitempty"`
+	// GCC is the gcc version of the matching builds, with the same syntax of Build.GCCVersion, like "8.3.0", "11" or ">=9.0.0 <11.0.0"
+	GCC string `yaml:"gcc"`
+
+	kernelRelease *regexp.Regexp
+}
+
+// GCCRulesFile is the yaml representation of a list of gcc rules, in order of precedence.
+type GCCRulesFile struct {
+	Rules []GCCRule `yaml:"rules"`
+}
+
+// LoadGCCRules loads and checks the gcc rules of the yaml file at filePath,
+// in the `rules: [ { kernel_release: <regex>, kernel_version: <range>, gcc: <gcc-version> },...]` format.
+func LoadGCCRules(filePath string) ([]GCCRule, error) {
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening gcc rules file %s: %w", filePath, err)
+	}
+	var rulesFile GCCRulesFile
+	dec := yaml.NewDecoder(bytes.NewReader(file))
+	dec.KnownFields(true)
+	if err = dec.Decode(&rulesFile); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error unmarshalling gcc rules file %s: %w", filePath, err)
+	}
+	for i := range rulesFile.Rules {
+		if err = rulesFile.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid gcc rules file %s: rule %d: %w", filePath, i+1, err)
+		}
+	}
+	return rulesFile.Rules, nil
+}
+
+// compile checks the rule, compiling its kernel release regex.
+func (r *GCCRule) compile() error {
+	if r.KernelRelease == "" && r.KernelVersion == "" {
+		return errors.New("expected a kernel_release regex or a kernel_version range")
+	}
+	if r.GCC == "" {
+		return errors.New("expected a gcc version")
+	}
+	if !isExactVersion(r.GCC) {
+		if _, partial := partialVersionRange(r.GCC); !partial {
+			if _, err := semver.ParseRange(r.GCC); err != nil {
+				return fmt.Errorf("wrong gcc version %s: %w", r.GCC, err)
+			}
+		}
+	}
+	if r.KernelVersion != "" && !isExactVersion(r.KernelVersion) {
+		if _, partial := partialVersionRange(r.KernelVersion); !partial {
+			if _, err := semver.ParseRange(r.KernelVersion); err != nil {
+				return fmt.Errorf("wrong kernel version range %s: %w", r.KernelVersion, err)
+			}
+		}
+	}
+	if r.KernelRelease != "" {
+		reg, err := regexp.Compile(r.KernelRelease)
+		if err != nil {
+			return fmt.Errorf("wrong kernel release regex %s: %w", r.KernelRelease, err)
+		}
+		r.kernelRelease = reg
+	}
+	return nil
+}
+
+// matches returns whether the rule matches the kernel release, like "3.10.0-957.el7.x86_64".
+func (r *GCCRule) matches(kernelRelease string) bool {
+	if r.KernelRelease != "" {
+		if r.kernelRelease == nil {
+			// Rule not loaded through LoadGCCRules
+			if err := r.compile(); err != nil {
+				return false
+			}
+		}
+		if !r.kernelRelease.MatchString(kernelRelease) {
+			return false
+		}
+	}
+	if r.KernelVersion != "" && !matchesVersion(r.KernelVersion, kernelrelease.FromString(kernelRelease).Version) {
+		return false
+	}
+	return true
+}
+
+// gccFromRules returns the gcc version of the first gcc rule matching the kernel release of the build, if any.
+func (b *Build) gccFromRules() (string, bool) {
+	for i := range b.GCCRules {
+		if b.GCCRules[i].matches(b.KernelRelease) {
+			return b.GCCRules[i].GCC, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"gotest.tools/assert"
+)
+
+func TestLoadGCCRules(t *testing.T) {
+	tests := map[string]struct {
+		rules       string
+		expected    int
+		expectedErr string
+	}{
+		"valid": {
+			rules: `rules:
+  - kernel_release: '\.el7\.'
+    gcc: "4.8"
+  - kernel_version: ">=5.15.0 <6.0.0"
+    kernel_release: "-generic$"
+    gcc: ">=11.0.0"
+  - kernel_version: "4.18"
+    gcc: "8.3.0"
+`,
+			expected: 3,
+		},
+		"no kernel": {
+			rules:       "rules:\n  - gcc: \"4.8\"\n",
+			expectedErr: "rule 1: expected a kernel_release regex or a kernel_version range",
+		},
+		"no gcc": {
+			rules:       "rules:\n  - kernel_version: \"4.18\"\n",
+			expectedErr: "rule 1: expected a gcc version",
+		},
+		"wrong gcc": {
+			rules:       "rules:\n  - kernel_version: \"4.18\"\n    gcc: latest\n",
+			expectedErr: "rule 1: wrong gcc version latest",
+		},
+		"wrong kernel version": {
+			rules:       "rules:\n  - kernel_version: \">=five\"\n    gcc: \"9\"\n",
+			expectedErr: "rule 1: wrong kernel version range >=five",
+		},
+		"wrong kernel release": {
+			rules:       "rules:\n  - kernel_release: \"el7(\"\n    gcc: \"9\"\n",
+			expectedErr: "rule 1: wrong kernel release regex el7(",
+		},
+		"unknown field": {
+			rules:       "rules:\n  - kernel: \"4.18\"\n    gcc: \"9\"\n",
+			expectedErr: "field kernel not found",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "rules.yaml")
+			assert.NilError(t, os.WriteFile(filePath, []byte(test.rules), 0644))
+			rules, err := LoadGCCRules(filePath)
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expected, len(rules))
+		})
+	}
+}
+
+func TestGCCRules(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.5"), Name: "centos-gcc4"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.3.0"), Name: "centos-gcc8"},
+		{Target: "centos", GCCVersion: semver.MustParse("11.2.0"), Name: "centos-gcc11"},
+	}
+	rules := []GCCRule{
+		{KernelRelease: `\.el7\.`, GCC: "4.8"},
+		{KernelVersion: ">=5.10.0", GCC: ">=11.0.0"},
+		{KernelVersion: "4.18", KernelRelease: `\.el8\.`, GCC: "11"},
+	}
+
+	tests := map[string]struct {
+		kernelrelease string
+		gcc           string
+		expectedGCC   string
+	}{
+		"kernel release rule": {
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			expectedGCC:   "4.8.5",
+		},
+		"kernel version range rule": {
+			kernelrelease: "5.14.0-70.el9.x86_64",
+			expectedGCC:   "11.2.0",
+		},
+		"both kernel release and version rule": {
+			kernelrelease: "4.18.0-348.el8.x86_64",
+			expectedGCC:   "11.2.0",
+		},
+		"no matching rule": {
+			kernelrelease: "5.4.0-1.x86_64",
+			expectedGCC:   "11.2.0",
+		},
+		"gcc set by user": {
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			gcc:           "8.3.0",
+			expectedGCC:   "8.3.0",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: test.kernelrelease, GCCVersion: test.gcc, GCCRules: rules, ImagesListers: []ImagesLister{lister}}
+			_, err := b.ResolveImage(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedGCC, b.GCCVersion)
+		})
+	}
+}
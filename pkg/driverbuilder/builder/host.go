@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// HostOSReleaseFile is the file describing the distribution of the running host.
+const HostOSReleaseFile = "/etc/os-release"
+
+// unameKernelVersion matches the kernel version in the `uname -v` output, like "59" in "#59-Ubuntu SMP ...".
+var unameKernelVersion = regexp.MustCompile(`^#(\d+)`)
+
+// Host describes the running host, as detected by DetectHost.
+type Host struct {
+	Target        Type
+	KernelRelease string
+	KernelVersion string
+	Architecture  string
+}
+
+// DetectHost detects the target, kernel release, kernel version and architecture of the running host,
+// from HostOSReleaseFile and uname, resolving the distribution to a target through aliases, see ParseOSRelease.
+func DetectHost(aliases TargetAliases) (*Host, error) {
+	osRelease, err := os.ReadFile(HostOSReleaseFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the distribution of the running host: %w", err)
+	}
+	target, err := ParseOSRelease(osRelease, aliases)
+	if err != nil {
+		return nil, err
+	}
+	release, err := uname("-r")
+	if err != nil {
+		return nil, err
+	}
+	if kr := kernelrelease.FromString(release); kr.Fullversion == "" {
+		return nil, fmt.Errorf("unexpected kernel release of the running host: %s", release)
+	}
+	version, err := uname("-v")
+	if err != nil {
+		return nil, err
+	}
+	machine, err := uname("-m")
+	if err != nil {
+		return nil, err
+	}
+	arch, err := kernelrelease.ParseArchitecture(machine)
+	if err != nil {
+		return nil, err
+	}
+	return &Host{
+		Target:        target,
+		KernelRelease: release,
+		KernelVersion: parseUnameKernelVersion(version),
+		Architecture:  arch.String(),
+	}, nil
+}
+
+// ParseOSRelease returns the target of the distribution described by the os-release file data,
+// either its ID or one of its ID_LIKE ones, resolved through aliases, like "redhat" for "rhel";
+// the amazonlinux targets are picked according to VERSION_ID, and every openSUSE flavor is the opensuse target.
+func ParseOSRelease(data []byte, aliases TargetAliases) (Type, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && !strings.HasPrefix(key, "#") {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	ids := append([]string{fields["ID"]}, strings.Fields(fields["ID_LIKE"])...)
+	for _, id := range ids {
+		switch {
+		case id == "amzn" && fields["VERSION_ID"] == "2":
+			id = "amzn2"
+		case id == "amzn" && fields["VERSION_ID"] >= "2022":
+			id = "amzn2022"
+		case strings.HasPrefix(id, "opensuse"):
+			id = TargetTypeOpenSUSE.String()
+		}
+		target := aliases.Resolve(id)
+		if _, ok := BuilderByTarget[target]; ok {
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported distribution of the running host: %s", fields["ID"])
+}
+
+// parseUnameKernelVersion returns the kernel version in the `uname -v` output, or "1" when missing.
+func parseUnameKernelVersion(version string) string {
+	if match := unameKernelVersion.FindStringSubmatch(version); match != nil {
+		return match[1]
+	}
+	return "1"
+}
+
+func uname(flag string) (string, error) {
+	out, err := exec.Command("uname", flag).Output()
+	if err != nil {
+		return "", fmt.Errorf("error running uname %s: %w", flag, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := map[string]struct {
+		osRelease string
+		aliases   TargetAliases
+		expected  Type
+		err       string
+	}{
+		"ubuntu":          {osRelease: "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\n", expected: TargetTypeUbuntu},
+		"default alias":   {osRelease: "ID=\"rhel\"\nID_LIKE=\"fedora\"\nVERSION_ID=\"8.6\"\n", expected: TargetTypeRedhat},
+		"oracle":          {osRelease: "ID=\"ol\"\nID_LIKE=\"fedora\"\n", expected: TargetTypeoracle},
+		"amazonlinux":     {osRelease: "ID=\"amzn\"\nVERSION_ID=\"2018.03\"\n", expected: TargetTypeAmazonLinux},
+		"amazonlinux2":    {osRelease: "ID=\"amzn\"\nVERSION_ID=\"2\"\n", expected: TargetTypeAmazonLinux2},
+		"amazonlinux2022": {osRelease: "ID=\"amzn\"\nVERSION_ID=\"2022\"\n", expected: TargetTypeAmazonLinux2022},
+		"opensuse":        {osRelease: "ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", expected: TargetTypeOpenSUSE},
+		"id like":         {osRelease: "ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n", expected: TargetTypeUbuntu},
+		"custom alias":    {osRelease: "ID=centos-stream\n", aliases: TargetAliases{"centos-stream": TargetTypeCentos}, expected: TargetTypeCentos},
+		"comments":        {osRelease: "# generated\nID=arch\n", expected: TargetTypeArchlinux},
+		"unsupported":     {osRelease: "ID=gentoo\n", err: "unsupported distribution of the running host: gentoo"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			target, err := ParseOSRelease([]byte(test.osRelease), test.aliases)
+			if test.err != "" {
+				assert.Error(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expected, target)
+		})
+	}
+}
+
+func TestParseUnameKernelVersion(t *testing.T) {
+	assert.Equal(t, "59", parseUnameKernelVersion("#59-Ubuntu SMP Tue Nov 5 12:34:56 UTC 2019"))
+	assert.Equal(t, "1", parseUnameKernelVersion("#1 SMP PREEMPT_DYNAMIC Debian 6.1.55-1 (2023-09-29)"))
+	assert.Equal(t, "1", parseUnameKernelVersion("SMP"))
+}
@@ -1,157 +1,1342 @@
 package builder
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/blang/semver"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	logger "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
-	"log"
+	"io"
+	"math"
+	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
 type YAMLImage struct {
-	Target      string   `yaml:"target"`
-	GCCVersions []string `yaml:"gcc_versions"` // we expect images to internally link eg: gcc5 to gcc5.0.0
-	Name        string   `yaml:"name"`
+	Target       string   `yaml:"target"`
+	GCCVersions  []string `yaml:"gcc_versions"` // we expect images to internally link eg: gcc5 to gcc5.0.0; see WildcardGCC
+	ClangVersion string   `yaml:"clang_version,omitempty"`
+	Name         string   `yaml:"name"`
+	MultiArch    bool     `yaml:"multi_arch,omitempty"`
+	Digest       string   `yaml:"digest,omitempty"` // pins the image, like "sha256:..."
+	// MinKernel and MaxKernel are the kernel releases range, inclusive, the image can build for, like "2.6.32";
+	// either of them can be empty, meaning that the range is unbounded on that side
+	MinKernel string `yaml:"min_kernel,omitempty"`
+	MaxKernel string `yaml:"max_kernel,omitempty"`
+	// DistroVersion is the distro major version the image is specific to, like "8" for a CentOS 8 one; empty means any version
+	DistroVersion string `yaml:"distro_version,omitempty"`
+	// BuildCommand is the command running the build script inside the image, like [ "/bin/sh", "/driverkit/driverkit.sh" ];
+	// empty means DefaultBuildCommand
+	BuildCommand []string `yaml:"build_command,omitempty"`
+	// DefaultGCC is the gcc version, among GCCVersions, building for the image target when the user does not request any gcc,
+	// in place of the one picked for the kernel release
+	DefaultGCC string `yaml:"default_gcc,omitempty"`
 }
 
 type YAMLImagesList struct {
 	Images []YAMLImage `yaml:"images"`
+	// Include lists other images list files, relative to the including one, whose images are loaded too;
+	// only supported by images list files, not by remote ones
+	Include []string `yaml:"include,omitempty"`
 }
 
 type Image struct {
-	Target     Type
-	GCCVersion semver.Version // we expect images to internally link eg: gcc5 to gcc5.0.0
-	Name       string
+	Target       Type
+	GCCVersion   semver.Version // we expect images to internally link eg: gcc5 to gcc5.0.0
+	ClangVersion semver.Version // clang used to build the eBPF probe; empty when unknown
+	Name         string
+	MultiArch    bool           // image is a multi-arch manifest list, whose name has no architecture
+	MinKernel    semver.Version // lowest kernel release the image can build for; unbounded when empty
+	MaxKernel    semver.Version // highest kernel release the image can build for; unbounded when empty
+	Digest       string         // pins the image, like "sha256:..."; empty when the image is only tagged
+	AnyGCC       bool           // image provides every gcc version, see WildcardGCC; GCCVersion is empty
+	BuildCommand []string       // command running the build script inside the image; empty means DefaultBuildCommand
+	// DistroVersion is the distro major version the image is specific to, like "8" for "centos8" images,
+	// only used to build for kernel releases of that version, see kernelrelease.KernelRelease.DistroVersion; empty means any version
+	DistroVersion string
+	// DefaultGCC tells that GCCVersion is the default gcc of Target, used when the user does not request any gcc; see YAMLImage.DefaultGCC
+	DefaultGCC bool
+	// Arch is the deb form of the image architecture, like "amd64", as found by the builder repos naming images after it;
+	// empty when unknown, like for images lists, and for multi-arch images
+	Arch string
 }
 
+// WildcardGCC is the gcc version of images lists entries meaning that the image provides every gcc version,
+// like images bundling many gcc versions. Such images are only used when no image provides the exact gcc.
+const WildcardGCC = "*"
+
 type ImagesLister interface {
-	LoadImages() []Image
+	// LoadImages loads the images; ctx governs any remote discovery.
+	LoadImages(ctx context.Context) ([]Image, error)
+}
+
+// PrioritizedImagesLister wraps an ImagesLister, giving it an explicit priority.
+// When the same image key is provided by multiple listers, the one with the highest priority wins;
+// listers that are not wrapped have priority 0.
+type PrioritizedImagesLister struct {
+	ImagesLister
+	Priority int
+}
+
+// LastResortImagesLister wraps an ImagesLister only used when no other lister of the build provides any image,
+// like when none of them is configured or all of them fail. It is loaded after every other lister, whatever their priority.
+type LastResortImagesLister struct {
+	ImagesLister
+}
+
+func (l *LastResortImagesLister) String() string {
+	return listerName(l.ImagesLister)
+}
+
+// ParseRepoPriority parses a "<repo>=<priority>" string, like "/path/to/images.yaml=10".
+func ParseRepoPriority(s string) (string, int, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("repo priority must be in the <repo>=<priority> form: %s", s)
+	}
+	priority, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("repo priority must be an integer: %s", s)
+	}
+	return s[:i], priority, nil
+}
+
+// ParseRepoChecksum parses a "<repo>=sha256:<hex>" string, like "/path/to/images.yaml=sha256:e3b0c442...",
+// returning the repo and the lowercase hex sha256.
+func ParseRepoChecksum(s string) (string, string, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("repo checksum must be in the <repo>=sha256:<hex> form: %s", s)
+	}
+	checksum := strings.ToLower(strings.TrimPrefix(s[i+1:], "sha256:"))
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != sha256.Size*2 {
+		return "", "", fmt.Errorf("repo checksum must be a sha256, like sha256:<64 hex digits>: %s", s)
+	}
+	return s[:i], checksum, nil
+}
+
+// ChecksumMismatchError is returned when a builder repo file does not match its expected checksum,
+// like when it was tampered with or truncated.
+type ChecksumMismatchError struct {
+	FilePath string
+	Expected string // hex sha256
+	Actual   string // hex sha256
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for builder repo file %s: expected sha256:%s, got sha256:%s", e.FilePath, e.Expected, e.Actual)
+}
+
+// verifyChecksum checks that the file read from filePath matches the expected hex sha256, if any.
+func verifyChecksum(filePath string, file []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	sum := sha256.Sum256(file)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return &ChecksumMismatchError{FilePath: filePath, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+func (l *PrioritizedImagesLister) String() string {
+	return listerName(l.ImagesLister)
+}
+
+// ParsePinnedImage parses a "<target>_<gcc>=<name>@<digest>" string, like
+// "centos_9.3.0=falcosecurity/driverkit-builder-centos@sha256:...", into an image pinned to its digest.
+func ParsePinnedImage(s string) (Image, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return Image{}, fmt.Errorf("pinned image must be in the <target>_<gcc>=<name>@<digest> form: %s", s)
+	}
+	key, ref := s[:i], s[i+1:]
+	target, gcc, ok := strings.Cut(key, "_")
+	if !ok {
+		return Image{}, fmt.Errorf("pinned image key must be in the <target>_<gcc> form: %s", s)
+	}
+	if _, ok = BuilderByTarget[Type(target)]; !ok && target != "any" {
+		return Image{}, fmt.Errorf("pinned image target must be \"any\" or a supported one: %s", s)
+	}
+	gccVersion, err := semver.ParseTolerant(gcc)
+	if err != nil {
+		return Image{}, fmt.Errorf("pinned image gcc must be a version: %s", s)
+	}
+	name, digest, ok := strings.Cut(ref, "@")
+	if !ok || name == "" || !strings.Contains(digest, ":") {
+		return Image{}, fmt.Errorf("pinned image must provide a digest, like <name>@sha256:<hex>: %s", s)
+	}
+	return Image{Target: Type(target), GCCVersion: gccVersion, Name: name, Digest: digest}, nil
+}
+
+// listerName returns the name of the lister, like its repo, used to identify it in logs and stats.
+func listerName(l ImagesLister) string {
+	if s, ok := l.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", l)
+}
+
+// listerPriority returns the priority of the lister.
+func listerPriority(l ImagesLister) int {
+	switch pl := l.(type) {
+	case *PrioritizedImagesLister:
+		return pl.Priority
+	case *LastResortImagesLister:
+		return math.MinInt
+	}
+	return 0
 }
 
+// FileImagesLister loads images from yaml image list files.
+// FilePath, and each of Paths, can be a single file, a directory or a glob pattern.
+// Images whose target is not a known one are skipped with a warning, or fail the load when Strict is set.
 type FileImagesLister struct {
 	FilePath string
+	// Paths are loaded after FilePath, each one overriding the images of the preceding ones for the same key,
+	// like environment-specific overrides of a base images list
+	Paths   []string
+	Strict  bool
+	Aliases TargetAliases // resolves images targets, in addition to DefaultTargetAliases
+	// Checksum is the expected sha256 of the FilePath file, in hex, verified before parsing it; see ParseRepoChecksum.
+	// It requires FilePath to be a single file, and does not cover Paths nor the included files
+	Checksum string
+}
+
+func (f *FileImagesLister) String() string {
+	return strings.Join(f.paths(), ",")
+}
+
+// paths returns FilePath and Paths, skipping the empty ones.
+func (f *FileImagesLister) paths() []string {
+	var paths []string
+	for _, path := range append([]string{f.FilePath}, f.Paths...) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
 }
 
 type RepoImagesLister struct {
-	repo string
+	repo        string
+	auth        RegistryAuth
+	cache       ImagesCache
+	maxAttempts int
+	searchLimit int              // see Build.RegistrySearchLimit
+	proxy       string           // see Build.RegistryProxy
+	regs        []*regexp.Regexp // see repoRegexes
+	arch        string           // deb form of the build architecture, like "amd64"
+	archs       []archRegexes    // in all architectures mode, the regexes of each supported architecture, used in place of regs
+	aliases     TargetAliases
+}
+
+// archRegexes are the image regexes for an architecture, see repoArchRegexes.
+type archRegexes struct {
+	arch string // deb form, like "amd64"
+	regs []*regexp.Regexp
 }
 
+// MaxRegistrySearchLimit is the maximum number of results of a docker repository search,
+// as enforced by the docker daemon.
+const MaxRegistrySearchLimit = 100
+
+// TagsImagesLister loads images from the tags of a repository,
+// using the registry v2 API directly instead of the docker daemon search,
+// that is not implemented by registries like GHCR or quay.io.
+type TagsImagesLister struct {
+	repo    string
+	auth    RegistryAuth
+	arch    string // deb form of the build architecture, like "amd64"
+	proxy   string // see Build.RegistryProxy
+	cache   ImagesCache
+	regs    []*regexp.Regexp // see repoRegexes
+	aliases TargetAliases
+}
+
+func (repo *RepoImagesLister) String() string {
+	return repo.repo
+}
+
+func (repo *TagsImagesLister) String() string {
+	return TagsRepoScheme + repo.repo
+}
+
+// TagsRepoScheme is the scheme of builder repos whose images must be loaded by a TagsImagesLister,
+// like "oci://ghcr.io/falcosecurity/driverkit-builder".
+const TagsRepoScheme = "oci://"
+
 type ImageKey string
 
+// String describes the image, like "centos/gcc9.3.0 -> falcosecurity/driverkit-builder-centos",
+// or "centos8/gcc9.3.0 -> falcosecurity/driverkit-builder-centos8" for distro version specific images.
+func (i Image) String() string {
+	var sb strings.Builder
+	sb.WriteString(i.Target.String() + i.DistroVersion)
+	sb.WriteString("/gcc" + i.GCCVersionString())
+	if i.ClangVersion.NE(semver.Version{}) {
+		sb.WriteString("/clang" + i.ClangVersion.String())
+	}
+	if i.MultiArch {
+		sb.WriteString("/multiarch")
+	}
+	sb.WriteString(" -> " + i.Name)
+	if i.Digest != "" {
+		sb.WriteString("@" + i.Digest)
+	}
+	return sb.String()
+}
+
+// GCCVersionString returns the gcc version provided by the image, or WildcardGCC when it provides every one.
+func (i Image) GCCVersionString() string {
+	if i.AnyGCC {
+		return WildcardGCC
+	}
+	return i.GCCVersion.String()
+}
+
+// jsonImage is the json representation of an Image, omitting unknown versions and unbounded kernels.
+type jsonImage struct {
+	Target        Type     `json:"target"`
+	GCCVersion    string   `json:"gcc_version"`
+	ClangVersion  string   `json:"clang_version,omitempty"`
+	Name          string   `json:"name"`
+	MultiArch     bool     `json:"multi_arch,omitempty"`
+	MinKernel     string   `json:"min_kernel,omitempty"`
+	MaxKernel     string   `json:"max_kernel,omitempty"`
+	Digest        string   `json:"digest,omitempty"`
+	BuildCommand  []string `json:"build_command,omitempty"`
+	DistroVersion string   `json:"distro_version,omitempty"`
+	DefaultGCC    bool     `json:"default_gcc,omitempty"`
+	Arch          string   `json:"arch,omitempty"`
+}
+
+// versionString returns the version as a string, or an empty string when it is unknown.
+func versionString(v semver.Version) string {
+	if v.EQ(semver.Version{}) {
+		return ""
+	}
+	return v.String()
+}
+
+func (i Image) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonImage{
+		Target:        i.Target,
+		GCCVersion:    i.GCCVersionString(),
+		ClangVersion:  versionString(i.ClangVersion),
+		Name:          i.Name,
+		MultiArch:     i.MultiArch,
+		MinKernel:     versionString(i.MinKernel),
+		MaxKernel:     versionString(i.MaxKernel),
+		Digest:        i.Digest,
+		BuildCommand:  i.BuildCommand,
+		DistroVersion: i.DistroVersion,
+		DefaultGCC:    i.DefaultGCC,
+		Arch:          i.Arch,
+	})
+}
+
 func (i *Image) toKey() ImageKey {
-	return ImageKey(i.Target.String() + "_" + i.GCCVersion.String())
+	// Build metadata does not take part in gcc versions comparisons, like semver.Version.EQ
+	gccVersion := i.GCCVersion
+	gccVersion.Build = nil
+	key := i.Target.String() + "_" + gccVersion.String()
+	if i.AnyGCC {
+		key = i.Target.String() + "_" + WildcardGCC
+	}
+	if i.MultiArch {
+		key += "_multiarch"
+	}
+	// Images of different architectures do not collide, like when loaded for multiple architectures at once
+	if i.Arch != "" {
+		key += "_" + i.Arch
+	}
+	return ImageKey(key)
+}
+
+func (k ImageKey) String() string {
+	return string(k)
 }
 
 type ImagesMap map[ImageKey]Image
 
-var repoRegs = make([]*regexp.Regexp, 0, 2)
+// MarshalJSON encodes the images as a list sorted by their keys, so that the output is stable.
+func (images ImagesMap) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(images))
+	for key := range images {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	list := make([]Image, 0, len(images))
+	for _, key := range keys {
+		list = append(list, images[ImageKey(key)])
+	}
+	return json.Marshal(list)
+}
+
+// Sorted returns the images sorted by target, then gcc version, with images providing every gcc first,
+// then architecture-specific images before multi-arch ones, so that the result is deterministic,
+// like to compare the images loaded by different driverkit versions.
+func (images ImagesMap) Sorted() []Image {
+	list := make([]Image, 0, len(images))
+	for _, img := range images {
+		list = append(list, img)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.AnyGCC != b.AnyGCC {
+			return a.AnyGCC
+		}
+		if a.GCCVersion.NE(b.GCCVersion) {
+			return a.GCCVersion.LT(b.GCCVersion)
+		}
+		if a.MultiArch != b.MultiArch {
+			return !a.MultiArch
+		}
+		return a.Name < b.Name
+	})
+	return list
+}
+
+// NamedImage is a builder image, as named in builder repos, with every target and gcc version it provides; see ImagesMap.ByName.
+type NamedImage struct {
+	Name        string
+	Targets     []Type           // in lexical order
+	GCCVersions []semver.Version // in ascending order
+	AnyGCC      bool             // the image provides every gcc
+}
+
+// ByName groups the images by name, in lexical order, with the union of the targets and gcc versions they provide,
+// like when an images list describes the same image in multiple entries, each listing some of its gcc versions.
+func (images ImagesMap) ByName() []NamedImage {
+	byName := make(map[string]*NamedImage)
+	var names []string
+	for _, img := range images.Sorted() {
+		named, ok := byName[img.Name]
+		if !ok {
+			named = &NamedImage{Name: img.Name}
+			byName[img.Name] = named
+			names = append(names, img.Name)
+		}
+		if !containsTarget(named.Targets, img.Target) {
+			named.Targets = append(named.Targets, img.Target)
+		}
+		if img.AnyGCC {
+			named.AnyGCC = true
+		} else if !containsVersion(named.GCCVersions, img.GCCVersion) {
+			named.GCCVersions = append(named.GCCVersions, img.GCCVersion)
+		}
+	}
+	sort.Strings(names)
+	res := make([]NamedImage, 0, len(names))
+	for _, name := range names {
+		named := byName[name]
+		sort.Slice(named.Targets, func(i, j int) bool { return named.Targets[i] < named.Targets[j] })
+		semver.Sort(named.GCCVersions)
+		res = append(res, *named)
+	}
+	return res
+}
+
+func containsTarget(targets []Type, target Type) bool {
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVersion(versions []semver.Version, v semver.Version) bool {
+	for _, version := range versions {
+		if version.EQ(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a copy of the images, that can be modified independently, like by concurrent builds.
+func (images ImagesMap) Clone() ImagesMap {
+	clone := make(ImagesMap, len(images))
+	for key, img := range images {
+		clone[key] = img
+	}
+	return clone
+}
+
+// MergeImages merges images lists, given in descending priority order, into a new ImagesMap.
+// See ImagesMap.Merge.
+func MergeImages(lists ...[]Image) ImagesMap {
+	images := make(ImagesMap)
+	images.Merge(lists...)
+	return images
+}
+
+// Merge adds the images of lists, given in descending priority order, to the map:
+// when multiple images share the same key (target, gcc version and multi-arch-ness),
+// the one already in the map, or else the first one, wins, and the conflict is logged.
+func (images ImagesMap) Merge(lists ...[]Image) {
+	for _, list := range lists {
+		for _, image := range list {
+			// Skip if key already exists: we have a descending prio list of docker repos!
+			if existing, ok := images[image.toKey()]; ok {
+				if existing.Name != image.Name {
+					logger.WithField("key", image.toKey()).
+						WithField("image", existing.Name).
+						WithField("skipped", image.Name).
+						Warn("Multiple builder images provide the same target and gcc, skipping the lower priority one")
+				}
+				continue
+			}
+			images[image.toKey()] = image
+		}
+	}
+}
+
+// findImage returns the image, for target or "any" target, that provides gccVers.
+// Target-specific images are always preferred over "any" ones.
+// When no target-specific image provides gccVers, it falls back at the one that provides the nearest gcc of the same major,
+// unless a target-specific image provides every gcc, then at the "any" target image that provides gccVers,
+// then at the image that provides every gcc, if any, and then at the image that provides the nearest gcc,
+// that is the greatest gcc lower than gccVers, or the lowest available one.
+// Multi-arch images are only considered when no architecture-specific image is available.
+// Only images of unknown architecture are considered, see findPreferredImage.
+func (im ImagesMap) findImage(target Type, gccVers semver.Version) (Image, bool) {
+	return im.findPreferredImage(target, gccVers, "", false, true)
+}
+
+// findPreferredImage is like findImage, but, when anyFirst is set, "any" target images are preferred
+// over target-specific ones at each step, see Build.PreferAnyImages.
+// Architecture-specific images are only considered for arch, the deb form of the build architecture,
+// preferring the ones annotated with arch over the ones of unknown architecture.
+// The last fallback, at the image that provides the nearest gcc, is only applied when nearestGCC is set.
+func (im ImagesMap) findPreferredImage(target Type, gccVers semver.Version, arch string, anyFirst bool, nearestGCC bool) (Image, bool) {
+	targets := []Type{target, "any"}
+	if anyFirst {
+		targets = []Type{"any", target}
+	}
+	if img, ok := im.findArchImage(targets, gccVers, arch, false, nearestGCC); ok {
+		return img, true
+	}
+	return im.findArchImage(targets, gccVers, arch, true, nearestGCC)
+}
+
+// findImage is like ImagesMap.findImage, for the build architecture, honoring PreferAnyImages.
+func (b *Build) findImage(images ImagesMap, target Type, gccVers semver.Version) (Image, bool) {
+	return images.findPreferredImage(target, gccVers, debArch(b.Architecture), b.PreferAnyImages, b.nearestGCC())
+}
+
+// nearestGCC returns whether builder images providing the nearest gcc can be used, see findImage:
+// only when GCCNearest is set or the gcc version is not enforced.
+func (b *Build) nearestGCC() bool {
+	return b.GCCNearest || !isExactVersion(b.GCCVersion)
+}
+
+// lookup returns the image with the key of img for arch, or, failing that, for an unknown architecture.
+// Multi-arch images have no architecture.
+func (im ImagesMap) lookup(img Image, arch string) (Image, bool) {
+	if !img.MultiArch && arch != "" {
+		img.Arch = arch
+		if found, ok := im[img.toKey()]; ok {
+			return found, true
+		}
+		img.Arch = ""
+	}
+	found, ok := im[img.toKey()]
+	return found, ok
+}
+
+// findArchImage implements findPreferredImage, only considering either architecture-specific or multi-arch images,
+// where targets are the target and "any", in order of preference.
+func (im ImagesMap) findArchImage(targets []Type, gccVers semver.Version, arch string, multiArch bool, nearestGCC bool) (Image, bool) {
+	// Try to find the image providing the specific gcc, for the preferred target first
+	for i, t := range targets {
+		targetImage := Image{
+			Target:     t,
+			GCCVersion: gccVers,
+			MultiArch:  multiArch,
+		}
+		if img, ok := im.lookup(targetImage, arch); ok {
+			return img, true
+		}
+		if i == 0 {
+			// Fallback at the nearest gcc of the same major for the preferred target, unless it provides every gcc
+			if _, ok := im.lookup(Image{Target: t, MultiArch: multiArch, AnyGCC: true}, arch); !ok {
+				if img, ok := im.sameMajorImage(t, gccVers, arch, multiArch); ok {
+					logger.WithField("image", img.Name).
+						WithField("targetGCC", gccVers.String()).
+						WithField("nearestGCC", img.GCCVersion.String()).
+						Info("no image offering the gcc, using the nearest gcc of the same major")
+					return img, true
+				}
+			}
+			logger.WithField("key", targetImage.toKey()).Debugf("no image for the preferred target, trying %q target", targets[1])
+		}
+	}
+
+	// Fallback at the images that offer every gcc, for the preferred target first
+	for _, t := range targets {
+		wildcardImage := Image{Target: t, MultiArch: multiArch, AnyGCC: true}
+		if img, ok := im.lookup(wildcardImage, arch); ok {
+			logger.WithField("key", wildcardImage.toKey()).Debug("no image offering the gcc, using the image offering every gcc")
+			return img, true
+		}
+	}
+
+	if !nearestGCC {
+		return Image{}, false
+	}
+	// Fallback at the image that offers the nearest gcc
+	candidates := make([]Image, 0)
+	for _, img := range im {
+		if img.MultiArch == multiArch && !img.AnyGCC && (img.Arch == "" || img.Arch == arch) && (img.Target == targets[0] || img.Target == targets[1]) {
+			candidates = append(candidates, img)
+		}
+	}
+	if len(candidates) == 0 {
+		return Image{}, false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].GCCVersion.NE(candidates[j].GCCVersion) {
+			return candidates[i].GCCVersion.LT(candidates[j].GCCVersion)
+		}
+		// prefer the images of the preferred target, then the ones annotated with arch,
+		// then the first name, since candidates come in map order
+		if candidates[i].Target != candidates[j].Target {
+			return candidates[i].Target == targets[0]
+		}
+		if candidates[i].Arch != candidates[j].Arch {
+			return candidates[i].Arch != ""
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	nearest := candidates[0]
+	for _, img := range candidates {
+		if img.GCCVersion.GT(gccVers) {
+			break
+		}
+		if img.GCCVersion.NE(nearest.GCCVersion) {
+			nearest = img
+		}
+	}
+	logger.WithField("image", nearest.Name).
+		WithField("targetGCC", gccVers.String()).
+		WithField("nearestGCC", nearest.GCCVersion.String()).
+		Info("no image offering the gcc, falling back to the nearest gcc")
+	return nearest, true
+}
+
+// sameMajorImage returns the image for target providing the nearest gcc of the same major as gccVers, if any,
+// see findArchImage. Between gcc versions as near as each other, the greatest one not greater than gccVers wins.
+func (im ImagesMap) sameMajorImage(target Type, gccVers semver.Version, arch string, multiArch bool) (Image, bool) {
+	var nearest Image
+	found := false
+	for _, img := range im {
+		if img.Target != target || img.MultiArch != multiArch || img.AnyGCC || (img.Arch != "" && img.Arch != arch) || img.GCCVersion.Major != gccVers.Major {
+			continue
+		}
+		if !found || nearerGCC(img.GCCVersion, nearest.GCCVersion, gccVers) {
+			nearest = img
+			found = true
+		}
+	}
+	return nearest, found
+}
+
+// nearerGCC returns whether v is nearer than other to target, see sameMajorImage.
+func nearerGCC(v, other, target semver.Version) bool {
+	dv, do := gccDistance(v, target), gccDistance(other, target)
+	if dv != do {
+		return dv < do
+	}
+	if v.LTE(target) != other.LTE(target) {
+		return v.LTE(target)
+	}
+	if v.LTE(target) {
+		return v.GT(other)
+	}
+	return v.LT(other)
+}
+
+// filePaths resolves path to the list of image list files to be loaded.
+// path can either be a single file, a directory (every "*.yaml" and "*.yml" file inside it,
+// and their gzip compressed "*.gz" variants, is loaded) or a glob pattern. Resolved files are returned in lexical order, so that priority stays deterministic.
+func filePaths(path string) ([]string, error) {
+	fileInfo, err := os.Stat(path)
+	if err == nil {
+		if !fileInfo.IsDir() {
+			return []string{path}, nil
+		}
+		var paths []string
+		for _, ext := range []string{"*.yaml", "*.yml", "*.yaml.gz", "*.yml.gz"} {
+			matches, err := filepath.Glob(filepath.Join(path, ext))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+		sort.Strings(paths)
+		return paths, nil
+	}
+
+	// Not an existing file nor directory; try it as a glob pattern
+	paths, globErr := filepath.Glob(path)
+	if globErr != nil || len(paths) == 0 {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (f *FileImagesLister) LoadImages(_ context.Context) ([]Image, error) {
+	var res []Image
+	for _, path := range f.paths() {
+		files, err := filePaths(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening builder repo file %s: %w", path, err)
+		}
+
+		var checksum string
+		if path == f.FilePath && f.Checksum != "" {
+			if len(files) != 1 || files[0] != path {
+				return nil, fmt.Errorf("error verifying builder repo file %s: a checksum can only be verified for a single file", path)
+			}
+			checksum = f.Checksum
+		}
+
+		var pathImages []Image
+		for _, filePath := range files {
+			images, err := loadImagesFile(filePath, checksum, f.Strict, f.Aliases)
+			if err != nil {
+				return nil, err
+			}
+			pathImages = append(pathImages, images...)
+		}
+		// The first image of each key wins when merging: put overrides first
+		res = append(pathImages, res...)
+	}
+	return res, nil
+}
+
+// loadImagesFile loads the images list file, along with the images list files it includes.
+// Images of the including file come first, so that they override the included ones when merged.
+// When checksum is set, the file must match it; the included files are not verified.
+func loadImagesFile(filePath string, checksum string, strict bool, aliases TargetAliases) ([]Image, error) {
+	return loadIncludingImagesFile(filePath, checksum, strict, aliases, make(map[string]bool))
+}
+
+// loadIncludingImagesFile implements loadImagesFile, where including holds the absolute paths
+// of the files including filePath, to detect include cycles.
+func loadIncludingImagesFile(filePath string, checksum string, strict bool, aliases TargetAliases, including map[string]bool) ([]Image, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening builder repo file %s: %w", filePath, err)
+	}
+	if including[absPath] {
+		return nil, fmt.Errorf("invalid image list file %s: include cycle", filePath)
+	}
+	including[absPath] = true
+	defer delete(including, absPath)
+
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening builder repo file %s: %w", filePath, err)
+	}
+	if err = verifyChecksum(filePath, file, checksum); err != nil {
+		return nil, err
+	}
+	imageList, err := decodeImagesList(filePath, file, strict)
+	if err != nil {
+		return nil, err
+	}
+	for i := range imageList.Images {
+		if err = imageList.Images[i].expandEnv(); err != nil {
+			return nil, fmt.Errorf("invalid image list file %s: %w", filePath, err)
+		}
+	}
+	res, err := imagesFromList(filePath, imageList, strict, aliases)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range imageList.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filePath), include)
+		}
+		images, err := loadIncludingImagesFile(include, "", strict, aliases, including)
+		if err != nil {
+			return nil, fmt.Errorf("error including builder repo file %s from %s: %w", include, filePath, err)
+		}
+		res = append(res, images...)
+	}
+	return res, nil
+}
+
+// envVarRegex matches the "${VAR}" and "${VAR:-default}" environment variable references of images lists values.
+var envVarRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv expands the environment variable references of the image name and target,
+// like "${REGISTRY:-docker.io}/myorg/driverkit-builder-centos", so that an images list can be shared across environments.
+// As in shells, defaults are used when the variable is either unset or empty; unset variables without a default are errors.
+func (i *YAMLImage) expandEnv() error {
+	name, err := expandEnv(i.Name)
+	if err != nil {
+		return fmt.Errorf("image %s: %w", i.Name, err)
+	}
+	target, err := expandEnv(i.Target)
+	if err != nil {
+		return fmt.Errorf("image %s: %w", i.Name, err)
+	}
+	i.Name, i.Target = name, target
+	return nil
+}
+
+// expandEnv expands the environment variable references of s, see YAMLImage.expandEnv.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envVarRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		match := envVarRegex.FindStringSubmatch(ref)
+		value, ok := os.LookupEnv(match[1])
+		if match[2] != "" && value == "" {
+			return match[3]
+		}
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set, and has no default", match[1])
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// gzipMagic are the leading bytes of gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompressImagesList transparently decompresses gzip compressed images lists, like "images.yaml.gz".
+func decompressImagesList(filePath string, file []byte) ([]byte, error) {
+	if !bytes.HasPrefix(file, gzipMagic) {
+		return file, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing builder repo file %s: %w", filePath, err)
+	}
+	defer r.Close()
+	file, err = io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing builder repo file %s: %w", filePath, err)
+	}
+	return file, nil
+}
+
+// parseImagesList parses the yaml images list read from filePath, that can also be a remote url.
+// Gzip compressed images lists are supported too, and images targets can be aliases.
+func parseImagesList(filePath string, file []byte, strict bool, aliases TargetAliases) ([]Image, error) {
+	imageList, err := decodeImagesList(filePath, file, strict)
+	if err != nil {
+		return nil, err
+	}
+	return imagesFromList(filePath, imageList, strict, aliases)
+}
+
+// decodeImagesList decodes the yaml images list read from filePath, decompressing it if needed.
+// Unknown fields, like a misspelled "gcc_version", are reported with their line:
+// in strict mode they are errors, otherwise they are logged and ignored.
+func decodeImagesList(filePath string, file []byte, strict bool) (YAMLImagesList, error) {
+	var imageList YAMLImagesList
+	file, err := decompressImagesList(filePath, file)
+	if err != nil {
+		return YAMLImagesList{}, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(file))
+	dec.KnownFields(true)
+	err = dec.Decode(&imageList)
+	if err == nil || errors.Is(err, io.EOF) {
+		return imageList, nil
+	}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && !strict {
+		logger.WithField("FilePath", filePath).WithError(err).Warning("Ignoring unknown fields of image list file")
+		imageList = YAMLImagesList{}
+		err = yaml.Unmarshal(file, &imageList)
+	}
+	if err != nil {
+		return YAMLImagesList{}, fmt.Errorf("error unmarshalling builder repo file %s: %w", filePath, err)
+	}
+	return imageList, nil
+}
+
+// imagesFromList returns the images of the images list read from filePath; see parseImagesList.
+func imagesFromList(filePath string, imageList YAMLImagesList, strict bool, aliases TargetAliases) ([]Image, error) {
+	var res []Image
+	var err error
+
+	if len(imageList.Images) == 0 && len(imageList.Include) == 0 {
+		logger.WithField("FilePath", filePath).Warning("Invalid image list file: expected at least 1 image")
+	}
+
+	for _, image := range imageList.Images {
+		if len(image.GCCVersions) == 0 {
+			return nil, fmt.Errorf("invalid image list file %s: expected at least 1 gcc version for image %s", filePath, image.Name)
+		}
+		target := aliases.Resolve(image.Target)
+		if _, ok := BuilderByTarget[target]; !ok && target != "any" {
+			targets := BuilderByTarget.Targets()
+			sort.Strings(targets)
+			if strict {
+				return nil, fmt.Errorf("invalid image list file %s: unknown target %s for image %s, expected \"any\" or one of %v", filePath, image.Target, image.Name, targets)
+			}
+			logger.WithField("FilePath", filePath).
+				WithField("image", image.Name).
+				WithField("target", image.Target).
+				WithField("targets", targets).
+				Warning("Skipping image with unknown target")
+			continue
+		}
+		var clangVersion semver.Version
+		if image.ClangVersion != "" {
+			clangVersion, err = semver.ParseTolerant(image.ClangVersion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid image list file %s: wrong clang version %s for image %s: %w", filePath, image.ClangVersion, image.Name, err)
+			}
+		}
+		minKernel, err := parseKernelBound(image.MinKernel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image list file %s: wrong min kernel for image %s: %w", filePath, image.Name, err)
+		}
+		maxKernel, err := parseKernelBound(image.MaxKernel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image list file %s: wrong max kernel for image %s: %w", filePath, image.Name, err)
+		}
+		defaultGCC, err := parseDefaultGCC(image)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image list file %s: %w", filePath, err)
+		}
+		// Collapse duplicated gcc versions, and skip the invalid ones
+		gccVersions := make(map[string]bool, len(image.GCCVersions))
+		for _, gcc := range image.GCCVersions {
+			if gcc == WildcardGCC {
+				if !gccVersions[WildcardGCC] {
+					gccVersions[WildcardGCC] = true
+					res = append(res, Image{
+						Name:          image.Name,
+						Target:        target,
+						ClangVersion:  clangVersion,
+						MultiArch:     image.MultiArch,
+						MinKernel:     minKernel,
+						MaxKernel:     maxKernel,
+						Digest:        image.Digest,
+						AnyGCC:        true,
+						BuildCommand:  image.BuildCommand,
+						DistroVersion: image.DistroVersion,
+					})
+				}
+				continue
+			}
+			gccVersion, err := semver.ParseTolerant(gcc)
+			if err != nil {
+				logger.WithField("FilePath", filePath).
+					WithField("image", image.Name).
+					WithField("gcc", gcc).
+					WithError(err).
+					Warning("Skipping invalid gcc version")
+				continue
+			}
+			if gccVersions[gccVersion.String()] {
+				continue
+			}
+			gccVersions[gccVersion.String()] = true
+			buildImage := Image{
+				Name:          image.Name,
+				Target:        target,
+				GCCVersion:    gccVersion,
+				ClangVersion:  clangVersion,
+				MultiArch:     image.MultiArch,
+				MinKernel:     minKernel,
+				MaxKernel:     maxKernel,
+				BuildCommand:  image.BuildCommand,
+				DistroVersion: image.DistroVersion,
+				DefaultGCC:    image.DefaultGCC != "" && gccVersion.EQ(defaultGCC),
+			}
+			buildImage.Digest = image.Digest
+			res = append(res, buildImage)
+		}
+	}
+	return res, nil
+}
+
+// DefaultImagePattern is the default pattern used to match builder images names.
+// See ImageRegexes for its syntax.
+const DefaultImagePattern = `(?i:driverkit-builder-)(?P<target>{{ .Target }})(?P<distroVers>[0-9]+)?(?P<arch>-{{ .Arch }})?(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+(-[0-9][0-9a-z.]*)?)+)(?P<clangVers>_clang[0-9]+.[0-9]+.[0-9]+)?$`
+
+// imagePatternData is the data used to render an image pattern.
+type imagePatternData struct {
+	Target string
+	Arch   string
+}
+
+// gccVersRegex matches each gcc version inside the "gccVers" group of an image name
+// (and the clang version inside the "clangVers" one), with its numeric pre-release, if any,
+// like "9.3.1-20210109".
+var gccVersRegex = regexp.MustCompile(`[0-9]+(\.[0-9]+)*(-[0-9][0-9a-z.]*)?`)
+
+// ImageRegexes renders the pattern used to match builder images names
+// for the given target and the "any" target, and architecture, that is rendered as it is, like "x86_64".
+// The pattern is a text/template, receiving ".Target" and ".Arch" fields, that must render to a regex.
+// The regex must provide a "gccVers" named group, containing all the gcc versions offered by the image,
+// and can provide a "target" named group; images without it are loaded as "any" target images.
+// ".Target" renders to a case-insensitive regex, like "(?i:centos)", and the captured targets are lowercased.
+// It can also provide a "clangVers" named group, containing the clang version offered by the image,
+// an optional "distroVers" named group, containing the distro major version the image is specific to, like "8" for "centos8",
+// and an optional "arch" named group: images where it is empty are loaded as multi-arch images.
+// An empty pattern means DefaultImagePattern.
+func ImageRegexes(pattern string, target Type, arch string) ([]*regexp.Regexp, error) {
+	return imageRegexes(pattern, target.String(), arch)
+}
+
+// imageRegexes is like ImageRegexes, but target is rendered as it is,
+// so that it can be a regex matching the target and its aliases.
+func imageRegexes(pattern string, target string, arch string) ([]*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = DefaultImagePattern
+	}
+	t, err := template.New("image-pattern").Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regs := make([]*regexp.Regexp, 0, 2)
+	for _, tgt := range []string{target, "any"} {
+		buf := bytes.NewBuffer(nil)
+		// Targets are matched case-insensitively, like "Ubuntu"
+		if err = t.Execute(buf, imagePatternData{Target: "(?i:" + tgt + ")", Arch: arch}); err != nil {
+			return nil, err
+		}
+		reg, err := regexp.Compile(buf.String())
+		if err != nil {
+			return nil, err
+		}
+		if reg.SubexpIndex("gccVers") == -1 {
+			return nil, fmt.Errorf("image pattern %q must provide a gccVers named group", pattern)
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+// repoRegexes returns the regexes matching the names of "any" and target-specific images
+// for the targets and architecture of build.
+// Target-specific images are matched by the target aliases too.
+func repoRegexes(build *Build) []*regexp.Regexp {
+	kernelArch, err := kernelrelease.ParseArchitecture(build.Architecture)
+	if err != nil {
+		// Reported by Build.LoadImages
+		return nil
+	}
+	return repoArchRegexes(build, kernelArch)
+}
+
+// repoArchRegexes is like repoRegexes, for the given architecture in place of the build one.
+func repoArchRegexes(build *Build, kernelArch kernelrelease.Architecture) []*regexp.Regexp {
+	// Create the proper regexes to load "any" and target-specific images for requested arch
+	arch := archRegex(kernelArch)
+	var repoRegs []*regexp.Regexp
+	for i, target := range build.targets() {
+		regs, err := imageRegexes(build.ImagePattern, targetRegex(target, build.TargetAliases), arch)
+		if err != nil {
+			// Reported by Build.LoadImages
+			return nil
+		}
+		if i > 0 {
+			// "any" target regex is already there
+			regs = regs[:1]
+		}
+		repoRegs = append(repoRegs, regs...)
+	}
+	return repoRegs
+}
+
+// archRegex returns a regex matching arch in both its non-deb and deb forms, like "(?:x86_64|amd64)",
+// since registries name builder images after either of them.
+func archRegex(arch kernelrelease.Architecture) string {
+	return "(?:" + regexp.QuoteMeta(arch.ToNonDeb()) + "|" + regexp.QuoteMeta(arch.String()) + ")"
+}
+
+// targetRegex returns the regex matching target or any of its aliases.
+func targetRegex(target Type, aliases TargetAliases) string {
+	names := aliases.aliasesOf(target)
+	if len(names) == 0 {
+		return target.String()
+	}
+	alts := []string{regexp.QuoteMeta(target.String())}
+	for _, name := range names {
+		alts = append(alts, regexp.QuoteMeta(name))
+	}
+	return "(?:" + strings.Join(alts, "|") + ")"
+}
+
+func NewRepoImagesLister(repo string, build *Build) *RepoImagesLister {
+	repo = build.RegistryMirror.Rewrite(repo)
+	return &RepoImagesLister{repo: repo, auth: build.registryAuth(repo), cache: build.ImagesCache, maxAttempts: build.RegistryMaxAttempts, searchLimit: build.RegistrySearchLimit, proxy: build.RegistryProxy, regs: repoRegexes(build), arch: debArch(build.Architecture), aliases: build.TargetAliases}
+}
+
+// NewAllArchsRepoImagesLister creates a RepoImagesLister loading the images of every supported architecture in one pass,
+// regardless of the build architecture, like for multi-arch tooling: images are annotated with their architecture,
+// and multi-arch images are returned once, without architecture.
+// Build.LoadImages only keeps the images of the build architecture.
+func NewAllArchsRepoImagesLister(repo string, build *Build) *RepoImagesLister {
+	lister := NewRepoImagesLister(repo, build)
+	archs := make([]kernelrelease.Architecture, 0, len(kernelrelease.SupportedArchs))
+	for arch := range kernelrelease.SupportedArchs {
+		archs = append(archs, arch)
+	}
+	sort.Slice(archs, func(i, j int) bool { return archs[i] < archs[j] })
+	for _, arch := range archs {
+		lister.archs = append(lister.archs, archRegexes{arch: arch.String(), regs: repoArchRegexes(build, arch)})
+	}
+	return lister
+}
+
+// NewTagsImagesLister creates a TagsImagesLister for repo, with or without TagsRepoScheme.
+func NewTagsImagesLister(repo string, build *Build) *TagsImagesLister {
+	repo = build.RegistryMirror.Rewrite(strings.TrimPrefix(repo, TagsRepoScheme))
+	return &TagsImagesLister{repo: repo, auth: build.registryAuth(repo), arch: debArch(build.Architecture), proxy: build.RegistryProxy, cache: build.ImagesCache, regs: repoRegexes(build), aliases: build.TargetAliases}
+}
+
+// debArch returns the deb form of the architecture, like "amd64" for "x86_64", or an empty string when it is not supported.
+func debArch(arch string) string {
+	if kernelArch, err := kernelrelease.ParseArchitecture(arch); err == nil {
+		return kernelArch.String()
+	}
+	return ""
+}
+
+// withArch annotates the images, but the multi-arch ones, with the architecture they were found for.
+func withArch(images []Image, arch string) []Image {
+	for i := range images {
+		if !images[i].MultiArch && images[i].Arch == "" {
+			images[i].Arch = arch
+		}
+	}
+	return images
+}
+
+// LoadImages matches each "repo:tag" image reference against the image regexes.
+func (repo *TagsImagesLister) LoadImages(ctx context.Context) ([]Image, error) {
+	cacheKey := TagsRepoScheme + repo.repo
+	if names, ok := repo.cache.load(cacheKey); ok {
+		return withArch(imagesFromNames(repo.regs, repo.aliases, names), repo.arch), nil
+	}
+	httpClient, err := proxyClient(repo.proxy, 0)
+	if err != nil {
+		return nil, err
+	}
+	baseURL, path := registryURL(repo.repo)
+	tags, err := listTags(ctx, httpClient, baseURL, path, repo.auth)
+	if err != nil {
+		logger.WithField("Repository", repo.repo).WithError(err).Warnf("Skipping repo")
+		return []Image{}, nil
+	}
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, repo.repo+":"+tag)
+	}
+	repo.cache.store(cacheKey, names)
+	return withArch(imagesFromNames(repo.regs, repo.aliases, names), repo.arch), nil
+}
+
+// DockerUnreachableError is returned by RepoImagesLister when the docker daemon, used to search the repo, is not reachable,
+// like when it is not running, and the repo registry does not provide the catalog API either.
+type DockerUnreachableError struct {
+	Host string // docker daemon host, like "unix:///var/run/docker.sock"
+	Err  error
+}
+
+func (e *DockerUnreachableError) Error() string {
+	return fmt.Sprintf("Docker daemon not reachable at %s; is it running?", e.Host)
+}
 
-func (im ImagesMap) findImage(target Type, gccVers semver.Version) (Image, bool) {
-	targetImage := Image{
-		Target:     target,
-		GCCVersion: gccVers,
-	}
-	// Try to find specific image for specific target first
-	if img, ok := im[targetImage.toKey()]; ok {
-		return img, true
-	}
+func (e *DockerUnreachableError) Unwrap() error {
+	return e.Err
+}
 
-	// Fallback at "any" target that offers specific gcc
-	targetImage.Target = "any"
-	if img, ok := im[targetImage.toKey()]; ok {
-		return img, true
-	}
-	return Image{}, false
+func (e *DockerUnreachableError) Is(target error) bool {
+	return target == ErrRegistryUnreachable
 }
 
-func (f *FileImagesLister) LoadImages() []Image {
-	// loop over lines in file to print them
-	file, err := os.ReadFile(f.FilePath)
+func (repo *RepoImagesLister) LoadImages(ctx context.Context) ([]Image, error) {
+	if names, ok := repo.cache.load(repo.repo); ok {
+		return repo.images(names), nil
+	}
+	httpClient, err := proxyClient(repo.proxy, 0)
 	if err != nil {
-		logger.WithError(err).WithField("FilePath", f.FilePath).Fatal("error opening builder repo file")
+		return nil, err
 	}
-
-	var imageList YAMLImagesList
-	var res []Image
-
-	err = yaml.Unmarshal(file, &imageList)
+	cli, err := client.NewClientWithOpts(client.FromEnv, withProxy(httpClient))
 	if err != nil {
-		logger.WithError(err).WithField("FilePath", f.FilePath).Fatal("error unmarshalling builder repo file")
+		return nil, err
 	}
-
-	if len(imageList.Images) == 0 {
-		logger.WithField("FilePath", f.FilePath).Warning("Invalid image list file: expected at least 1 image")
+	registryAuth, err := repo.auth.encode()
+	if err != nil {
+		return nil, err
 	}
-
-	for _, image := range imageList.Images {
-		if len(image.GCCVersions) == 0 {
-			logger.WithField("FilePath", f.FilePath).WithField("image", image).Fatal("Invalid image list file: expected at least 1 gcc version")
+	limit := repo.searchLimit
+	if limit <= 0 || limit > MaxRegistrySearchLimit {
+		limit = MaxRegistrySearchLimit
+	}
+	var names []string
+	var imgs []registry.SearchResult
+	var attempts int
+	var unreachable *DockerUnreachableError
+	if _, pingErr := cli.Ping(ctx); client.IsErrConnectionFailed(pingErr) {
+		// No point in retrying the search, but the registry catalog API does not need the daemon
+		unreachable = &DockerUnreachableError{Host: cli.DaemonHost(), Err: pingErr}
+		err = unreachable
+	} else {
+		attempts, err = retry(ctx, repo.maxAttempts, func() (err error) {
+			imgs, err = cli.ImageSearch(ctx, repo.repo, types.ImageSearchOptions{Limit: limit, RegistryAuth: registryAuth})
+			if err != nil {
+				logger.WithField("Repository", repo.repo).WithError(err).Debug("image search failed")
+			}
+			return err
+		})
+	}
+	if err == nil {
+		for _, img := range imgs {
+			names = append(names, img.Name)
 		}
-		for _, gcc := range image.GCCVersions {
-			buildImage := Image{
-				Name:       image.Name,
-				Target:     Type(image.Target),
-				GCCVersion: mustParseTolerant(gcc),
+		if len(imgs) >= limit {
+			// Search results are not paginated: complete them with the registry catalog API, if any
+			names = repo.completeSearch(ctx, httpClient, names, limit)
+		}
+	} else {
+		// Search endpoint is not available; try with the registry catalog API, if any
+		logger.WithField("Repository", repo.repo).WithField("attempts", attempts).WithError(err).Debug("image search failed, trying registry catalog")
+		domain, path := splitRepo(repo.repo)
+		if domain == "" {
+			if unreachable != nil {
+				return nil, unreachable
 			}
-			res = append(res, buildImage)
+			logger.WithField("Repository", repo.repo).WithField("attempts", attempts).WithError(err).Warnf("Skipping repo")
+			return []Image{}, nil
+		}
+		names, err = catalogImages(ctx, httpClient, domain, path, repo.auth)
+		if err != nil {
+			if unreachable != nil {
+				logger.WithField("Repository", repo.repo).WithError(err).Debug("registry catalog not available")
+				return nil, unreachable
+			}
+			logger.WithField("Repository", repo.repo).WithField("attempts", attempts).WithError(err).Warnf("Skipping repo")
+			return []Image{}, nil
 		}
 	}
-	return res
+	repo.cache.store(repo.repo, names)
+	return repo.images(names), nil
 }
 
-func NewRepoImagesLister(repo string, build *Build) *RepoImagesLister {
-	if len(repoRegs) == 0 {
-		// Create the proper regexes to load "any" and target-specific images for requested arch
-		arch := kernelrelease.Architecture(build.Architecture).ToNonDeb()
-		targetFmt := fmt.Sprintf("driverkit-builder-(?P<target>%s)-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)$", build.TargetType.String(), arch)
-		repoRegs = append(repoRegs, regexp.MustCompile(targetFmt))
-		genericFmt := fmt.Sprintf("driverkit-builder-any-%s(?P<gccVers>(_gcc[0-9]+.[0-9]+.[0-9]+)+)$", arch)
-		repoRegs = append(repoRegs, regexp.MustCompile(genericFmt))
+// images returns the images whose names match the regexes of the build architecture,
+// or, in all architectures mode, of each supported architecture.
+func (repo *RepoImagesLister) images(names []string) []Image {
+	if repo.archs == nil {
+		return withArch(imagesFromNames(repo.regs, repo.aliases, names), repo.arch)
+	}
+	var res []Image
+	for i, archRegs := range repo.archs {
+		for _, img := range withArch(imagesFromNames(archRegs.regs, repo.aliases, names), archRegs.arch) {
+			// Multi-arch images are matched for every architecture
+			if img.MultiArch && i > 0 {
+				continue
+			}
+			res = append(res, img)
+		}
 	}
-	return &RepoImagesLister{repo: repo}
+	return res
 }
 
-func (repo *RepoImagesLister) LoadImages() []Image {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		log.Fatal(err)
+// completeSearch completes the names found by a search that hit the results limit,
+// with the ones listed by the registry catalog API, if the repo registry provides it.
+func (repo *RepoImagesLister) completeSearch(ctx context.Context, httpClient *http.Client, names []string, limit int) []string {
+	entry := logger.WithField("Repository", repo.repo).WithField("limit", limit)
+	domain, path := splitRepo(repo.repo)
+	if domain == "" {
+		entry.Warn("Image search hit the results limit, some builder images could be missing")
+		return names
 	}
-	imgs, err := cli.ImageSearch(context.Background(), repo.repo, types.ImageSearchOptions{Limit: 100})
+	catalog, err := catalogImages(ctx, httpClient, domain, path, repo.auth)
 	if err != nil {
-		logger.WithField("Repository", repo.repo).WithError(err).Warnf("Skipping repo")
-		return []Image{}
+		entry.WithError(err).Warn("Image search hit the results limit, and registry catalog is not available: some builder images could be missing")
+		return names
 	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, name := range catalog {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// imagesFromNames returns the images whose names match any of regs,
+// with their target resolved through aliases.
+func imagesFromNames(regs []*regexp.Regexp, aliases TargetAliases, names []string) []Image {
 	var res []Image
-	for _, img := range imgs {
-		for _, reg := range repoRegs {
-			match := reg.FindStringSubmatch(img.Name)
+	for _, imgName := range names {
+		for _, reg := range regs {
+			match := reg.FindStringSubmatch(imgName)
 			if len(match) == 0 {
 				continue
 			}
 
 			var gccVers []string
+			var clangVers semver.Version
 			target := ""
+			distroVersion := ""
+			multiArch := false
 			for i, name := range reg.SubexpNames() {
 				if i > 0 && i <= len(match) {
 					switch name {
 					case "gccVers":
-						gccVers = strings.Split(match[i], "_gcc")
-						gccVers = gccVers[1:] // remove initial whitespace
+						gccVers = gccVersRegex.FindAllString(match[i], -1)
+					case "clangVers":
+						if clangVer := gccVersRegex.FindString(match[i]); clangVer != "" {
+							clangVers, _ = semver.ParseTolerant(clangVer)
+						}
 					case "target":
-						target = match[i]
+						target = strings.ToLower(match[i])
+					case "distroVers":
+						distroVersion = match[i]
+					case "arch":
+						multiArch = match[i] == ""
 					}
 				}
 			}
 
 			if len(gccVers) == 0 {
-				logger.Debug("Malformed image name: ", img.Name, len(match))
+				logger.WithField("image", imgName).Debug("Malformed image name")
 				continue
 			}
 
@@ -161,13 +1346,20 @@ func (repo *RepoImagesLister) LoadImages() []Image {
 			// and we cannot guarantee here that any subsequent docker repos
 			// does not provide a target-specific image that offers same gcc version
 			for _, gccVer := range gccVers {
-				// If user set a fixed gcc version, only load images that provide it.
+				gccVersion, err := semver.ParseTolerant(gccVer)
+				if err != nil {
+					logger.WithField("image", imgName).WithField("gcc", gccVer).WithError(err).Debug("Skipping invalid gcc version")
+					continue
+				}
 				buildImage := Image{
-					GCCVersion: mustParseTolerant(gccVer),
-					Name:       img.Name,
+					GCCVersion:    gccVersion,
+					ClangVersion:  clangVers,
+					Name:          imgName,
+					MultiArch:     multiArch,
+					DistroVersion: distroVersion,
 				}
 				if target != "" {
-					buildImage.Target = Type(target)
+					buildImage.Target = aliases.Resolve(target)
 				} else {
 					buildImage.Target = Type("any")
 				}
@@ -178,19 +1370,720 @@ func (repo *RepoImagesLister) LoadImages() []Image {
 	return res
 }
 
-func (b *Build) LoadImages() {
-	for _, imagesLister := range b.ImagesListers {
-		for _, image := range imagesLister.LoadImages() {
-			if b.GCCVersion != "" && b.GCCVersion != image.GCCVersion.String() {
+// partialVersionRange returns the range of versions matched by a version missing its patch,
+// or both its minor and patch, numbers: like ">=9.0.0 <10.0.0" for "9", or ">=9.3.0 <9.4.0" for "9.3".
+func partialVersionRange(s string) (semver.Range, bool) {
+	if strings.Count(s, ".") > 1 || strings.ContainsAny(s, "-+") {
+		return nil, false
+	}
+	from, err := semver.ParseTolerant(s)
+	if err != nil {
+		return nil, false
+	}
+	to := semver.Version{Major: from.Major + 1}
+	if strings.Contains(s, ".") {
+		to = semver.Version{Major: from.Major, Minor: from.Minor + 1}
+	}
+	return semver.MustParseRange(fmt.Sprintf(">=%s <%s", from, to)), true
+}
+
+// isExactVersion returns whether s is a fully expanded version, like "9.3.0".
+func isExactVersion(s string) bool {
+	if _, partial := partialVersionRange(s); partial {
+		return false
+	}
+	_, err := semver.ParseTolerant(s)
+	return err == nil
+}
+
+// matchesVersion returns whether v satisfies constraint, that is either a version,
+// a partial version matching any minor and patch number, like "9" or "9.3",
+// a version range, like ">=9.0.0 <11.0.0", or a comma separated list of them, like "8,9",
+// satisfied by any of them.
+func matchesVersion(constraint string, v semver.Version) bool {
+	if strings.Contains(constraint, ",") {
+		for _, c := range strings.Split(constraint, ",") {
+			if matchesVersion(strings.TrimSpace(c), v) {
+				return true
+			}
+		}
+		return false
+	}
+	if versionRange, ok := partialVersionRange(constraint); ok {
+		return versionRange(v)
+	}
+	if version, err := semver.ParseTolerant(constraint); err == nil {
+		return version.EQ(v)
+	}
+	if versionRange, err := semver.ParseRange(constraint); err == nil {
+		return versionRange(v)
+	}
+	return false
+}
+
+// providesGCC returns whether the image provides a gcc allowed by the build.
+func (b *Build) providesGCC(image Image) bool {
+	if b.GCCVersion == "" || b.GCCVersion == GCCAuto {
+		return true
+	}
+	if isExactVersion(b.GCCVersion) && b.GCCNearest {
+		// In nearest mode, load every image: the nearest gcc is selected afterwards
+		return true
+	}
+	if image.AnyGCC {
+		return true
+	}
+	return matchesVersion(b.GCCVersion, image.GCCVersion)
+}
+
+// deniesGCC returns whether the gcc version is denied by the build, see Build.GCCDeny.
+func (b *Build) deniesGCC(v semver.Version) bool {
+	for _, denied := range b.GCCDeny {
+		if matchesVersion(denied, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// belowMinGCC returns whether the gcc version is lower than the minimum gcc of the build, see Build.MinGCC.
+func (b *Build) belowMinGCC(v semver.Version) bool {
+	if b.MinGCC == "" {
+		return false
+	}
+	minGCC, err := semver.ParseTolerant(b.MinGCC)
+	if err != nil {
+		// Reported by Build.LoadImages
+		return false
+	}
+	return v.LT(minGCC)
+}
+
+// allowedGCC returns gcc when it is not denied, otherwise the nearest gcc provided by the images for target
+// that is not denied either, that is the greatest one lower than gcc, or the lowest one.
+func (b *Build) allowedGCC(target Type, gcc semver.Version) (semver.Version, bool) {
+	if !b.deniesGCC(gcc) {
+		return gcc, true
+	}
+	var allowed []semver.Version
+	for _, v := range b.GCCVersionsFor(target) {
+		if !b.deniesGCC(v) {
+			allowed = append(allowed, v)
+		}
+	}
+	if len(allowed) == 0 {
+		return semver.Version{}, false
+	}
+	nearest := allowed[0]
+	for _, v := range allowed {
+		if v.GT(gcc) {
+			break
+		}
+		nearest = v
+	}
+	logger.WithField("deniedGCC", gcc.String()).WithField("nearestGCC", nearest.String()).Debug("gcc is denied, falling back to nearest allowed gcc")
+	return nearest, true
+}
+
+// providesKernel returns whether the image can build for the kernel release of the build.
+func (b *Build) providesKernel(image Image) bool {
+	if b.KernelRelease == "" {
+		return true
+	}
+	return image.buildsKernel(kernelrelease.FromString(b.KernelRelease))
+}
+
+// buildsKernel returns whether the kernel release is within the kernel range of the image,
+// and ships with the distro version the image is specific to, if any.
+func (i Image) buildsKernel(kr kernelrelease.KernelRelease) bool {
+	if i.DistroVersion != "" && i.DistroVersion != kr.DistroVersion() {
+		return false
+	}
+	if i.MinKernel.NE(semver.Version{}) && kr.Version.LT(i.MinKernel) {
+		return false
+	}
+	return i.MaxKernel.EQ(semver.Version{}) || kr.Version.LTE(i.MaxKernel)
+}
+
+// parseDefaultGCC parses the default gcc of the image, if any, that must be one of its explicit gcc versions.
+func parseDefaultGCC(image YAMLImage) (semver.Version, error) {
+	if image.DefaultGCC == "" {
+		return semver.Version{}, nil
+	}
+	defaultGCC, err := semver.ParseTolerant(image.DefaultGCC)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("wrong default gcc %s for image %s: %w", image.DefaultGCC, image.Name, err)
+	}
+	for _, gcc := range image.GCCVersions {
+		if gccVersion, err := semver.ParseTolerant(gcc); err == nil && gccVersion.EQ(defaultGCC) {
+			return defaultGCC, nil
+		}
+	}
+	return semver.Version{}, fmt.Errorf("default gcc %s for image %s is not one of its gcc versions", image.DefaultGCC, image.Name)
+}
+
+// parseKernelBound parses a kernel release bounding the images kernel range; empty ones are unbounded.
+func parseKernelBound(s string) (semver.Version, error) {
+	if s == "" {
+		return semver.Version{}, nil
+	}
+	kr := kernelrelease.FromString(s)
+	if kr.Fullversion == "" {
+		return semver.Version{}, fmt.Errorf("%s is not a kernel release", s)
+	}
+	return kr.Version, nil
+}
+
+// providesClang returns whether the image provides a clang allowed by the build.
+// Clang is only enforced when building the eBPF probe.
+func (b *Build) providesClang(image Image) bool {
+	if b.ClangVersion == "" || !b.BuildsProbe() {
+		return true
+	}
+	return matchesVersion(b.ClangVersion, image.ClangVersion)
+}
+
+// ErrNoImages is returned by Build.LoadImages when no builder image could be loaded.
+var ErrNoImages = errors.New("could not load any builder image")
+
+// Categories of the image selection failures, matched through errors.Is by the errors returned by Build.LoadImages
+// and by the image selection, like Build.ResolveImage, so that callers can handle each of them.
+var (
+	// ErrNoImageForTarget is matched by an *ImageNotFoundError when no builder image, not even a skipped one, is available for its target.
+	ErrNoImageForTarget = errors.New("no builder image for target")
+	// ErrNoGCCMatch is matched by an *ImageNotFoundError when builder images are available for its target, but none provides the requested gcc,
+	// and by a *GCCPolicyError.
+	ErrNoGCCMatch = errors.New("no builder image providing the requested gcc")
+	// ErrArchUnsupported is matched by the error returned for an unsupported build architecture.
+	ErrArchUnsupported = errors.New("unsupported architecture")
+	// ErrRegistryUnreachable is matched by a *DockerUnreachableError.
+	ErrRegistryUnreachable = errors.New("registry unreachable")
+)
+
+// unsupportedArchError is returned by Build.LoadImages for an unsupported architecture, matching ErrArchUnsupported.
+type unsupportedArchError struct {
+	error
+}
+
+func (e *unsupportedArchError) Is(target error) bool {
+	return target == ErrArchUnsupported
+}
+
+func (e *unsupportedArchError) Unwrap() error {
+	return e.error
+}
+
+// maxClosestImages is the maximum number of closest images reported by ImageNotFoundError.
+const maxClosestImages = 3
+
+// ImageNotFoundError is returned when no builder image provides the requested gcc for a target.
+// It reports the image keys that were tried and the closest available images, if any.
+// It wraps ErrNoImages.
+type ImageNotFoundError struct {
+	Target     Type
+	GCCVersion string // either a gcc version or a gcc version range
+	Tried      []ImageKey
+	Closest    []Image
+	Denied     []Image // images for target skipped because they only provide a denied gcc, see Build.GCCDeny
+}
+
+func (e *ImageNotFoundError) Error() string {
+	msg := fmt.Sprintf("could not find any builder image for target %s", e.Target)
+	if e.GCCVersion != "" {
+		msg += fmt.Sprintf(" providing gcc %s", e.GCCVersion)
+	}
+	if len(e.Tried) > 0 {
+		tried := make([]string, 0, len(e.Tried))
+		for _, key := range e.Tried {
+			tried = append(tried, string(key))
+		}
+		msg += fmt.Sprintf(" (tried %s)", strings.Join(tried, ", "))
+	}
+	if len(e.Closest) > 0 {
+		closest := make([]string, 0, len(e.Closest))
+		for _, img := range e.Closest {
+			closest = append(closest, fmt.Sprintf("%s with gcc %s", img.Name, img.GCCVersionString()))
+		}
+		msg += fmt.Sprintf("; available images: %s", strings.Join(closest, ", "))
+	}
+	if len(e.Denied) > 0 {
+		denied := make([]string, 0, len(e.Denied))
+		for _, img := range e.Denied {
+			denied = append(denied, fmt.Sprintf("%s with gcc %s", img.Name, img.GCCVersionString()))
+		}
+		msg += fmt.Sprintf("; images providing a denied gcc: %s", strings.Join(denied, ", "))
+	}
+	return msg
+}
+
+func (e *ImageNotFoundError) Unwrap() error {
+	return ErrNoImages
+}
+
+// Is makes the error match either ErrNoImageForTarget or ErrNoGCCMatch,
+// depending on whether any builder image is available for its target.
+func (e *ImageNotFoundError) Is(target error) bool {
+	switch target {
+	case ErrNoImageForTarget:
+		return len(e.Closest) == 0 && len(e.Denied) == 0
+	case ErrNoGCCMatch:
+		return len(e.Closest) > 0 || len(e.Denied) > 0
+	}
+	return false
+}
+
+// TargetsImageNotFoundError is returned when no builder image is found for any of the targets of a build,
+// reporting the error of each target, either an *ImageNotFoundError or a *GCCPolicyError.
+type TargetsImageNotFoundError struct {
+	Errs []error
+}
+
+func (e *TargetsImageNotFoundError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is makes the error match any of the errors of the targets.
+func (e *TargetsImageNotFoundError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the targets that matches target.
+func (e *TargetsImageNotFoundError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// GCCPolicyError is returned when the builder images for a target only provide gcc versions
+// lower than the minimum gcc of the build, see Build.MinGCC.
+type GCCPolicyError struct {
+	Target     Type
+	GCCVersion semver.Version // gcc that would have been used without the minimum gcc
+	MinGCC     string
+}
+
+func (e *GCCPolicyError) Error() string {
+	return fmt.Sprintf("gcc policy violation: target %s would be built with gcc %s, lower than the minimum gcc %s", e.Target, e.GCCVersion, e.MinGCC)
+}
+
+func (e *GCCPolicyError) Is(target error) bool {
+	return target == ErrNoGCCMatch
+}
+
+// gccPolicyViolation returns a GCCPolicyError for target and gcc when any of the images dropped for providing a gcc
+// lower than the minimum one would have been used, otherwise nil.
+func (b *Build) gccPolicyViolation(target Type, gcc string) error {
+	belowMin := make(ImagesMap)
+	for _, img := range b.belowMinImages {
+		if img.Target == target || img.Target == "any" {
+			belowMin[img.toKey()] = img
+		}
+	}
+	// Ranges and partial versions are already matched by the dropped images
+	gccVersion, _ := semver.ParseTolerant(gcc)
+	image, ok := b.findImage(belowMin, target, gccVersion)
+	if !ok {
+		return nil
+	}
+	return &GCCPolicyError{Target: target, GCCVersion: image.GCCVersion, MinGCC: b.MinGCC}
+}
+
+// imageNotFound returns an ImageNotFoundError for target and gcc,
+// reporting the closest images between the loaded and discarded ones,
+// or a GCCPolicyError when target could only be built with a gcc lower than the minimum one.
+func (b *Build) imageNotFound(target Type, gcc string) error {
+	if err := b.gccPolicyViolation(target, gcc); err != nil {
+		return err
+	}
+	e := &ImageNotFoundError{Target: target, GCCVersion: gcc}
+	// Partial versions and ranges do not map to image keys
+	gccVersion, _ := semver.ParseTolerant(gcc)
+	if isExactVersion(gcc) {
+		for _, t := range []Type{target, "any"} {
+			img := Image{Target: t, GCCVersion: gccVersion}
+			e.Tried = append(e.Tried, img.toKey())
+		}
+	}
+
+	seen := make(map[ImageKey]bool)
+	var candidates []Image
+	for _, images := range [][]Image{b.imagesList(), b.discardedImages} {
+		for _, img := range images {
+			if (img.Target == target || img.Target == "any") && !seen[img.toKey()] {
+				seen[img.toKey()] = true
+				candidates = append(candidates, img)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		di, dj := gccDistance(candidates[i].GCCVersion, gccVersion), gccDistance(candidates[j].GCCVersion, gccVersion)
+		if di == dj {
+			return candidates[i].GCCVersion.LT(candidates[j].GCCVersion)
+		}
+		return di < dj
+	})
+	if len(candidates) > maxClosestImages {
+		candidates = candidates[:maxClosestImages]
+	}
+	e.Closest = candidates
+	seen = make(map[ImageKey]bool)
+	for _, img := range b.deniedImages {
+		if (img.Target == target || img.Target == "any") && !seen[img.toKey()] {
+			seen[img.toKey()] = true
+			e.Denied = append(e.Denied, img)
+		}
+	}
+	return e
+}
+
+// imagesList returns the loaded images.
+func (b *Build) imagesList() []Image {
+	images := make([]Image, 0, len(b.Images))
+	for _, img := range b.Images {
+		images = append(images, img)
+	}
+	return images
+}
+
+// gccDistance returns how far v is from target, weighting major versions the most.
+func gccDistance(v, target semver.Version) uint64 {
+	abs := func(a, b uint64) uint64 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	return abs(v.Major, target.Major)*1000000 + abs(v.Minor, target.Minor)*1000 + abs(v.Patch, target.Patch)
+}
+
+// maxConcurrentListers is the maximum number of ImagesListers loading images at the same time.
+const maxConcurrentListers = 4
+
+// listerResult holds the images loaded by an ImagesLister.
+type listerResult struct {
+	images  []Image
+	err     error
+	elapsed time.Duration
+}
+
+// ListerStats describes the images loading of an ImagesLister, to spot slow or empty builder repos.
+type ListerStats struct {
+	Lister  string // see listerName
+	Images  int    // number of images returned by the lister, before any filtering
+	Elapsed time.Duration
+	Err     error
+}
+
+// loadListersImages loads the images of the listers concurrently, with at most maxConcurrentListers at a time.
+// Results are returned in the same order as the listers, so that priorities are preserved.
+func loadListersImages(ctx context.Context, imagesListers []ImagesLister) []listerResult {
+	results := make([]listerResult, len(imagesListers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < maxConcurrentListers && w < len(imagesListers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				results[i].images, results[i].err = imagesListers[i].LoadImages(ctx)
+				results[i].elapsed = time.Since(start)
+			}
+		}()
+	}
+	for i := range imagesListers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// listedImages are the images loaded by the listers of a build, in descending priority order.
+type listedImages struct {
+	listers []ImagesLister // as set on the build
+	sorted  []ImagesLister // by descending priority
+	results []listerResult
+}
+
+// of returns whether the images were loaded by the very same listers, not only equal ones.
+func (l *listedImages) of(listers []ImagesLister) bool {
+	return l != nil && len(l.listers) == len(listers) && (len(listers) == 0 || &l.listers[0] == &listers[0])
+}
+
+// anyListerLoaded returns whether any of the listers loaded its images without errors.
+func anyListerLoaded(results []listerResult) bool {
+	for _, res := range results {
+		if res.err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadImages loads the images of the ImagesListers, in descending priority order, into Images.
+// It never terminates the process: when no builder image could be loaded, it returns ErrNoImages,
+// or an *ImageNotFoundError wrapping it when the loaded images do not provide the requested gcc or clang,
+// so that callers can handle it with errors.Is.
+// Builder repos that cannot be searched because the docker daemon is not reachable are skipped
+// as long as other builder repos are loaded, otherwise their *DockerUnreachableError is returned.
+// The ImagesListers are only run by the first call, the next ones filter the images they already loaded.
+func (b *Build) LoadImages(ctx context.Context) error {
+	// An unsupported architecture would not match any image
+	if _, err := kernelrelease.ParseArchitecture(b.Architecture); err != nil {
+		return &unsupportedArchError{err}
+	}
+	// Neither would an invalid pattern
+	if _, err := ImageRegexes(b.ImagePattern, b.TargetType, "arch"); err != nil {
+		return fmt.Errorf("invalid builder images pattern: %w", err)
+	}
+	if b.MinGCC != "" {
+		if _, err := semver.ParseTolerant(b.MinGCC); err != nil {
+			return fmt.Errorf("invalid minimum gcc version %s: %w", b.MinGCC, err)
+		}
+	}
+	var exclude *regexp.Regexp
+	if b.ImageExclude != "" {
+		var err error
+		if exclude, err = regexp.Compile(b.ImageExclude); err != nil {
+			return fmt.Errorf("invalid builder images exclude pattern: %w", err)
+		}
+	}
+	if b.Images == nil {
+		b.Images = make(ImagesMap)
+	}
+	arch := debArch(b.Architecture)
+	// Pinned images bypass the listers, and any filter, replacing the images they provide
+	pinned := make(map[ImageKey]bool, len(b.PinnedImages))
+	pinnedImages := withArch(append([]Image{}, b.PinnedImages...), arch)
+	for _, image := range pinnedImages {
+		logger.WithField("image", image).Debug("Using pinned builder image")
+		pinned[image.toKey()] = true
+	}
+	b.Images.Merge(pinnedImages)
+	// Listers are only run once per build, and by the builds of its targets, see PerTarget
+	if !b.listed.of(b.ImagesListers) {
+		// Sort listers by descending priority; listers with the same priority keep their order
+		imagesListers := append([]ImagesLister{}, b.ImagesListers...)
+		sort.SliceStable(imagesListers, func(i, j int) bool {
+			return listerPriority(imagesListers[i]) > listerPriority(imagesListers[j])
+		})
+		b.listed = &listedImages{listers: b.ImagesListers, sorted: imagesListers, results: loadListersImages(ctx, imagesListers)}
+	}
+	imagesListers, results := b.listed.sorted, b.listed.results
+	b.discardedImages = nil
+	b.deniedImages = nil
+	b.belowMinImages = nil
+	b.ListersStats = make([]ListerStats, 0, len(results))
+	for i, res := range results {
+		stats := ListerStats{Lister: listerName(imagesListers[i]), Images: len(res.images), Elapsed: res.elapsed, Err: res.err}
+		b.ListersStats = append(b.ListersStats, stats)
+		entry := logger.WithField("lister", stats.Lister).WithField("images", stats.Images).WithField("elapsed", stats.Elapsed)
+		if stats.Err != nil {
+			entry = entry.WithError(stats.Err)
+		}
+		entry.Debug("builder images loaded")
+	}
+	// Last resort listers are only used when no other lister provides any image
+	othersProvided := false
+	for i, res := range results {
+		if _, ok := imagesListers[i].(*LastResortImagesLister); !ok && res.err == nil && len(res.images) > 0 {
+			othersProvided = true
+		}
+	}
+	for i, res := range results {
+		if _, ok := imagesListers[i].(*LastResortImagesLister); ok && othersProvided {
+			continue
+		}
+		if res.err != nil {
+			// Builder repos that do not need the docker daemon, like yaml files, can still provide the images
+			var unreachable *DockerUnreachableError
+			if errors.As(res.err, &unreachable) && anyListerLoaded(results) {
+				logger.WithError(res.err).Warn("Skipping builder repos searched through the docker daemon")
 				continue
 			}
-			// Skip if key already exists: we have a descending prio list of docker repos!
-			if _, ok := b.Images[image.toKey()]; !ok {
-				b.Images[image.toKey()] = image
+			return res.err
+		}
+		provided := make([]Image, 0, len(res.images))
+		for _, image := range res.images {
+			if image.Arch != "" && image.Arch != arch {
+				// Like the ones of listers loading every architecture
+				continue
+			}
+			if !image.MultiArch {
+				// Images of unknown architecture, like the ones of images lists, are loaded for the build architecture,
+				// so that they share their keys with the ones of the same target and gcc of the other listers
+				image.Arch = arch
+			}
+			if pinned[image.toKey()] {
+				continue
+			}
+			if exclude != nil && exclude.MatchString(image.Name) {
+				logger.WithField("image", image).Debug("Excluding builder image")
+				continue
+			}
+			if b.ImageName != "" && !sameImageName(image.Name, b.ImageName) {
+				logger.WithField("image", image).WithField("imageName", b.ImageName).Debug("Skipping builder image not named as requested")
+				continue
+			}
+			if !b.providesKernel(image) {
+				logger.WithField("image", image).WithField("kernelrelease", b.KernelRelease).Debug("Skipping builder image not building for the kernel release")
+				continue
+			}
+			if !image.AnyGCC && b.deniesGCC(image.GCCVersion) {
+				logger.WithField("image", image).Debug("Skipping builder image providing a denied gcc")
+				b.deniedImages = append(b.deniedImages, image)
+				continue
+			}
+			if !b.providesGCC(image) || !b.providesClang(image) {
+				b.discardedImages = append(b.discardedImages, image)
+				continue
+			}
+			if !image.AnyGCC && b.belowMinGCC(image.GCCVersion) {
+				logger.WithField("image", image).WithField("minGCC", b.MinGCC).Debug("Skipping builder image providing a gcc lower than the minimum one")
+				b.belowMinImages = append(b.belowMinImages, image)
+				continue
 			}
+			provided = append(provided, image)
 		}
+		// Distro version specific images win over the generic ones of the same lister
+		sort.SliceStable(provided, func(i, j int) bool {
+			return provided[i].DistroVersion != "" && provided[j].DistroVersion == ""
+		})
+		b.Images.Merge(provided)
 	}
 	if len(b.Images) == 0 {
-		logger.Fatal("Could not load any builder image. Leaving.")
+		if len(b.discardedImages) > 0 || len(b.deniedImages) > 0 || len(b.belowMinImages) > 0 {
+			targets := b.targets()
+			if len(targets) == 1 {
+				return b.imageNotFound(targets[0], b.GCCVersion)
+			}
+			e := &TargetsImageNotFoundError{}
+			for _, target := range targets {
+				e.Errs = append(e.Errs, b.imageNotFound(target, b.GCCVersion))
+			}
+			return e
+		}
+		if b.ImageName != "" {
+			return fmt.Errorf("%w named %s", ErrNoImages, b.ImageName)
+		}
+		return ErrNoImages
+	}
+	return nil
+}
+
+// AvailableTargets returns the supported targets that the loaded builder images can build for,
+// in lexical order. Every supported target is available when any "any" target image is loaded.
+//
+// Call it only after LoadImages.
+func (b *Build) AvailableTargets() []Type {
+	anyGCC := make(map[Type]bool)
+	for _, img := range b.Images {
+		if img.AnyGCC {
+			anyGCC[img.Target] = true
+		}
+	}
+	targets := make([]Type, 0)
+	for target := range BuilderByTarget {
+		if len(b.GCCVersionsFor(target)) > 0 || anyGCC[target] || anyGCC["any"] {
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i] < targets[j]
+	})
+	return targets
+}
+
+// GCCVersionsFor returns the gcc versions provided by the loaded builder images for target,
+// including the ones of "any" target images, in ascending order.
+// Images providing every gcc do not contribute any version.
+//
+// Call it only after LoadImages.
+func (b *Build) GCCVersionsFor(target Type) []semver.Version {
+	seen := make(map[string]bool)
+	versions := make([]semver.Version, 0)
+	for _, img := range b.Images {
+		if (img.Target != target && img.Target != "any") || img.AnyGCC {
+			continue
+		}
+		if !seen[img.GCCVersion.String()] {
+			seen[img.GCCVersion.String()] = true
+			versions = append(versions, img.GCCVersion)
+		}
+	}
+	semver.Sort(versions)
+	return versions
+}
+
+// targetDefaultGCC returns the default gcc of the loaded builder images for target, if any; see Image.DefaultGCC.
+// Target-specific images win over "any" target ones, and, between images of the same target,
+// the ones sorted first by ImagesMap.Sorted win.
+//
+// Call it only after LoadImages.
+func (b *Build) targetDefaultGCC(target Type) (semver.Version, bool) {
+	var anyGCC *semver.Version
+	for _, img := range b.Images.Sorted() {
+		if !img.DefaultGCC {
+			continue
+		}
+		if img.Target == target {
+			return img.GCCVersion, true
+		}
+		if img.Target == "any" && anyGCC == nil {
+			gcc := img.GCCVersion
+			anyGCC = &gcc
+		}
+	}
+	if anyGCC != nil {
+		return *anyGCC, true
+	}
+	return semver.Version{}, false
+}
+
+// ProvidesAnyGCC returns whether any loaded builder image for target, or for "any" target, provides every gcc.
+//
+// Call it only after LoadImages.
+func (b *Build) ProvidesAnyGCC(target Type) bool {
+	for _, img := range b.Images {
+		if img.AnyGCC && (img.Target == target || img.Target == "any") {
+			return true
+		}
+	}
+	return false
+}
+
+// CandidateImages returns the loaded builder images that could build the kernel release for the targets of the build,
+// including "any" target images, that is the ones whose kernel range includes it and that provide the requested gcc and clang, if any.
+// Every target is considered when the build has none. Images are sorted as by ImagesMap.Sorted.
+//
+// Call it only after LoadImages.
+func (b *Build) CandidateImages(kr kernelrelease.KernelRelease) []Image {
+	targets := make(map[Type]bool)
+	for _, target := range b.targets() {
+		targets[target] = true
+	}
+	candidates := make([]Image, 0)
+	for _, img := range b.Images.Sorted() {
+		if !targets[""] && !targets[img.Target] && img.Target != "any" {
+			continue
+		}
+		if !img.buildsKernel(kr) || !b.providesGCC(img) || !b.providesClang(img) {
+			continue
+		}
+		candidates = append(candidates, img)
 	}
+	return candidates
 }
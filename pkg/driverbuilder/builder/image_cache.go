@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// ImagesCache configures the caching of the images found by RepoImagesLister.
+// Results are always cached in-process; when File is set, they are also persisted
+// to a json file to be reused by subsequent runs.
+// Cache entries are keyed by repo and store the raw image names found in it,
+// so that they can be reused for any target and architecture.
+type ImagesCache struct {
+	File   string
+	TTL    time.Duration // zero means that entries never expire
+	Bypass bool          // ignore cached entries, but still refresh them
+}
+
+type imagesCacheEntry struct {
+	Names     []string  `json:"names"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var (
+	imagesCacheMu      sync.Mutex
+	imagesCacheEntries = make(map[string]imagesCacheEntry)
+)
+
+func (c ImagesCache) isFresh(entry imagesCacheEntry) bool {
+	return c.TTL <= 0 || time.Since(entry.Timestamp) < c.TTL
+}
+
+func (c ImagesCache) readFile() map[string]imagesCacheEntry {
+	entries := make(map[string]imagesCacheEntry)
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WithError(err).WithField("file", c.File).Warn("error reading images cache file")
+		}
+		return entries
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		logger.WithError(err).WithField("file", c.File).Warn("error unmarshalling images cache file")
+	}
+	return entries
+}
+
+// load returns the cached image names for repo, if any.
+func (c ImagesCache) load(repo string) ([]string, bool) {
+	if c.Bypass {
+		return nil, false
+	}
+	imagesCacheMu.Lock()
+	defer imagesCacheMu.Unlock()
+
+	if entry, ok := imagesCacheEntries[repo]; ok && c.isFresh(entry) {
+		logger.WithField("Repository", repo).Debug("using in-process cached images")
+		return entry.Names, true
+	}
+	if c.File != "" {
+		if entry, ok := c.readFile()[repo]; ok && c.isFresh(entry) {
+			logger.WithField("Repository", repo).WithField("file", c.File).Debug("using file cached images")
+			imagesCacheEntries[repo] = entry
+			return entry.Names, true
+		}
+	}
+	return nil, false
+}
+
+// store caches the image names found for repo.
+func (c ImagesCache) store(repo string, names []string) {
+	imagesCacheMu.Lock()
+	defer imagesCacheMu.Unlock()
+
+	entry := imagesCacheEntry{Names: names, Timestamp: time.Now()}
+	imagesCacheEntries[repo] = entry
+	if c.File == "" {
+		return
+	}
+	entries := c.readFile()
+	entries[repo] = entry
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err == nil {
+		err = os.WriteFile(c.File, data, 0644)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("file", c.File).Warn("error writing images cache file")
+	}
+}
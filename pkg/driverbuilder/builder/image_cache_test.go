@@ -0,0 +1,41 @@
+package builder
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestImagesCache(t *testing.T) {
+	cache := ImagesCache{
+		File: filepath.Join(t.TempDir(), "cache.json"),
+		TTL:  time.Hour,
+	}
+	names := []string{"myorg/driverkit-builder-any-x86_64_gcc8.0.0"}
+
+	_, ok := cache.load("myorg/driverkit")
+	assert.Assert(t, !ok)
+
+	cache.store("myorg/driverkit", names)
+	cached, ok := cache.load("myorg/driverkit")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, names, cached)
+
+	// Entries are read back from the file when missing from the in-process cache
+	delete(imagesCacheEntries, "myorg/driverkit")
+	cached, ok = cache.load("myorg/driverkit")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, names, cached)
+
+	bypass := cache
+	bypass.Bypass = true
+	_, ok = bypass.load("myorg/driverkit")
+	assert.Assert(t, !ok)
+
+	expired := cache
+	expired.TTL = time.Nanosecond
+	_, ok = expired.load("myorg/driverkit")
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,38 @@
+package builder
+
+import (
+	"context"
+	"embed"
+	"fmt"
+
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+)
+
+// embeddedImages are the images lists of the official falcosecurity builder images,
+// one for each architecture (in its non-deb form), like "images/x86_64.yaml".
+//
+//go:embed images/*.yaml
+var embeddedImages embed.FS
+
+// EmbeddedImagesLister loads the official falcosecurity builder images for Architecture
+// from the images list embedded in driverkit, so that no builder repo needs to be configured.
+type EmbeddedImagesLister struct {
+	Architecture string
+}
+
+func (e *EmbeddedImagesLister) String() string {
+	return "embedded"
+}
+
+func (e *EmbeddedImagesLister) LoadImages(_ context.Context) ([]Image, error) {
+	arch, err := kernelrelease.ParseArchitecture(e.Architecture)
+	if err != nil {
+		return nil, err
+	}
+	filePath := "images/" + arch.ToNonDeb() + ".yaml"
+	file, err := embeddedImages.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening embedded builder images list %s: %w", filePath, err)
+	}
+	return parseImagesList(filePath, file, true, nil)
+}
@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/blang/semver"
+	"github.com/docker/docker/client"
+	logger "github.com/sirupsen/logrus"
+)
+
+// ImageInspector checks that a builder image is available, before building with it.
+type ImageInspector interface {
+	// InspectImage returns an error when the image, like "falcosecurity/driverkit-builder-centos:latest",
+	// cannot be used.
+	InspectImage(ctx context.Context, name string) error
+}
+
+// RegistryImageInspector inspects the builder images manifests in their registries through the docker daemon,
+// without pulling them.
+type RegistryImageInspector struct {
+	Auth  RegistryAuth
+	Auths RegistryAuths // credentials of specific repos or registries, used in place of Auth for them
+}
+
+// DigestResolver resolves the tag of a builder image to the digest it currently points to,
+// so that the build can be exactly reproduced later, even when the tag is pushed again.
+type DigestResolver interface {
+	// ResolveDigest returns the digest, like "sha256:...", of the image, like "falcosecurity/driverkit-builder-centos:latest".
+	ResolveDigest(ctx context.Context, name string) (string, error)
+}
+
+func (r *RegistryImageInspector) InspectImage(ctx context.Context, name string) error {
+	_, err := r.ResolveDigest(ctx, name)
+	return err
+}
+
+func (r *RegistryImageInspector) ResolveDigest(ctx context.Context, name string) (string, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+	auth, ok := r.Auths.lookup(name)
+	if !ok {
+		auth = r.Auth
+	}
+	registryAuth, err := auth.encode()
+	if err != nil {
+		return "", err
+	}
+	inspect, err := cli.DistributionInspect(ctx, name, registryAuth)
+	if err != nil {
+		return "", fmt.Errorf("error inspecting builder image %s: %w", name, err)
+	}
+	return inspect.Descriptor.Digest.String(), nil
+}
+
+// ResolveDigest returns the digest the image currently points to in its registry, like "sha256:...",
+// or its own digest when already pinned. Images without a tag are resolved as "latest".
+func (i Image) ResolveDigest(ctx context.Context, auth RegistryAuth) (string, error) {
+	if i.Digest != "" {
+		return i.Digest, nil
+	}
+	name := i.Name
+	if !hasTag(name) {
+		name += ":latest"
+	}
+	return (&RegistryImageInspector{Auth: auth}).ResolveDigest(ctx, name)
+}
+
+// findInspectedImage is like findImage, but, when the build has an ImageInspector,
+// images that fail the inspection are dropped from Images, falling back at the next candidate.
+func (b *Build) findInspectedImage(ctx context.Context, target Type, gccVers semver.Version) (Image, bool) {
+	for {
+		image, ok := b.findImage(b.Images, target, gccVers)
+		if !ok || b.ImageInspector == nil || b.hasCustomBuilderImage() {
+			return image, ok
+		}
+		name := b.taggedImageName(image)
+		err := b.ImageInspector.InspectImage(ctx, name)
+		if err == nil {
+			return image, true
+		}
+		logger.WithField("image", name).WithError(err).Warn("Builder image not available, falling back at the next one")
+		delete(b.Images, image.toKey())
+	}
+}
+
+// pinDigest pins the selected image to the digest its tag currently points to, when the build has a DigestResolver,
+// so that GetBuilderImage, and so the build and the resolved image it reports, refer to the digest.
+func (b *Build) pinDigest(ctx context.Context, image Image) error {
+	if b.DigestResolver == nil || image.Digest != "" || b.hasCustomBuilderImage() {
+		return nil
+	}
+	name := b.taggedImageName(image)
+	digest, err := b.DigestResolver.ResolveDigest(ctx, name)
+	if err != nil {
+		return fmt.Errorf("error resolving digest of builder image %s: %w", name, err)
+	}
+	logger.WithField("image", name).WithField("digest", digest).Info("builder image resolved to digest")
+	image.Digest = digest
+	b.Images[image.toKey()] = image
+	return nil
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCILayoutRepoScheme is the scheme of builder repos pointing at an OCI image layout directory on disk,
+// like "oci-layout:///path/to/layout", whose images are loaded by an OCILayoutImagesLister.
+const OCILayoutRepoScheme = "oci-layout://"
+
+// containerdImageNameAnnotation is the annotation holding the full reference of the images exported by containerd,
+// whose "org.opencontainers.image.ref.name" annotation only holds the tag.
+const containerdImageNameAnnotation = "io.containerd.image.name"
+
+// OCILayoutImagesLister loads images from an OCI image layout directory, like the ones exported by skopeo or containerd,
+// matching the reference names of the images listed in its index.json against the image regexes.
+// Images of other architectures are skipped. Images are named after their references in the layout,
+// like "docker.io/falcosecurity/driverkit-builder-centos-x86_64_gcc8.0.0:latest",
+// so they must be available under those names to the build processor, like when loaded into the docker daemon.
+type OCILayoutImagesLister struct {
+	path    string
+	arch    string           // deb form of the build architecture, like "amd64"
+	regs    []*regexp.Regexp // see repoRegexes
+	aliases TargetAliases
+}
+
+// NewOCILayoutImagesLister creates an OCILayoutImagesLister for the layout at repo, with or without OCILayoutRepoScheme.
+func NewOCILayoutImagesLister(repo string, build *Build) *OCILayoutImagesLister {
+	return &OCILayoutImagesLister{path: strings.TrimPrefix(repo, OCILayoutRepoScheme), arch: debArch(build.Architecture), regs: repoRegexes(build), aliases: build.TargetAliases}
+}
+
+func (l *OCILayoutImagesLister) String() string {
+	return OCILayoutRepoScheme + l.path
+}
+
+func (l *OCILayoutImagesLister) LoadImages(_ context.Context) ([]Image, error) {
+	if _, err := os.Stat(filepath.Join(l.path, v1.ImageLayoutFile)); err != nil {
+		return nil, fmt.Errorf("error opening OCI image layout %s: %w", l.path, err)
+	}
+	file, err := os.ReadFile(filepath.Join(l.path, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error opening OCI image layout %s: %w", l.path, err)
+	}
+	var index v1.Index
+	if err = json.Unmarshal(file, &index); err != nil {
+		return nil, fmt.Errorf("error unmarshalling OCI image layout index %s: %w", l.path, err)
+	}
+
+	var res []Image
+	seen := make(map[string]bool)
+	for _, manifest := range index.Manifests {
+		if manifest.Platform != nil && manifest.Platform.Architecture != "" && manifest.Platform.Architecture != l.arch {
+			continue
+		}
+		name := manifest.Annotations[containerdImageNameAnnotation]
+		if name == "" {
+			name = manifest.Annotations[v1.AnnotationRefName]
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		res = append(res, l.imagesFromRef(name)...)
+	}
+	return res, nil
+}
+
+// imagesFromRef returns the images matching the reference name, like "falcosecurity/driverkit-builder-centos-x86_64_gcc8.0.0:latest":
+// when the whole reference does not match, its name without the tag is matched, and the images keep the whole reference.
+func (l *OCILayoutImagesLister) imagesFromRef(ref string) []Image {
+	images := withArch(imagesFromNames(l.regs, l.aliases, []string{ref}), l.arch)
+	if len(images) > 0 || !hasTag(ref) {
+		return images
+	}
+	images = withArch(imagesFromNames(l.regs, l.aliases, []string{ref[:strings.LastIndex(ref, ":")]}), l.arch)
+	for i := range images {
+		images[i].Name = ref
+	}
+	return images
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const testOCILayoutIndex = `{
+  "schemaVersion": 2,
+  "manifests": [
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+      "size": 500,
+      "annotations": {
+        "io.containerd.image.name": "docker.io/falcosecurity/driverkit-builder-centos-x86_64_gcc8.0.0:latest",
+        "org.opencontainers.image.ref.name": "latest"
+      }
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+      "size": 500,
+      "annotations": {
+        "org.opencontainers.image.ref.name": "ghcr.io/myorg/driverkit:driverkit-builder-any-x86_64_gcc12.0.0"
+      }
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+      "size": 500,
+      "platform": { "architecture": "arm64", "os": "linux" },
+      "annotations": {
+        "io.containerd.image.name": "docker.io/falcosecurity/driverkit-builder-centos_gcc9.0.0:latest"
+      }
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:4444444444444444444444444444444444444444444444444444444444444444",
+      "size": 500,
+      "annotations": {
+        "io.containerd.image.name": "docker.io/library/ubuntu:22.04"
+      }
+    },
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "digest": "sha256:5555555555555555555555555555555555555555555555555555555555555555",
+      "size": 500
+    }
+  ]
+}`
+
+func TestOCILayoutImagesLister(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "index.json"), []byte(testOCILayoutIndex), 0644))
+
+	lister := NewOCILayoutImagesLister(OCILayoutRepoScheme+dir, &Build{TargetType: "centos", Architecture: "amd64"})
+	assert.Equal(t, OCILayoutRepoScheme+dir, lister.String())
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(images))
+	assert.Equal(t, "docker.io/falcosecurity/driverkit-builder-centos-x86_64_gcc8.0.0:latest", images[0].Name)
+	assert.Equal(t, Type("centos"), images[0].Target)
+	assert.Equal(t, "8.0.0", images[0].GCCVersion.String())
+	assert.Equal(t, "ghcr.io/myorg/driverkit:driverkit-builder-any-x86_64_gcc12.0.0", images[1].Name)
+	assert.Equal(t, Type("any"), images[1].Target)
+	assert.Equal(t, "12.0.0", images[1].GCCVersion.String())
+
+	// arm64 builds only get the arm64 multi-arch image
+	lister = NewOCILayoutImagesLister(dir, &Build{TargetType: "centos", Architecture: "arm64"})
+	images, err = lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(images))
+	assert.Equal(t, "docker.io/falcosecurity/driverkit-builder-centos_gcc9.0.0:latest", images[0].Name)
+	assert.Equal(t, true, images[0].MultiArch)
+}
+
+func TestOCILayoutImagesListerMissing(t *testing.T) {
+	dir := t.TempDir()
+	lister := NewOCILayoutImagesLister(OCILayoutRepoScheme+dir, &Build{TargetType: "centos", Architecture: "amd64"})
+	_, err := lister.LoadImages(context.Background())
+	assert.ErrorContains(t, err, "error opening OCI image layout "+dir)
+}
@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v3"
+)
+
+// snapshotKey groups the gcc versions provided by the same builder image in a snapshot.
+type snapshotKey struct {
+	name      string
+	target    string
+	clang     string
+	multiArch bool
+	minKernel string
+	maxKernel string
+	digest    string
+	command   string // build command arguments, joined by snapshotCommandSep
+	distro    string
+}
+
+// snapshotCommandSep joins the build command arguments in a snapshotKey, that must be comparable.
+const snapshotCommandSep = "\x00"
+
+// ImagesSnapshot returns an images list describing the loaded builder images, with their tagged names,
+// that can be saved to a file and loaded back as a builder repo later, like in air-gapped environments.
+// When digests is true, each image is pinned to its digest, fetched from its registry through the docker daemon.
+//
+// Call it only after LoadImages.
+func (b *Build) ImagesSnapshot(ctx context.Context, digests bool) (YAMLImagesList, error) {
+	gccVersions := make(map[snapshotKey][]semver.Version)
+	anyGCC := make(map[snapshotKey]bool)
+	defaultGCC := make(map[snapshotKey]string)
+	for _, img := range b.Images {
+		// Keep the digest apart from the tagged name, so that the snapshot can be loaded back
+		digest := img.Digest
+		img.Digest = ""
+		key := snapshotKey{name: b.taggedImageName(img), target: img.Target.String(), multiArch: img.MultiArch, digest: digest, command: strings.Join(img.BuildCommand, snapshotCommandSep), distro: img.DistroVersion}
+		if img.ClangVersion.NE(semver.Version{}) {
+			key.clang = img.ClangVersion.String()
+		}
+		if img.MinKernel.NE(semver.Version{}) {
+			key.minKernel = img.MinKernel.String()
+		}
+		if img.MaxKernel.NE(semver.Version{}) {
+			key.maxKernel = img.MaxKernel.String()
+		}
+		if img.AnyGCC {
+			anyGCC[key] = true
+			// Register the image, even when it provides no explicit gcc
+			if _, ok := gccVersions[key]; !ok {
+				gccVersions[key] = nil
+			}
+			continue
+		}
+		gccVersions[key] = append(gccVersions[key], img.GCCVersion)
+		if img.DefaultGCC {
+			defaultGCC[key] = img.GCCVersion.String()
+		}
+	}
+
+	list := YAMLImagesList{Images: make([]YAMLImage, 0, len(gccVersions))}
+	for key, versions := range gccVersions {
+		semver.Sort(versions)
+		image := YAMLImage{Target: key.target, ClangVersion: key.clang, Name: key.name, MultiArch: key.multiArch, MinKernel: key.minKernel, MaxKernel: key.maxKernel, Digest: key.digest, DistroVersion: key.distro, DefaultGCC: defaultGCC[key]}
+		if key.command != "" {
+			image.BuildCommand = strings.Split(key.command, snapshotCommandSep)
+		}
+		if anyGCC[key] {
+			image.GCCVersions = append(image.GCCVersions, WildcardGCC)
+		}
+		for _, v := range versions {
+			image.GCCVersions = append(image.GCCVersions, v.String())
+		}
+		list.Images = append(list.Images, image)
+	}
+	sort.Slice(list.Images, func(i, j int) bool {
+		if list.Images[i].Name != list.Images[j].Name {
+			return list.Images[i].Name < list.Images[j].Name
+		}
+		return list.Images[i].Target < list.Images[j].Target
+	})
+
+	if digests {
+		if err := b.resolveDigests(ctx, &list); err != nil {
+			return YAMLImagesList{}, err
+		}
+	}
+	return list, nil
+}
+
+// resolveDigests pins the images of list to their digests, skipping the ones already pinned.
+func (b *Build) resolveDigests(ctx context.Context, list *YAMLImagesList) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return err
+	}
+	for i, image := range list.Images {
+		if image.Digest != "" {
+			continue
+		}
+		registryAuth, err := b.registryAuth(image.Name).encode()
+		if err != nil {
+			return err
+		}
+		inspect, err := cli.DistributionInspect(ctx, image.Name, registryAuth)
+		if err != nil {
+			return fmt.Errorf("error fetching digest of builder image %s: %w", image.Name, err)
+		}
+		list.Images[i].Digest = inspect.Descriptor.Digest.String()
+	}
+	return nil
+}
+
+// WriteFile saves the images list as yaml to filePath, gzip compressed when it ends with ".gz".
+func (l YAMLImagesList) WriteFile(filePath string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(filePath, ".gz") {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+		if err = w.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	if err = os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("error writing builder images snapshot %s: %w", filePath, err)
+	}
+	return nil
+}
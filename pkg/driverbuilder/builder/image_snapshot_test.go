@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+	"gotest.tools/assert"
+)
+
+func TestImagesSnapshot(t *testing.T) {
+	b := &Build{
+		BuilderImage: "auto:v0.1.0",
+		Images: MergeImages([]Image{
+			{Target: "centos", GCCVersion: semver.MustParse("5.0.0"), Name: "myorg/driverkit-builder-centos"},
+			{Target: "centos", GCCVersion: semver.MustParse("4.8.0"), Name: "myorg/driverkit-builder-centos"},
+			{Target: "any", GCCVersion: semver.MustParse("8.0.0"), ClangVersion: semver.MustParse("14.0.0"), Name: "ghcr.io/myorg/driverkit:builder-any"},
+			{Target: "any", GCCVersion: semver.MustParse("9.0.0"), Name: "myorg/driverkit-builder-any", MultiArch: true},
+		}),
+	}
+
+	list, err := b.ImagesSnapshot(context.Background(), false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []YAMLImage{
+		{Target: "any", GCCVersions: []string{"8.0.0"}, ClangVersion: "14.0.0", Name: "ghcr.io/myorg/driverkit:builder-any"},
+		{Target: "any", GCCVersions: []string{"9.0.0"}, Name: "myorg/driverkit-builder-any:v0.1.0", MultiArch: true},
+		{Target: "centos", GCCVersions: []string{"4.8.0", "5.0.0"}, Name: "myorg/driverkit-builder-centos:v0.1.0"},
+	}, list.Images)
+
+	// Snapshots are loaded back as they were saved, with pinned images
+	list.Images[2].Digest = "sha256:0123456789abcdef"
+	for _, name := range []string{"snapshot.yaml", "snapshot.yaml.gz"} {
+		filePath := filepath.Join(t.TempDir(), name)
+		assert.NilError(t, list.WriteFile(filePath))
+
+		images, err := (&FileImagesLister{FilePath: filePath}).LoadImages(context.Background())
+		assert.NilError(t, err)
+		loaded := MergeImages(images)
+		assert.Equal(t, 4, len(loaded))
+		assert.Equal(t, "myorg/driverkit-builder-centos:v0.1.0", loaded["centos_4.8.0"].Name)
+		assert.Equal(t, "sha256:0123456789abcdef", loaded["centos_4.8.0"].Digest)
+		assert.Assert(t, loaded["any_9.0.0_multiarch"].MultiArch)
+		assert.Equal(t, "14.0.0", loaded["any_8.0.0"].ClangVersion.String())
+
+		// Pinned names are used as they are
+		b = &Build{TargetType: "centos", GCCVersion: "4.8.0", Images: loaded}
+		assert.Equal(t, "myorg/driverkit-builder-centos:v0.1.0@sha256:0123456789abcdef", b.GetBuilderImage())
+
+		// Snapshots of pinned images keep them pinned
+		list, err = b.ImagesSnapshot(context.Background(), false)
+		assert.NilError(t, err)
+		assert.Equal(t, "myorg/driverkit-builder-centos:v0.1.0", list.Images[len(list.Images)-1].Name)
+		assert.Equal(t, "sha256:0123456789abcdef", list.Images[len(list.Images)-1].Digest)
+	}
+}
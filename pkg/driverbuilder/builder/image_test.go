@@ -0,0 +1,1777 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
+	logger "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"gotest.tools/assert"
+)
+
+const testImagesA = `images:
+  - name: myorg/driverkit-builder-a
+    target: any
+    gcc_versions:
+      - 8.0.0
+`
+
+const testImagesB = `images:
+  - name: myorg/driverkit-builder-b
+    target: centos
+    gcc_versions:
+      - 4.8.0
+      - 5.0.0
+      - "5"
+      - wrong
+`
+
+func writeTestImagesFiles(t *testing.T) string {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "b.yml"), []byte(testImagesB), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte(testImagesA), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("not an images list"), 0644))
+	return dir
+}
+
+func TestFileImagesListerLoadImages(t *testing.T) {
+	dir := writeTestImagesFiles(t)
+
+	tests := map[string]struct {
+		filePath string
+		expected []string
+	}{
+		"single file": {
+			filePath: filepath.Join(dir, "b.yml"),
+			expected: []string{"myorg/driverkit-builder-b", "myorg/driverkit-builder-b"},
+		},
+		"directory": {
+			filePath: dir,
+			expected: []string{"myorg/driverkit-builder-a", "myorg/driverkit-builder-b", "myorg/driverkit-builder-b"},
+		},
+		"glob": {
+			filePath: filepath.Join(dir, "*.y*ml"),
+			expected: []string{"myorg/driverkit-builder-a", "myorg/driverkit-builder-b", "myorg/driverkit-builder-b"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			lister := &FileImagesLister{FilePath: test.filePath}
+			images, err := lister.LoadImages(context.Background())
+			assert.NilError(t, err)
+			names := make([]string, 0, len(images))
+			for _, img := range images {
+				names = append(names, img.Name)
+			}
+			assert.DeepEqual(t, test.expected, names)
+		})
+	}
+}
+
+func TestFileImagesListerLoadImagesPaths(t *testing.T) {
+	dir := writeTestImagesFiles(t)
+	override := filepath.Join(t.TempDir(), "override.yaml")
+	assert.NilError(t, os.WriteFile(override, []byte("images:\n  - name: myorg/driverkit-builder-override\n    target: centos\n    gcc_versions: [ 5.0.0, 9.0.0 ]\n"), 0644))
+
+	lister := &FileImagesLister{FilePath: dir, Paths: []string{override}}
+	assert.Equal(t, dir+","+override, lister.String())
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	im := MergeImages(images)
+	assert.Equal(t, 4, len(im))
+	assert.Equal(t, "myorg/driverkit-builder-a", im["any_8.0.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-b", im["centos_4.8.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-override", im["centos_5.0.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-override", im["centos_9.0.0"].Name)
+
+	// Errors report the failing path
+	missing := filepath.Join(dir, "missing.yaml")
+	lister = &FileImagesLister{FilePath: dir, Paths: []string{missing}}
+	_, err = lister.LoadImages(context.Background())
+	assert.ErrorContains(t, err, missing)
+}
+
+func TestFileImagesListerLoadImagesGzip(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(testImagesA))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "a.yaml.gz"), buf.Bytes(), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "b.yml"), []byte(testImagesB), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "corrupted.yaml.gz"), buf.Bytes()[:10], 0644))
+
+	lister := &FileImagesLister{FilePath: filepath.Join(dir, "a.yaml.gz")}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(images))
+	assert.Equal(t, "myorg/driverkit-builder-a", images[0].Name)
+
+	lister = &FileImagesLister{FilePath: filepath.Join(dir, "corrupted.yaml.gz")}
+	_, err = lister.LoadImages(context.Background())
+	assert.ErrorContains(t, err, "error decompressing builder repo file")
+}
+
+func TestFileImagesListerLoadImagesErrors(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.yaml")
+	assert.NilError(t, os.WriteFile(malformed, []byte("images: [ {"), 0644))
+	noGCC := filepath.Join(dir, "nogcc.yaml")
+	assert.NilError(t, os.WriteFile(noGCC, []byte("images:\n  - name: myorg/driverkit-builder\n    target: any\n"), 0644))
+	unknownTarget := filepath.Join(dir, "unknowntarget.yaml")
+	assert.NilError(t, os.WriteFile(unknownTarget, []byte("images:\n  - name: myorg/driverkit-builder\n    target: ubunut\n    gcc_versions: [ 8.0.0 ]\n"), 0644))
+	unknownField := filepath.Join(dir, "unknownfield.yaml")
+	assert.NilError(t, os.WriteFile(unknownField, []byte("images:\n  - name: myorg/driverkit-builder\n    target: any\n    gcc_versions: [ 8.0.0 ]\n    gcc_version: 9.0.0\n"), 0644))
+
+	tests := map[string]string{
+		"missing file":  filepath.Join(dir, "missing.yaml"),
+		"malformed":     malformed,
+		"no gcc":        noGCC,
+		"empty pattern": filepath.Join(dir, "*.json"),
+		"strict target": unknownTarget,
+	}
+
+	for name, filePath := range tests {
+		t.Run(name, func(t *testing.T) {
+			lister := &FileImagesLister{FilePath: filePath, Strict: true}
+			_, err := lister.LoadImages(context.Background())
+			assert.ErrorContains(t, err, filePath)
+		})
+	}
+
+	// Unknown targets are skipped when not strict
+	lister := &FileImagesLister{FilePath: unknownTarget}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(images))
+}
+
+func testImagesMap() ImagesMap {
+	images := []Image{
+		{Target: "any", GCCVersion: semver.MustParse("5.0.0"), Name: "any-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("11.0.0"), Name: "any-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "debian", GCCVersion: semver.MustParse("9.0.0"), Name: "debian-builder"},
+	}
+	im := make(ImagesMap)
+	for _, img := range images {
+		im[img.toKey()] = img
+	}
+	return im
+}
+
+func TestEmbeddedImagesLister(t *testing.T) {
+	for _, arch := range []string{"amd64", "arm64"} {
+		images, err := (&EmbeddedImagesLister{Architecture: arch}).LoadImages(context.Background())
+		assert.NilError(t, err, arch)
+		assert.Assert(t, len(images) > 0, arch)
+		for _, img := range images {
+			assert.Assert(t, strings.Contains(img.Name, kernelrelease.Architecture(arch).ToNonDeb()), img.Name)
+		}
+	}
+
+	_, err := (&EmbeddedImagesLister{Architecture: "s390x"}).LoadImages(context.Background())
+	assert.Assert(t, err != nil)
+}
+
+func TestLastResortImagesLister(t *testing.T) {
+	var running, maxRun int32
+	lastResort := &LastResortImagesLister{ImagesLister: testImagesLister{{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "embedded-builder"}}}
+	repo := &PrioritizedImagesLister{ImagesLister: testImagesLister{{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "repo-builder"}}, Priority: -10}
+	unreachable := slowImagesLister{err: &DockerUnreachableError{Host: "unix:///var/run/docker.sock"}, running: &running, maxRun: &maxRun}
+
+	tests := map[string]struct {
+		listers  []ImagesLister
+		expected []string
+	}{
+		"other lister providing images":  {listers: []ImagesLister{lastResort, repo}, expected: []string{"repo-builder"}},
+		"no other lister":                {listers: []ImagesLister{lastResort}, expected: []string{"embedded-builder"}},
+		"other lister providing nothing": {listers: []ImagesLister{testImagesLister{}, lastResort}, expected: []string{"embedded-builder"}},
+		"other lister unreachable":       {listers: []ImagesLister{unreachable, lastResort}, expected: []string{"embedded-builder"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", ImagesListers: test.listers}
+			assert.NilError(t, b.LoadImages(context.Background()))
+			var names []string
+			for _, img := range b.Images.Sorted() {
+				names = append(names, img.Name)
+			}
+			assert.DeepEqual(t, test.expected, names)
+		})
+	}
+}
+
+func TestFindImage(t *testing.T) {
+	tests := map[string]struct {
+		target      Type
+		gcc         string
+		expectedGCC string
+		expectedImg string
+	}{
+		"exact target":        {"centos", "8.0.0", "8.0.0", "centos-builder"},
+		"exact any":           {"centos", "5.0.0", "5.0.0", "any-builder"},
+		"nearest lower":       {"centos", "10.0.0", "8.0.0", "centos-builder"},
+		"nearest lowest":      {"ubuntu", "4.8.0", "5.0.0", "any-builder"},
+		"other targets skip":  {"ubuntu", "9.0.0", "8.0.0", "any-builder"},
+		"nearest with target": {"debian", "10.0.0", "9.0.0", "debian-builder"},
+		"same major target":   {"debian", "9.3.0", "9.0.0", "debian-builder"},
+		"same major first":    {"centos", "8.3.0", "8.0.0", "centos-builder"},
+	}
+
+	im := testImagesMap()
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			img, ok := im.findImage(test.target, semver.MustParse(test.gcc))
+			assert.Assert(t, ok)
+			assert.Equal(t, test.expectedGCC, img.GCCVersion.String())
+			assert.Equal(t, test.expectedImg, img.Name)
+		})
+	}
+
+	_, ok := ImagesMap{}.findImage("centos", semver.MustParse("8.0.0"))
+	assert.Assert(t, !ok)
+}
+
+func TestFindImageMultiArch(t *testing.T) {
+	im := ImagesMap{}
+	for _, img := range []Image{
+		{Target: "any", GCCVersion: semver.MustParse("12.0.0"), Name: "any-multiarch-builder", MultiArch: true},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-multiarch-builder", MultiArch: true},
+	} {
+		im[img.toKey()] = img
+	}
+
+	// Only multi-arch images are available
+	img, ok := im.findImage("centos", semver.MustParse("8.0.0"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "centos-multiarch-builder", img.Name)
+	img, ok = im.findImage("ubuntu", semver.MustParse("11.0.0"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "any-multiarch-builder", img.Name)
+
+	// Architecture-specific images are preferred, even when providing the nearest gcc only
+	arch := Image{Target: "any", GCCVersion: semver.MustParse("9.0.0"), Name: "any-builder"}
+	im[arch.toKey()] = arch
+	img, ok = im.findImage("centos", semver.MustParse("8.0.0"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "any-builder", img.Name)
+}
+
+func TestFindImageSameMajor(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("9.2.0"), Name: "centos-builder-gcc92"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.4.0"), Name: "centos-builder-gcc94"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.5.1"), Name: "centos-builder-gcc951"},
+		{Target: "any", GCCVersion: semver.MustParse("9.3.0"), Name: "any-builder-gcc93"},
+		{Target: "any", GCCVersion: semver.MustParse("10.0.0"), Name: "any-builder-gcc10"},
+	})
+
+	tests := map[string]struct {
+		gcc          string
+		expectedName string
+	}{
+		"equally near, lower wins":  {gcc: "9.3.0", expectedName: "centos-builder-gcc92"},
+		"nearest minor":             {gcc: "9.5.0", expectedName: "centos-builder-gcc951"},
+		"nearest patch":             {gcc: "9.4.1", expectedName: "centos-builder-gcc94"},
+		"other major through any":   {gcc: "10.0.0", expectedName: "any-builder-gcc10"},
+		"other major nearest lower": {gcc: "11.0.0", expectedName: "any-builder-gcc10"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			img, ok := im.findImage("centos", semver.MustParse(test.gcc))
+			assert.Assert(t, ok)
+			assert.Equal(t, test.expectedName, img.Name)
+		})
+	}
+
+	// Target-specific images providing every gcc provide the exact one
+	wildcard := Image{Target: "centos", AnyGCC: true, Name: "centos-builder-anygcc"}
+	im[wildcard.toKey()] = wildcard
+	img, ok := im.findImage("centos", semver.MustParse("9.3.0"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "any-builder-gcc93", img.Name)
+}
+
+func TestFindImagePreferAny(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "centos-builder-gcc9"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("7.0.0"), Name: "any-builder-gcc7"},
+	})
+
+	tests := map[string]struct {
+		gcc          string
+		preferAny    bool
+		expectedName string
+	}{
+		"target-specific first":                 {gcc: "8.0.0", expectedName: "centos-builder"},
+		"any first":                             {gcc: "8.0.0", preferAny: true, expectedName: "any-builder"},
+		"target-specific gcc only":              {gcc: "9.0.0", preferAny: true, expectedName: "centos-builder-gcc9"},
+		"nearest gcc of any first":              {gcc: "8.5.0", preferAny: true, expectedName: "any-builder"},
+		"nearest gcc of target-specific first":  {gcc: "8.5.0", expectedName: "centos-builder"},
+		"lower gcc only provided by any target": {gcc: "7.0.0", expectedName: "any-builder-gcc7"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{PreferAnyImages: test.preferAny}
+			img, ok := b.findImage(im, "centos", semver.MustParse(test.gcc))
+			assert.Assert(t, ok)
+			assert.Equal(t, test.expectedName, img.Name)
+		})
+	}
+}
+
+func TestFindImageNearestGCC(t *testing.T) {
+	im := ImagesMap{}
+	for _, img := range []Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder-amd64", Arch: "amd64"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder", Arch: "amd64"},
+	} {
+		im[img.toKey()] = img
+	}
+
+	// Equally near images are always picked in the same order
+	b := &Build{Architecture: "amd64"}
+	for i := 0; i < 10; i++ {
+		img, ok := b.findImage(im, "centos", semver.MustParse("9.5.0"))
+		assert.Assert(t, ok)
+		assert.Equal(t, "centos-builder-amd64", img.Name)
+	}
+	delete(im, "centos_8.0.0_amd64")
+	for i := 0; i < 10; i++ {
+		img, ok := b.findImage(im, "centos", semver.MustParse("9.5.0"))
+		assert.Assert(t, ok)
+		assert.Equal(t, "centos-builder", img.Name)
+	}
+
+	// Enforced gcc versions only fall back at the nearest gcc when requested
+	b = &Build{Architecture: "amd64", GCCVersion: "9.5.0"}
+	_, ok := b.findImage(im, "centos", semver.MustParse("9.5.0"))
+	assert.Assert(t, !ok)
+	b.GCCNearest = true
+	_, ok = b.findImage(im, "centos", semver.MustParse("9.5.0"))
+	assert.Assert(t, ok)
+}
+
+func TestFindImagePreRelease(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.1-20210109+el8"), Name: "centos-builder-prerelease"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.1"), Name: "centos-builder"},
+	})
+	assert.Equal(t, 2, len(im))
+
+	// Build metadata is ignored
+	img, ok := im.findImage("centos", semver.MustParse("9.3.1-20210109"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "centos-builder-prerelease", img.Name)
+	assert.Equal(t, "9.3.1-20210109+el8", img.GCCVersion.String())
+
+	// Pre-releases are lower than their release
+	img, ok = im.findImage("centos", semver.MustParse("9.3.1-20210110"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "centos-builder-prerelease", img.Name)
+	img, ok = im.findImage("centos", semver.MustParse("9.4.0"))
+	assert.Assert(t, ok)
+	assert.Equal(t, "centos-builder", img.Name)
+}
+
+func TestProvidesGCC(t *testing.T) {
+	image := Image{Target: "any", GCCVersion: semver.MustParse("9.0.0")}
+	tests := map[string]struct {
+		build    Build
+		expected bool
+	}{
+		"no gcc":           {Build{}, true},
+		"exact":            {Build{GCCVersion: "9.0.0"}, true},
+		"exact tolerant":   {Build{GCCVersion: "v9.0.0"}, true},
+		"exact mismatch":   {Build{GCCVersion: "8.0.0"}, false},
+		"nearest mismatch": {Build{GCCVersion: "8.0.0", GCCNearest: true}, true},
+		"major":            {Build{GCCVersion: "9"}, true},
+		"major mismatch":   {Build{GCCVersion: "8", GCCNearest: true}, false},
+		"minor":            {Build{GCCVersion: "9.0"}, true},
+		"minor mismatch":   {Build{GCCVersion: "9.1"}, false},
+		"range":            {Build{GCCVersion: ">=9.0.0 <11.0.0"}, true},
+		"range mismatch":   {Build{GCCVersion: ">=10.0.0 <11.0.0"}, false},
+		"list":             {Build{GCCVersion: "8,9"}, true},
+		"list with range":  {Build{GCCVersion: "8, >=9.0.0 <10.0.0"}, true},
+		"list mismatch":    {Build{GCCVersion: "8,10.1"}, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.build.providesGCC(image))
+		})
+	}
+}
+
+func TestImagesFromNames(t *testing.T) {
+	tests := map[string]struct {
+		pattern  string
+		names    []string
+		expected []string
+	}{
+		"default pattern": {
+			pattern: "",
+			names: []string{
+				"falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0_gcc6.0.0",
+				"falcosecurity/driverkit-builder-centos-x86_64_gcc4.8.5",
+				"falcosecurity/driverkit-builder-debian-x86_64_gcc9.0.0",
+				"falcosecurity/driverkit-builder-any-aarch64_gcc10.0.0",
+				"falcosecurity/driverkit-builder-centos-x86_64_gcc11.0.0_clang14.0.0",
+				"falcosecurity/driverkit-builder-any_gcc12.0.0",
+				"falcosecurity/driverkit-builder-centos-x86_64_gcc9.3.1-20210109_gcc8.5.0",
+			},
+			expected: []string{"any_8.0.0", "any_6.0.0", "centos_4.8.5", "centos_11.0.0", "any_12.0.0_multiarch", "centos_9.3.1-20210109", "centos_8.5.0"},
+		},
+		"custom pattern": {
+			pattern: `driverkit-builder-(?P<target>{{ .Target }})-{{ .Arch }}(?P<gccVers>(-gcc-[0-9]+)+)$`,
+			names: []string{
+				"myorg/driverkit-builder-centos-x86_64-gcc-9",
+				"myorg/driverkit-builder-any-x86_64-gcc-10-gcc-11",
+				"myorg/driverkit-builder-any-x86_64-clang-14",
+			},
+			expected: []string{"centos_9.0.0", "any_10.0.0", "any_11.0.0"},
+		},
+		"mixed case": {
+			pattern: "",
+			names: []string{
+				"ghcr.io/myorg/driverkit:Driverkit-Builder-CentOS-x86_64_gcc4.8.5",
+				"ghcr.io/myorg/driverkit:driverkit-builder-ANY-x86_64_gcc8.0.0",
+				"ghcr.io/myorg/driverkit:driverkit-builder-centos-X86_64_gcc9.0.0",
+			},
+			expected: []string{"centos_4.8.5", "any_8.0.0"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			regs, err := ImageRegexes(test.pattern, "centos", "x86_64")
+			assert.NilError(t, err)
+
+			var keys []string
+			for _, img := range imagesFromNames(regs, nil, test.names) {
+				keys = append(keys, string(img.toKey()))
+			}
+			assert.DeepEqual(t, test.expected, keys)
+		})
+	}
+}
+
+func TestRepoRegexes(t *testing.T) {
+	names := []string{
+		"falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0",
+		"falcosecurity/driverkit-builder-any-aarch64_gcc9.0.0",
+		"falcosecurity/driverkit-builder-centos-x86_64_gcc4.8.5",
+		"falcosecurity/driverkit-builder-debian-aarch64_gcc10.0.0",
+	}
+	keys := func(lister *RepoImagesLister) []string {
+		var res []string
+		for _, img := range imagesFromNames(lister.regs, lister.aliases, names) {
+			res = append(res, string(img.toKey()))
+		}
+		return res
+	}
+
+	// Listers for different architectures and targets do not interfere
+	amd64 := NewRepoImagesLister("falcosecurity", &Build{TargetType: "centos", Architecture: "amd64"})
+	arm64 := NewRepoImagesLister("falcosecurity", &Build{TargetType: "debian", Architecture: "arm64"})
+	multi := NewRepoImagesLister("falcosecurity", &Build{TargetType: "centos", Targets: []Type{"centos", "debian"}, Architecture: "arm64"})
+	assert.DeepEqual(t, []string{"any_8.0.0", "centos_4.8.5"}, keys(amd64))
+	assert.DeepEqual(t, []string{"any_9.0.0", "debian_10.0.0"}, keys(arm64))
+	assert.DeepEqual(t, []string{"any_9.0.0", "debian_10.0.0"}, keys(multi))
+}
+
+func TestLoadImagesInvalidPattern(t *testing.T) {
+	b := &Build{TargetType: "centos", Architecture: "amd64", ImagePattern: "driverkit-builder-(?P<target>{{ .Target }})_gcc[0-9]+$"}
+	b.ImagesListers = []ImagesLister{NewRepoImagesLister("falcosecurity/driverkit", b)}
+	assert.ErrorContains(t, b.LoadImages(context.Background()), "invalid builder images pattern")
+}
+
+func TestImageRegexesErrors(t *testing.T) {
+	for _, pattern := range []string{"{{ .Missing", "driverkit-builder-(", "driverkit-builder-{{ .Target }}"} {
+		_, err := ImageRegexes(pattern, "centos", "x86_64")
+		assert.Assert(t, err != nil, pattern)
+	}
+}
+
+func TestProvidesClang(t *testing.T) {
+	image := Image{Target: "any", GCCVersion: semver.MustParse("9.0.0"), ClangVersion: semver.MustParse("14.0.0")}
+	tests := map[string]struct {
+		build    Build
+		expected bool
+	}{
+		"no clang":       {Build{ProbeFilePath: "/tmp/probe.o"}, true},
+		"no probe":       {Build{ClangVersion: "12.0.0"}, true},
+		"exact":          {Build{ClangVersion: "14", ProbeFilePath: "/tmp/probe.o"}, true},
+		"exact mismatch": {Build{ClangVersion: "12.0.0", ProbeFilePath: "/tmp/probe.o"}, false},
+		"range":          {Build{ClangVersion: ">=13.0.0", ProbeFilePath: "/tmp/probe.o"}, true},
+		"unknown image":  {Build{ClangVersion: ">=13.0.0", ProbeFilePath: "/tmp/probe.o"}, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			img := image
+			if name == "unknown image" {
+				img.ClangVersion = semver.Version{}
+			}
+			assert.Equal(t, test.expected, test.build.providesClang(img))
+		})
+	}
+}
+
+type testImagesLister []Image
+
+func (l testImagesLister) LoadImages(_ context.Context) ([]Image, error) {
+	return l, nil
+}
+
+// slowImagesLister loads its images after a delay, tracking the number of concurrent loads.
+type slowImagesLister struct {
+	images  testImagesLister
+	delay   time.Duration
+	err     error
+	running *int32
+	maxRun  *int32
+}
+
+func (l slowImagesLister) LoadImages(ctx context.Context) ([]Image, error) {
+	n := atomic.AddInt32(l.running, 1)
+	defer atomic.AddInt32(l.running, -1)
+	for {
+		max := atomic.LoadInt32(l.maxRun)
+		if n <= max || atomic.CompareAndSwapInt32(l.maxRun, max, n) {
+			break
+		}
+	}
+	time.Sleep(l.delay)
+	return l.images, l.err
+}
+
+func TestLoadImagesConcurrent(t *testing.T) {
+	var running, maxRun int32
+	var listers []ImagesLister
+	for i := 0; i < 2*maxConcurrentListers; i++ {
+		// Higher priority listers are the slowest ones
+		listers = append(listers, slowImagesLister{
+			images:  testImagesLister{{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: fmt.Sprintf("builder-%d", i)}},
+			delay:   time.Duration(2*maxConcurrentListers-i) * 10 * time.Millisecond,
+			running: &running,
+			maxRun:  &maxRun,
+		})
+	}
+
+	b := &Build{Architecture: "amd64", ImagesListers: listers, Images: ImagesMap{}}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, "builder-0", b.Images["centos_8.0.0_amd64"].Name)
+	assert.Assert(t, maxRun > 1)
+	assert.Assert(t, maxRun <= maxConcurrentListers)
+
+	// Errors are reported following the priority order too
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	b = &Build{Architecture: "amd64", Images: ImagesMap{}, ImagesListers: []ImagesLister{
+		slowImagesLister{delay: 20 * time.Millisecond, err: errFirst, running: &running, maxRun: &maxRun},
+		slowImagesLister{err: errSecond, running: &running, maxRun: &maxRun},
+	}}
+	assert.Equal(t, errFirst, b.LoadImages(context.Background()))
+}
+
+func TestLoadImagesStats(t *testing.T) {
+	var running, maxRun int32
+	dir := writeTestImagesFiles(t)
+	errSlow := errors.New("slow")
+	b := &Build{Architecture: "amd64", Images: ImagesMap{}, ImagesListers: []ImagesLister{
+		&PrioritizedImagesLister{ImagesLister: &FileImagesLister{FilePath: dir}, Priority: 10},
+		slowImagesLister{delay: 20 * time.Millisecond, err: errSlow, running: &running, maxRun: &maxRun},
+	}}
+	assert.Equal(t, errSlow, b.LoadImages(context.Background()))
+
+	assert.Equal(t, 2, len(b.ListersStats))
+	assert.Equal(t, dir, b.ListersStats[0].Lister)
+	assert.Equal(t, 3, b.ListersStats[0].Images)
+	assert.NilError(t, b.ListersStats[0].Err)
+	assert.Equal(t, "builder.slowImagesLister", b.ListersStats[1].Lister)
+	assert.Equal(t, 0, b.ListersStats[1].Images)
+	assert.Assert(t, b.ListersStats[1].Elapsed >= 20*time.Millisecond)
+	assert.Equal(t, errSlow, b.ListersStats[1].Err)
+}
+
+// countingImagesLister counts its loads.
+type countingImagesLister struct {
+	images []Image
+	loads  *int
+}
+
+func (l countingImagesLister) LoadImages(ctx context.Context) ([]Image, error) {
+	*l.loads++
+	return l.images, nil
+}
+
+func TestLoadImagesOnce(t *testing.T) {
+	loads := 0
+	lister := countingImagesLister{loads: &loads, images: []Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "builder-centos"},
+		{Target: "debian", GCCVersion: semver.MustParse("9.0.0"), Name: "builder-debian"},
+	}}
+	b := &Build{Architecture: "amd64", TargetType: "centos", Targets: []Type{"centos", "debian"}, GCCVersion: "8.0.0", ImagesListers: []ImagesLister{lister}}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.NilError(t, b.LoadImages(context.Background()))
+	// Images not providing the gcc are reported once
+	assert.Equal(t, 1, len(b.discardedImages))
+	for _, tb := range b.PerTarget() {
+		assert.NilError(t, tb.LoadImages(context.Background()))
+	}
+	assert.Equal(t, 1, loads)
+
+	// Other listers are loaded again
+	b.ImagesListers = []ImagesLister{lister}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, 2, loads)
+}
+
+func TestLoadImagesExclude(t *testing.T) {
+	repo := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "driverkit-builder-centos_gcc8.0.0-deprecated"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "driverkit-builder-any_gcc8.0.0"},
+	}
+	b := &Build{Architecture: "amd64", ImagesListers: []ImagesLister{repo}, ImageExclude: "-deprecated$"}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, 1, len(b.Images))
+	assert.Equal(t, "driverkit-builder-any_gcc8.0.0", b.Images["any_8.0.0_amd64"].Name)
+
+	b = &Build{Architecture: "amd64", ImagesListers: []ImagesLister{repo}, ImageExclude: "driverkit-builder-"}
+	assert.Equal(t, ErrNoImages, b.LoadImages(context.Background()))
+
+	b = &Build{Architecture: "amd64", ImagesListers: []ImagesLister{repo}, ImageExclude: "("}
+	assert.ErrorContains(t, b.LoadImages(context.Background()), "invalid builder images exclude pattern")
+}
+
+func TestLoadImagesKernelRange(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "kernels.yaml")
+	assert.NilError(t, os.WriteFile(filePath, []byte(`images:
+  - name: myorg/driverkit-builder-old
+    target: centos
+    gcc_versions: [ 4.8.0 ]
+    max_kernel: 3.10.0
+  - name: myorg/driverkit-builder-new
+    target: centos
+    gcc_versions: [ 4.8.0 ]
+    min_kernel: 3.10.1
+`), 0644))
+
+	tests := map[string]string{
+		"2.6.32-754.el6.x86_64":       "myorg/driverkit-builder-old",
+		"3.10.0-1160.el7.x86_64":      "myorg/driverkit-builder-old",
+		"4.18.0-348.el8.x86_64":       "myorg/driverkit-builder-new",
+		"5.14.0-70.13.1.el9_0.x86_64": "myorg/driverkit-builder-new",
+	}
+	for kernelRelease, expected := range tests {
+		t.Run(kernelRelease, func(t *testing.T) {
+			b := &Build{
+				TargetType:    "centos",
+				KernelRelease: kernelRelease,
+				Architecture:  "amd64",
+				ImagesListers: []ImagesLister{&FileImagesLister{FilePath: filePath}},
+			}
+			assert.NilError(t, b.LoadImages(context.Background()))
+			img, ok := b.findImage(b.Images, "centos", semver.MustParse("4.8.0"))
+			assert.Assert(t, ok)
+			assert.Equal(t, expected, img.Name)
+		})
+	}
+
+	assert.NilError(t, os.WriteFile(filePath, []byte("images:\n  - name: myorg/driverkit-builder\n    target: centos\n    gcc_versions: [ 4.8.0 ]\n    min_kernel: old\n"), 0644))
+	_, err := (&FileImagesLister{FilePath: filePath}).LoadImages(context.Background())
+	assert.ErrorContains(t, err, "wrong min kernel")
+}
+
+func TestImageString(t *testing.T) {
+	img := Image{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "falcosecurity/driverkit-builder-centos"}
+	assert.Equal(t, "centos/gcc9.3.0 -> falcosecurity/driverkit-builder-centos", img.String())
+	img = Image{Target: "any", GCCVersion: semver.MustParse("8.0.0"), ClangVersion: semver.MustParse("14.0.0"), Name: "builder-any", MultiArch: true}
+	assert.Equal(t, "any/gcc8.0.0/clang14.0.0/multiarch -> builder-any", img.String())
+}
+
+func TestImagesMapMarshalJSON(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "centos-builder", MaxKernel: semver.MustParse("3.10.0")},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), ClangVersion: semver.MustParse("14.0.0"), Name: "any-builder", MultiArch: true},
+	})
+	data, err := json.Marshal(im)
+	assert.NilError(t, err)
+	assert.Equal(t, `[{"target":"any","gcc_version":"8.0.0","clang_version":"14.0.0","name":"any-builder","multi_arch":true},`+
+		`{"target":"centos","gcc_version":"9.3.0","name":"centos-builder","max_kernel":"3.10.0"}]`, string(data))
+}
+
+func TestMergeImages(t *testing.T) {
+	high := []Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "high-builder"},
+	}
+	low := []Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "low-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "low-builder-multiarch", MultiArch: true},
+		{Target: "any", GCCVersion: semver.MustParse("9.0.0"), Name: "low-builder"},
+	}
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	images := MergeImages(high, low)
+	assert.Equal(t, 3, len(images))
+	// Conflicts are logged with both candidates
+	assert.Equal(t, 1, len(hook.AllEntries()))
+	assert.Equal(t, logger.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "high-builder", hook.LastEntry().Data["image"])
+	assert.Equal(t, "low-builder", hook.LastEntry().Data["skipped"])
+	assert.Equal(t, "high-builder", images["centos_8.0.0"].Name)
+	assert.Equal(t, "low-builder-multiarch", images["centos_8.0.0_multiarch"].Name)
+	assert.Equal(t, "low-builder", images["any_9.0.0"].Name)
+
+	// Images already in the map win
+	images = ImagesMap{"any_9.0.0": {Target: "any", GCCVersion: semver.MustParse("9.0.0"), Name: "my-builder"}}
+	images.Merge(high, low)
+	assert.Equal(t, "high-builder", images["centos_8.0.0"].Name)
+	assert.Equal(t, "my-builder", images["any_9.0.0"].Name)
+}
+
+func TestLoadImagesPriority(t *testing.T) {
+	repo := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "repo-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "repo-builder"},
+	}
+	file := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "file-builder"},
+	}
+
+	b := &Build{
+		Architecture:  "amd64",
+		ImagesListers: []ImagesLister{repo, &PrioritizedImagesLister{ImagesLister: file, Priority: 10}},
+		Images:        ImagesMap{},
+	}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, "file-builder", b.Images["centos_8.0.0_amd64"].Name)
+	assert.Equal(t, "repo-builder", b.Images["any_8.0.0_amd64"].Name)
+
+	// Same priority: the construction order wins
+	b = &Build{
+		Architecture:  "amd64",
+		ImagesListers: []ImagesLister{repo, &PrioritizedImagesLister{ImagesLister: file}},
+		Images:        ImagesMap{},
+	}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, "repo-builder", b.Images["centos_8.0.0_amd64"].Name)
+}
+
+func TestLoadImagesNoImages(t *testing.T) {
+	b := &Build{Architecture: "amd64"}
+	err := b.LoadImages(context.Background())
+	assert.Assert(t, errors.Is(err, ErrNoImages))
+}
+
+func TestLoadImagesUnsupportedArchitecture(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+	}
+
+	b := &Build{TargetType: "centos", Architecture: "x86", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	assert.ErrorContains(t, b.LoadImages(context.Background()), `unsupported architecture "x86"`)
+
+	// Non-deb aliases are supported too
+	b = &Build{TargetType: "centos", Architecture: "x86_64", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	assert.NilError(t, b.LoadImages(context.Background()))
+}
+
+func TestParseRepoPriority(t *testing.T) {
+	repo, priority, err := ParseRepoPriority("https://example.com/images.yaml?v=1=10")
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/images.yaml?v=1", repo)
+	assert.Equal(t, 10, priority)
+
+	for _, s := range []string{"falcosecurity/driverkit", "=10", "falcosecurity/driverkit=high"} {
+		_, _, err = ParseRepoPriority(s)
+		assert.Assert(t, err != nil, s)
+	}
+}
+
+func TestImagesArch(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "builder-x86_64", Arch: "amd64"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "builder-aarch64", Arch: "arm64"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "builder"},
+	})
+	// Images differing only by architecture do not overwrite each other
+	assert.Equal(t, 3, len(im))
+	for arch, expected := range map[string]string{"amd64": "builder-x86_64", "arm64": "builder-aarch64"} {
+		b := &Build{Architecture: arch}
+		img, ok := b.findImage(im, "centos", semver.MustParse("8.0.0"))
+		assert.Assert(t, ok)
+		assert.Equal(t, expected, img.Name)
+		// Images of unknown architecture suit every architecture
+		img, ok = b.findImage(im, "centos", semver.MustParse("9.0.0"))
+		assert.Assert(t, ok)
+		assert.Equal(t, "builder", img.Name)
+	}
+
+	// Only the images of the build architecture are loaded, along with the ones of unknown architecture,
+	// that share their keys with them
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "builder-x86_64", Arch: "amd64"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "builder-aarch64", Arch: "arm64"},
+	}
+	b := &Build{TargetType: "centos", Architecture: "x86_64", ImagesListers: []ImagesLister{lister}}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, 1, len(b.Images))
+	assert.Equal(t, "builder", b.Images["centos_8.0.0_amd64"].Name)
+}
+
+func TestFileImagesListerEnv(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "images.yaml")
+	assert.NilError(t, os.WriteFile(filePath, []byte(`images:
+  - name: ${TEST_DRIVERKIT_REGISTRY}/myorg/driverkit-builder-${TEST_DRIVERKIT_TARGET:-centos}
+    target: ${TEST_DRIVERKIT_TARGET:-centos}
+    gcc_versions: [ "8.0.0" ]
+`), 0644))
+	lister := &FileImagesLister{FilePath: filePath}
+
+	t.Setenv("TEST_DRIVERKIT_REGISTRY", "registry.example.com")
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(images))
+	assert.Equal(t, "registry.example.com/myorg/driverkit-builder-centos", images[0].Name)
+	assert.Equal(t, Type("centos"), images[0].Target)
+
+	t.Setenv("TEST_DRIVERKIT_TARGET", "debian")
+	images, err = lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "registry.example.com/myorg/driverkit-builder-debian", images[0].Name)
+	assert.Equal(t, Type("debian"), images[0].Target)
+
+	os.Unsetenv("TEST_DRIVERKIT_REGISTRY")
+	_, err = lister.LoadImages(context.Background())
+	assert.ErrorContains(t, err, "image ${TEST_DRIVERKIT_REGISTRY}/myorg/driverkit-builder-${TEST_DRIVERKIT_TARGET:-centos}: environment variable TEST_DRIVERKIT_REGISTRY is not set, and has no default")
+}
+
+func TestParseRepoChecksum(t *testing.T) {
+	hexSum := strings.Repeat("ab", 32)
+	repo, checksum, err := ParseRepoChecksum("https://example.com/images.yaml?v=1=sha256:" + strings.ToUpper(hexSum))
+	assert.NilError(t, err)
+	assert.Equal(t, "https://example.com/images.yaml?v=1", repo)
+	assert.Equal(t, hexSum, checksum)
+
+	for _, s := range []string{"/path/to/images.yaml", "=sha256:" + hexSum, "/path/to/images.yaml=sha256:abab", "/path/to/images.yaml=sha256:" + strings.Repeat("zz", 32)} {
+		_, _, err = ParseRepoChecksum(s)
+		assert.Assert(t, err != nil, s)
+	}
+}
+
+func TestFileImagesListerChecksum(t *testing.T) {
+	dir := writeTestImagesFiles(t)
+	filePath := filepath.Join(dir, "b.yml")
+	sum := sha256.Sum256([]byte(testImagesB))
+
+	lister := &FileImagesLister{FilePath: filePath, Checksum: hex.EncodeToString(sum[:])}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(images))
+
+	lister.Checksum = strings.Repeat("00", 32)
+	_, err = lister.LoadImages(context.Background())
+	var mismatchErr *ChecksumMismatchError
+	assert.Assert(t, errors.As(err, &mismatchErr))
+	assert.Equal(t, hex.EncodeToString(sum[:]), mismatchErr.Actual)
+	assert.ErrorContains(t, err, "checksum mismatch for builder repo file "+filePath+": expected sha256:"+lister.Checksum)
+
+	// Checksums only apply to single files
+	lister = &FileImagesLister{FilePath: dir, Checksum: hex.EncodeToString(sum[:])}
+	_, err = lister.LoadImages(context.Background())
+	assert.ErrorContains(t, err, "a checksum can only be verified for a single file")
+}
+
+func TestTargetAliases(t *testing.T) {
+	aliases := TargetAliases{"centos-stream": "centos", "rhel": "centos"}
+	assert.Equal(t, Type("centos"), aliases.Resolve("centos-stream"))
+	// Custom aliases win over default ones
+	assert.Equal(t, Type("centos"), aliases.Resolve("rhel"))
+	assert.Equal(t, Type("amazonlinux2"), aliases.Resolve("amzn2"))
+	assert.Equal(t, Type("debian"), aliases.Resolve("debian"))
+	assert.Equal(t, Type("redhat"), TargetAliases(nil).Resolve("rhel"))
+	assert.DeepEqual(t, []string{"centos-stream", "rhel"}, aliases.aliasesOf("centos"))
+	assert.Equal(t, 0, len(aliases.aliasesOf("redhat")))
+}
+
+func TestParseTargetAlias(t *testing.T) {
+	alias, target, err := ParseTargetAlias("centos-stream=centos")
+	assert.NilError(t, err)
+	assert.Equal(t, "centos-stream", alias)
+	assert.Equal(t, Type("centos"), target)
+
+	for _, s := range []string{"centos-stream", "=centos", "centos-stream=centos-stream", "centos=debian"} {
+		_, _, err = ParseTargetAlias(s)
+		assert.Assert(t, err != nil, s)
+	}
+}
+
+func TestFileImagesListerLoadImagesAliases(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "aliases.yaml")
+	assert.NilError(t, os.WriteFile(filePath, []byte("images:\n  - name: myorg/driverkit-builder-rhel\n    target: rhel\n    gcc_versions: [ 8.0.0 ]\n  - name: myorg/driverkit-builder-stream\n    target: centos-stream\n    gcc_versions: [ 9.0.0 ]\n"), 0644))
+
+	lister := &FileImagesLister{FilePath: filePath, Strict: true, Aliases: TargetAliases{"centos-stream": "centos"}}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	im := MergeImages(images)
+	assert.Equal(t, "myorg/driverkit-builder-rhel", im["redhat_8.0.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-stream", im["centos_9.0.0"].Name)
+}
+
+func TestRepoRegexesAliases(t *testing.T) {
+	names := []string{
+		"falcosecurity/driverkit-builder-centos-x86_64_gcc4.8.5",
+		"falcosecurity/driverkit-builder-centos-stream-x86_64_gcc8.0.0",
+		"falcosecurity/driverkit-builder-rhel-x86_64_gcc9.0.0",
+	}
+	b := &Build{TargetType: "centos", Targets: []Type{"centos", "redhat"}, Architecture: "amd64", TargetAliases: TargetAliases{"centos-stream": "centos"}}
+	lister := NewRepoImagesLister("falcosecurity", b)
+	var keys []string
+	for _, img := range imagesFromNames(lister.regs, lister.aliases, names) {
+		keys = append(keys, string(img.toKey()))
+	}
+	assert.DeepEqual(t, []string{"centos_4.8.5", "centos_8.0.0", "redhat_9.0.0"}, keys)
+}
+
+func TestAllArchsRepoImagesLister(t *testing.T) {
+	names := []string{
+		"falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0",
+		"falcosecurity/driverkit-builder-any-aarch64_gcc9.0.0",
+		"falcosecurity/driverkit-builder-centos-x86_64_gcc4.8.5",
+		"falcosecurity/driverkit-builder-centos-arm64_gcc4.8.5",
+		"falcosecurity/driverkit-builder-centos_gcc10.0.0",
+	}
+	describe := func(images []Image) []string {
+		var res []string
+		for _, img := range images {
+			res = append(res, string(img.toKey())+"/"+img.Arch)
+		}
+		return res
+	}
+
+	lister := NewRepoImagesLister("falcosecurity", &Build{TargetType: "centos", Architecture: "x86_64"})
+	assert.DeepEqual(t, []string{"any_8.0.0_amd64/amd64", "centos_4.8.5_amd64/amd64", "centos_10.0.0_multiarch/"}, describe(lister.images(names)))
+
+	lister = NewAllArchsRepoImagesLister("falcosecurity", &Build{TargetType: "centos", Architecture: "x86_64"})
+	assert.DeepEqual(t, []string{
+		"any_8.0.0_amd64/amd64", "centos_4.8.5_amd64/amd64", "centos_10.0.0_multiarch/",
+		"any_9.0.0_arm64/arm64", "centos_4.8.5_arm64/arm64",
+	}, describe(lister.images(names)))
+}
+
+func TestParsePinnedImage(t *testing.T) {
+	image, err := ParsePinnedImage("centos_4.8.5=falcosecurity/driverkit-builder-centos:v0.1.0@sha256:0123456789abcdef")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Image{
+		Target:     "centos",
+		GCCVersion: semver.MustParse("4.8.5"),
+		Name:       "falcosecurity/driverkit-builder-centos:v0.1.0",
+		Digest:     "sha256:0123456789abcdef",
+	}, image)
+
+	for _, s := range []string{
+		"centos_4.8.5",
+		"centos_4.8.5=falcosecurity/driverkit-builder-centos",
+		"centos_4.8.5=@sha256:0123456789abcdef",
+		"centos=falcosecurity/driverkit-builder-centos@sha256:0123456789abcdef",
+		"centos_gcc=falcosecurity/driverkit-builder-centos@sha256:0123456789abcdef",
+		"centOS_4.8.5=falcosecurity/driverkit-builder-centos@sha256:0123456789abcdef",
+	} {
+		_, err = ParsePinnedImage(s)
+		assert.Assert(t, err != nil, s)
+	}
+}
+
+func TestLoadImagesPinned(t *testing.T) {
+	repo := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.5"), Name: "repo-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "repo-builder"},
+	}
+	pinned, err := ParsePinnedImage("centos_4.8.5=pinned-builder@sha256:0123456789abcdef")
+	assert.NilError(t, err)
+
+	b := &Build{
+		TargetType:    "centos",
+		Architecture:  "amd64",
+		GCCVersion:    "4.8.5",
+		ImagesListers: []ImagesLister{repo},
+		PinnedImages:  []Image{pinned},
+	}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, 1, len(b.Images))
+	assert.Equal(t, "pinned-builder@sha256:0123456789abcdef", b.GetBuilderImage())
+	assert.Equal(t, "centos/gcc4.8.5 -> pinned-builder@sha256:0123456789abcdef", b.Images["centos_4.8.5_amd64"].String())
+}
+
+func TestAvailableTargets(t *testing.T) {
+	b := &Build{Images: ImagesMap{}}
+	assert.Equal(t, 0, len(b.AvailableTargets()))
+
+	img := Image{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"}
+	b.Images[img.toKey()] = img
+	assert.DeepEqual(t, []Type{"centos"}, b.AvailableTargets())
+
+	// "any" target images can build for every target
+	img = Image{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"}
+	b.Images[img.toKey()] = img
+	assert.Equal(t, len(BuilderByTarget), len(b.AvailableTargets()))
+}
+
+func TestGCCVersionsFor(t *testing.T) {
+	b := &Build{Images: testImagesMap()}
+	versions := func(target Type) []string {
+		var res []string
+		for _, v := range b.GCCVersionsFor(target) {
+			res = append(res, v.String())
+		}
+		return res
+	}
+	assert.DeepEqual(t, []string{"5.0.0", "8.0.0", "11.0.0"}, versions("centos"))
+	assert.DeepEqual(t, []string{"5.0.0", "8.0.0", "9.0.0", "11.0.0"}, versions("debian"))
+	assert.DeepEqual(t, []string{"5.0.0", "8.0.0", "11.0.0"}, versions("ubuntu"))
+
+	assert.Equal(t, false, b.ProvidesAnyGCC("centos"))
+	img := Image{Target: "any", Name: "any-builder-all", AnyGCC: true}
+	b.Images[img.toKey()] = img
+	assert.Equal(t, true, b.ProvidesAnyGCC("centos"))
+}
+
+func TestImageNotFoundError(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("10.2.0"), Name: "centos-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("5.0.0"), Name: "any-builder"},
+		{Target: "ubuntu", GCCVersion: semver.MustParse("9.3.0"), Name: "ubuntu-builder"},
+	}
+
+	// No image at all provides the gcc
+	b := &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "9.2.0", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	err := b.LoadImages(context.Background())
+	assert.Assert(t, errors.Is(err, ErrNoImages))
+	assert.Error(t, err, "could not find any builder image for target centos providing gcc 9.2.0 (tried centos_9.2.0, any_9.2.0); "+
+		"available images: centos-builder with gcc 10.2.0, any-builder with gcc 5.0.0")
+
+	// Another target image provides the gcc
+	b = &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "9.3.0", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	_, err = b.ResolveImage(context.Background())
+	var notFound *ImageNotFoundError
+	assert.Assert(t, errors.As(err, &notFound))
+	assert.Equal(t, Type("centos"), notFound.Target)
+	assert.DeepEqual(t, []ImageKey{"centos_9.3.0", "any_9.3.0"}, notFound.Tried)
+	assert.Equal(t, "centos-builder", notFound.Closest[0].Name)
+
+	// Every target is reported
+	b = &Build{TargetType: "centos", Targets: []Type{"centos", "ubuntu"}, Architecture: "amd64", GCCVersion: "9.2.0", ImagesListers: []ImagesLister{lister}, Images: ImagesMap{}}
+	err = b.LoadImages(context.Background())
+	assert.Assert(t, errors.Is(err, ErrNoImages))
+	assert.Error(t, err, "could not find any builder image for target centos providing gcc 9.2.0 (tried centos_9.2.0, any_9.2.0); "+
+		"available images: centos-builder with gcc 10.2.0, any-builder with gcc 5.0.0; "+
+		"could not find any builder image for target ubuntu providing gcc 9.2.0 (tried ubuntu_9.2.0, any_9.2.0); "+
+		"available images: ubuntu-builder with gcc 9.3.0, any-builder with gcc 5.0.0")
+	assert.Assert(t, errors.As(err, &notFound))
+	assert.Equal(t, Type("centos"), notFound.Target)
+}
+
+func TestLoadImagesAnyGCC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images.yaml")
+	assert.NilError(t, os.WriteFile(path, []byte(`images:
+  - name: myorg/driverkit-builder-centos
+    target: centos
+    gcc_versions: [ 8.0.0 ]
+  - name: myorg/driverkit-builder-bundle
+    target: any
+    gcc_versions: [ "*", "*" ]
+`), 0644))
+	lister := &FileImagesLister{FilePath: path}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(images))
+	assert.Equal(t, "any/gcc* -> myorg/driverkit-builder-bundle", images[1].String())
+
+	tests := map[string]struct {
+		target      Type
+		gcc         string
+		expectedGCC string
+		expectedImg string
+	}{
+		"exact target has priority": {"centos", "8.0.0", "8.0.0", "myorg/driverkit-builder-centos"},
+		"any gcc over nearest":      {"centos", "9.0.0", "9.0.0", "myorg/driverkit-builder-bundle"},
+		"any gcc for any target":    {"ubuntu", "4.8.0", "4.8.0", "myorg/driverkit-builder-bundle"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: test.target, Architecture: "amd64", GCCVersion: test.gcc, ImagesListers: []ImagesLister{lister}}
+			image, err := b.ResolveImage(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedImg, image.Name)
+			assert.Equal(t, test.expectedGCC, b.GCCVersion)
+		})
+	}
+
+	b := &Build{Architecture: "amd64", ImagesListers: []ImagesLister{lister}}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, len(BuilderByTarget), len(b.AvailableTargets()))
+	assert.DeepEqual(t, []semver.Version{semver.MustParse("8.0.0")}, b.GCCVersionsFor("centos"))
+}
+
+type testImageInspector map[string]bool
+
+func (missing testImageInspector) InspectImage(_ context.Context, name string) error {
+	if missing[name] {
+		return errors.New("manifest unknown")
+	}
+	return nil
+}
+
+func TestLoadImagesInspected(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("5.0.0"), Name: "old-centos-builder"},
+	}
+	newBuild := func(inspector ImageInspector) *Build {
+		return &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "8.0.0", GCCNearest: true, ImagesListers: []ImagesLister{lister}, ImageInspector: inspector}
+	}
+
+	b := newBuild(testImageInspector{})
+	image, err := b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "centos-builder", image.Name)
+
+	// Missing images fall back at the next candidate
+	b = newBuild(testImageInspector{"centos-builder:latest": true})
+	image, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "any-builder", image.Name)
+	assert.Equal(t, "any-builder:latest", b.GetBuilderImage())
+
+	b = newBuild(testImageInspector{"centos-builder:latest": true, "any-builder:latest": true})
+	image, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "old-centos-builder", image.Name)
+	assert.Equal(t, "5.0.0", b.GCCVersion)
+
+	b = newBuild(testImageInspector{"centos-builder:latest": true, "any-builder:latest": true, "old-centos-builder:latest": true})
+	_, err = b.ResolveImage(context.Background())
+	assert.Assert(t, errors.Is(err, ErrNoImages))
+
+	// User provided builder images are never inspected
+	b = newBuild(testImageInspector{"centos-builder:latest": true})
+	b.BuilderImage = "myorg/builder:latest"
+	_, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "myorg/builder:latest", b.GetBuilderImage())
+}
+
+const testImagesDefaultGCC = `images:
+  - name: myorg/driverkit-builder-centos
+    target: centos
+    gcc_versions: [ "4.8.0", "8.0.0", "9.0.0" ]
+    default_gcc: "8"
+  - name: myorg/driverkit-builder-any
+    target: any
+    gcc_versions: [ "5.0.0", "11.0.0" ]
+    default_gcc: "11.0.0"
+`
+
+func TestImagesDefaultGCC(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "images.yaml")
+	assert.NilError(t, os.WriteFile(filePath, []byte(testImagesDefaultGCC), 0644))
+
+	tests := map[string]struct {
+		target      Type
+		gcc         string
+		expectedGCC string
+	}{
+		"target default": {
+			target:      "centos",
+			expectedGCC: "8.0.0",
+		},
+		"any target default": {
+			target:      "ubuntu",
+			expectedGCC: "11.0.0",
+		},
+		"gcc set by user": {
+			target:      "centos",
+			gcc:         "9",
+			expectedGCC: "9.0.0",
+		},
+		"auto gcc": {
+			target:      "centos",
+			gcc:         GCCAuto,
+			expectedGCC: "4.8.0",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: test.target, KernelRelease: "3.10.0-957.el7.x86_64", Architecture: "amd64", GCCVersion: test.gcc, ImagesListers: []ImagesLister{&FileImagesLister{FilePath: filePath}}}
+			_, err := b.ResolveImage(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedGCC, b.GCCVersion)
+		})
+	}
+
+	assert.NilError(t, os.WriteFile(filePath, []byte(strings.Replace(testImagesDefaultGCC, `default_gcc: "8"`, `default_gcc: "7"`, 1)), 0644))
+	_, err := (&FileImagesLister{FilePath: filePath}).LoadImages(context.Background())
+	assert.ErrorContains(t, err, "default gcc 7 for image myorg/driverkit-builder-centos is not one of its gcc versions")
+}
+
+func TestImagesByName(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.5"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "amazonlinux2", GCCVersion: semver.MustParse("4.8.5"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "amazonlinux2", GCCVersion: semver.MustParse("7.3.0"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "any", Name: "myorg/driverkit-builder-any", AnyGCC: true},
+	})
+	assert.DeepEqual(t, []NamedImage{
+		{Name: "myorg/driverkit-builder-any", Targets: []Type{"any"}, AnyGCC: true},
+		{
+			Name:        "myorg/driverkit-builder-centos",
+			Targets:     []Type{"amazonlinux2", "centos"},
+			GCCVersions: []semver.Version{semver.MustParse("4.8.5"), semver.MustParse("7.3.0"), semver.MustParse("9.3.0")},
+		},
+	}, im.ByName())
+}
+
+func TestGCCCandidates(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "vanilla", GCCVersion: semver.MustParse("5.0.0"), Name: "vanilla-builder"},
+		{Target: "vanilla", GCCVersion: semver.MustParse("8.0.0"), Name: "vanilla-builder"},
+		{Target: "vanilla", GCCVersion: semver.MustParse("9.0.0"), Name: "vanilla-builder"},
+		{Target: "vanilla", GCCVersion: semver.MustParse("11.0.0"), Name: "vanilla-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("7.0.0"), Name: "any-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.1.0"), Name: "centos-builder"},
+	}
+	b := &Build{TargetType: "vanilla", KernelRelease: "4.15.0", Architecture: "amd64", GCCVersion: GCCAuto, ImagesListers: []ImagesLister{lister}}
+	candidates, err := b.GCCCandidates(context.Background())
+	assert.NilError(t, err)
+	var versions []string
+	for _, v := range candidates {
+		versions = append(versions, v.String())
+	}
+	// Best-match gcc for 4.x kernels first, then the nearest ones, the ones of the same image first
+	assert.DeepEqual(t, []string{"8.0.0", "9.0.0", "7.0.0", "5.0.0", "11.0.0"}, versions)
+	assert.Equal(t, GCCAuto, b.GCCVersion)
+
+	// Auto gcc mode picks the best-match gcc, when resolving the image
+	_, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "8.0.0", b.GCCVersion)
+}
+
+func TestRepoRegexesArchitectureAliases(t *testing.T) {
+	names := []string{
+		"myorg/driverkit-builder-any-x86_64_gcc8.0.0",
+		"myorg/driverkit-builder-centos-amd64_gcc4.8.5",
+		"myorg/driverkit-builder-any-arm64_gcc9.0.0",
+		"myorg/driverkit-builder-debian-aarch64_gcc10.0.0",
+	}
+	tests := map[string]struct {
+		target   Type
+		expected []string
+	}{
+		"amd64":   {"centos", []string{"any_8.0.0", "centos_4.8.5"}},
+		"x86_64":  {"centos", []string{"any_8.0.0", "centos_4.8.5"}},
+		"arm64":   {"debian", []string{"any_9.0.0", "debian_10.0.0"}},
+		"aarch64": {"debian", []string{"any_9.0.0", "debian_10.0.0"}},
+	}
+	for arch, test := range tests {
+		t.Run(arch, func(t *testing.T) {
+			lister := NewRepoImagesLister("myorg", &Build{TargetType: test.target, Architecture: arch})
+			var keys []string
+			for _, img := range imagesFromNames(lister.regs, lister.aliases, names) {
+				keys = append(keys, string(img.toKey()))
+			}
+			assert.DeepEqual(t, test.expected, keys)
+		})
+	}
+}
+
+func TestGetBuildCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images.yaml")
+	assert.NilError(t, os.WriteFile(path, []byte(`images:
+  - name: myorg/driverkit-builder-centos
+    target: centos
+    gcc_versions: [ 8.0.0 ]
+    build_command: [ /bin/sh, -e, /driverkit/driverkit.sh ]
+  - name: myorg/driverkit-builder-any
+    target: any
+    gcc_versions: [ 9.0.0 ]
+`), 0644))
+	images, err := (&FileImagesLister{FilePath: path}).LoadImages(context.Background())
+	assert.NilError(t, err)
+
+	b := &Build{TargetType: "centos", GCCVersion: "8.0.0", Images: MergeImages(images)}
+	assert.DeepEqual(t, []string{"/bin/sh", "-e", "/driverkit/driverkit.sh"}, b.GetBuildCommand())
+
+	// Snapshots keep the build command
+	list, err := b.ImagesSnapshot(context.Background(), false)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"/bin/sh", "-e", "/driverkit/driverkit.sh"}, list.Images[1].BuildCommand)
+
+	b = &Build{TargetType: "ubuntu", GCCVersion: "9.0.0", Images: MergeImages(images)}
+	assert.DeepEqual(t, DefaultBuildCommand, b.GetBuildCommand())
+
+	// User provided builder images run the default build command
+	b = &Build{TargetType: "centos", GCCVersion: "8.0.0", Images: MergeImages(images), BuilderImage: "myorg/builder:latest"}
+	assert.DeepEqual(t, DefaultBuildCommand, b.GetBuildCommand())
+}
+
+func TestOnImageSelected(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("9.0.0"), Name: "any-builder"},
+	}
+	var selections []string
+	onImageSelected := func(target Type, gcc semver.Version, chosen Image, fallback bool) error {
+		selections = append(selections, fmt.Sprintf("%s %s %s %t", target, gcc, chosen.Name, fallback))
+		if chosen.Target == "any" {
+			return errors.New("\"any\" target images are not allowed")
+		}
+		return nil
+	}
+
+	b := &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "8.0.0", ImagesListers: []ImagesLister{lister}, OnImageSelected: onImageSelected}
+	_, err := b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+
+	b = &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "10.0.0", GCCNearest: true, ImagesListers: []ImagesLister{lister}, OnImageSelected: onImageSelected}
+	_, err = b.ResolveImage(context.Background())
+	assert.Error(t, err, "builder image any-builder rejected: \"any\" target images are not allowed")
+
+	assert.DeepEqual(t, []string{"centos 8.0.0 centos-builder false", "centos 10.0.0 any-builder true"}, selections)
+}
+
+func TestFileImagesListerInclude(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "fragments"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "images.yaml"), []byte(`include: [ fragments/centos.yaml ]
+images:
+  - name: myorg/driverkit-builder-override
+    target: centos
+    gcc_versions: [ 8.0.0 ]
+`), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "fragments", "centos.yaml"), []byte(`include: [ any.yaml ]
+images:
+  - name: myorg/driverkit-builder-centos
+    target: centos
+    gcc_versions: [ 5.0.0, 8.0.0 ]
+`), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "fragments", "any.yaml"), []byte(`images:
+  - name: myorg/driverkit-builder-any
+    target: any
+    gcc_versions: [ 9.0.0 ]
+`), 0644))
+
+	images, err := (&FileImagesLister{FilePath: filepath.Join(dir, "images.yaml")}).LoadImages(context.Background())
+	assert.NilError(t, err)
+	im := MergeImages(images)
+	assert.Equal(t, 3, len(im))
+	// The including file overrides the included ones
+	assert.Equal(t, "myorg/driverkit-builder-override", im["centos_8.0.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-centos", im["centos_5.0.0"].Name)
+	assert.Equal(t, "myorg/driverkit-builder-any", im["any_9.0.0"].Name)
+
+	// Include cycles are detected
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "fragments", "any.yaml"), []byte("include: [ ../images.yaml ]\n"), 0644))
+	_, err = (&FileImagesLister{FilePath: filepath.Join(dir, "images.yaml")}).LoadImages(context.Background())
+	assert.ErrorContains(t, err, "include cycle")
+
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "fragments", "any.yaml"), []byte("include: [ missing.yaml ]\n"), 0644))
+	_, err = (&FileImagesLister{FilePath: filepath.Join(dir, "images.yaml")}).LoadImages(context.Background())
+	assert.ErrorContains(t, err, "missing.yaml")
+}
+
+func TestImagesMapSorted(t *testing.T) {
+	im := MergeImages([]Image{
+		{Target: "ubuntu", GCCVersion: semver.MustParse("5.0.0"), Name: "ubuntu-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("10.0.0"), Name: "centos-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "centos-builder", MultiArch: true},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "centos-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"},
+		{Target: "any", Name: "any-bundle-builder", AnyGCC: true},
+	})
+	var images []string
+	for _, img := range im.Sorted() {
+		images = append(images, img.String())
+	}
+	assert.DeepEqual(t, []string{
+		"any/gcc* -> any-bundle-builder",
+		"any/gcc8.0.0 -> any-builder",
+		"centos/gcc9.0.0 -> centos-builder",
+		"centos/gcc9.0.0/multiarch -> centos-builder",
+		"centos/gcc10.0.0 -> centos-builder",
+		"ubuntu/gcc5.0.0 -> ubuntu-builder",
+	}, images)
+}
+
+func TestCandidateImages(t *testing.T) {
+	images := MergeImages([]Image{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.0"), Name: "old-centos-builder", MaxKernel: semver.MustParse("3.10.0")},
+		{Target: "centos", GCCVersion: semver.MustParse("9.0.0"), Name: "centos-builder", MinKernel: semver.MustParse("4.0.0")},
+		{Target: "ubuntu", GCCVersion: semver.MustParse("9.0.0"), Name: "ubuntu-builder"},
+		{Target: "any", GCCVersion: semver.MustParse("8.0.0"), Name: "any-builder"},
+	})
+
+	tests := map[string]struct {
+		build         Build
+		kernelrelease string
+		expected      []string
+	}{
+		"old kernel": {
+			build:         Build{TargetType: "centos"},
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			expected:      []string{"any-builder", "old-centos-builder"},
+		},
+		"new kernel": {
+			build:         Build{TargetType: "centos"},
+			kernelrelease: "5.14.0-70.el9.x86_64",
+			expected:      []string{"any-builder", "centos-builder"},
+		},
+		"gcc": {
+			build:         Build{TargetType: "centos", GCCVersion: ">=9.0.0"},
+			kernelrelease: "5.14.0-70.el9.x86_64",
+			expected:      []string{"centos-builder"},
+		},
+		"multiple targets": {
+			build:         Build{Targets: []Type{"centos", "ubuntu"}},
+			kernelrelease: "5.14.0-70.el9.x86_64",
+			expected:      []string{"any-builder", "centos-builder", "ubuntu-builder"},
+		},
+		"no target": {
+			build:         Build{},
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			expected:      []string{"any-builder", "old-centos-builder", "ubuntu-builder"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.build.Images = images
+			var names []string
+			for _, img := range test.build.CandidateImages(kernelrelease.FromString(test.kernelrelease)) {
+				names = append(names, img.Name)
+			}
+			assert.DeepEqual(t, test.expected, names)
+		})
+	}
+}
+
+func TestLoadImagesGCCDeny(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "myorg/driverkit-builder-centos-gcc8"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "myorg/driverkit-builder-centos-gcc9"},
+		{Target: "any", Name: "myorg/driverkit-builder-bundle", AnyGCC: true},
+	}
+
+	tests := map[string]struct {
+		gcc         string
+		nearest     bool
+		deny        []string
+		expectedGCC string
+		expectedImg string
+		expectedErr string
+	}{
+		"allowed gcc": {
+			gcc:         "9.3.0",
+			deny:        []string{"8.0.0"},
+			expectedGCC: "9.3.0",
+			expectedImg: "myorg/driverkit-builder-centos-gcc9",
+		},
+		"denied gcc": {
+			gcc:         "9.3.0",
+			deny:        []string{"9"},
+			expectedErr: "providing gcc 9.3.0 (tried centos_9.3.0, any_9.3.0); available images: myorg/driverkit-builder-centos-gcc8 with gcc 8.0.0, myorg/driverkit-builder-bundle with gcc *; images providing a denied gcc: myorg/driverkit-builder-centos-gcc9 with gcc 9.3.0",
+		},
+		"denied gcc range": {
+			gcc:         ">=9.0.0",
+			deny:        []string{">=9.0.0"},
+			expectedErr: "images providing a denied gcc: myorg/driverkit-builder-centos-gcc9 with gcc 9.3.0",
+		},
+		"nearest allowed gcc": {
+			gcc:         "9.3.0",
+			nearest:     true,
+			deny:        []string{"9.3.0"},
+			expectedGCC: "8.0.0",
+			expectedImg: "myorg/driverkit-builder-centos-gcc8",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: test.gcc, GCCNearest: test.nearest, GCCDeny: test.deny, ImagesListers: []ImagesLister{lister}}
+			image, err := b.ResolveImage(context.Background())
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				assert.Assert(t, errors.Is(err, ErrNoImages))
+				// Denied images are reported once, however many times images are loaded
+				_, err = b.GCCCandidates(context.Background())
+				assert.ErrorContains(t, err, test.expectedErr)
+				assert.Equal(t, 1, len(b.deniedImages))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedImg, image.Name)
+			assert.Equal(t, test.expectedGCC, b.GCCVersion)
+		})
+	}
+}
+
+func TestLoadImagesMinGCC(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.5"), Name: "myorg/driverkit-builder-centos-gcc4"},
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "myorg/driverkit-builder-centos-gcc8"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "myorg/driverkit-builder-centos-gcc9"},
+	}
+	oldLister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("4.8.5"), Name: "myorg/driverkit-builder-centos-gcc4"},
+		{Target: "centos", GCCVersion: semver.MustParse("5.0.0"), Name: "myorg/driverkit-builder-centos-gcc5"},
+	}
+
+	tests := map[string]struct {
+		lister        ImagesLister
+		kernelrelease string
+		gcc           string
+		expectedImg   string
+		expectedErr   string
+	}{
+		"compliant best-match gcc": {
+			lister:        lister,
+			kernelrelease: "5.4.0-1.el8.x86_64",
+			expectedImg:   "myorg/driverkit-builder-centos-gcc9",
+		},
+		"nearest compliant gcc": {
+			lister:        lister,
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			expectedImg:   "myorg/driverkit-builder-centos-gcc8",
+		},
+		"enforced gcc below minimum": {
+			lister:        lister,
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			gcc:           "4.8.5",
+			expectedErr:   "gcc policy violation: target centos would be built with gcc 4.8.5, lower than the minimum gcc 8",
+		},
+		"only gcc below minimum": {
+			lister:        oldLister,
+			kernelrelease: "4.18.0-348.el8.x86_64",
+			expectedErr:   "gcc policy violation: target centos would be built with gcc 5.0.0, lower than the minimum gcc 8",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: test.kernelrelease, GCCVersion: test.gcc, MinGCC: "8", ImagesListers: []ImagesLister{test.lister}}
+			image, err := b.ResolveImage(context.Background())
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				var policyErr *GCCPolicyError
+				assert.Assert(t, errors.As(err, &policyErr))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedImg, image.Name)
+		})
+	}
+}
+
+func TestLoadImagesImageName(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "centos", GCCVersion: semver.MustParse("9.3.0"), Name: "myorg/driverkit-builder-centos"},
+		{Target: "centos", GCCVersion: semver.MustParse("11.2.0"), Name: "myorg/driverkit-builder-centos-gcc11"},
+		{Target: "any", GCCVersion: semver.MustParse("10.0.0"), Name: "ghcr.io/myorg/driverkit:driverkit-builder-any-x86_64_gcc10.0.0"},
+	}
+
+	tests := map[string]struct {
+		imageName   string
+		expectedImg string
+		expectedGCC string
+		expectedErr string
+	}{
+		"best gcc of the named image": {
+			imageName:   "myorg/driverkit-builder-centos",
+			expectedImg: "myorg/driverkit-builder-centos",
+			expectedGCC: "9.3.0",
+		},
+		"tagged docker hub name": {
+			imageName:   "docker.io/myorg/driverkit-builder-centos:latest",
+			expectedImg: "myorg/driverkit-builder-centos",
+			expectedGCC: "9.3.0",
+		},
+		"repository of tags": {
+			imageName:   "ghcr.io/myorg/driverkit",
+			expectedImg: "ghcr.io/myorg/driverkit:driverkit-builder-any-x86_64_gcc10.0.0",
+			expectedGCC: "10.0.0",
+		},
+		"no image with the name": {
+			imageName:   "myorg/driverkit-builder-debian",
+			expectedErr: "could not load any builder image named myorg/driverkit-builder-debian",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: "5.14.0-70.el9.x86_64", ImageName: test.imageName, ImagesListers: []ImagesLister{lister}}
+			image, err := b.ResolveImage(context.Background())
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				assert.Assert(t, errors.Is(err, ErrNoImages))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedImg, image.Name)
+			assert.Equal(t, test.expectedGCC, b.GCCVersion)
+		})
+	}
+}
+
+func TestSelectionErrors(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "myorg/driverkit-builder-centos-gcc8"},
+	}
+
+	tests := map[string]struct {
+		build    *Build
+		expected error
+	}{
+		"no image for target": {
+			build:    &Build{TargetType: "debian", Architecture: "amd64", KernelRelease: "5.10.0-1-amd64", ImagesListers: []ImagesLister{lister}},
+			expected: ErrNoImageForTarget,
+		},
+		"no gcc match": {
+			build:    &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: "5.14.0-70.el9.x86_64", GCCVersion: "9.3.0", ImagesListers: []ImagesLister{lister}},
+			expected: ErrNoGCCMatch,
+		},
+		"gcc policy violation": {
+			build:    &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: "5.14.0-70.el9.x86_64", MinGCC: "9", ImagesListers: []ImagesLister{lister}},
+			expected: ErrNoGCCMatch,
+		},
+		"unsupported architecture": {
+			build:    &Build{TargetType: "centos", Architecture: "mips", KernelRelease: "5.14.0-70.el9.x86_64", ImagesListers: []ImagesLister{lister}},
+			expected: ErrArchUnsupported,
+		},
+	}
+
+	categories := []error{ErrNoImageForTarget, ErrNoGCCMatch, ErrArchUnsupported, ErrRegistryUnreachable}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := test.build.ResolveImage(context.Background())
+			assert.Assert(t, err != nil)
+			for _, category := range categories {
+				assert.Equal(t, category == test.expected, errors.Is(err, category), "%v", category)
+			}
+		})
+	}
+
+	assert.Assert(t, errors.Is(&DockerUnreachableError{Host: "unix:///var/run/docker.sock"}, ErrRegistryUnreachable))
+}
+
+type testDigestResolver map[string]string
+
+func (digests testDigestResolver) ResolveDigest(_ context.Context, name string) (string, error) {
+	if digest, ok := digests[name]; ok {
+		return digest, nil
+	}
+	return "", errors.New("manifest unknown")
+}
+
+func TestResolveImageDigest(t *testing.T) {
+	lister := testImagesLister{
+		{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "centos-builder"},
+		{Target: "centos", GCCVersion: semver.MustParse("5.0.0"), Name: "pinned-centos-builder", Digest: "sha256:pinned"},
+	}
+	newBuild := func(gcc string, resolver DigestResolver) *Build {
+		return &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: gcc, ImagesListers: []ImagesLister{lister}, DigestResolver: resolver}
+	}
+
+	b := newBuild("8.0.0", testDigestResolver{"centos-builder:latest": "sha256:current"})
+	image, err := b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "sha256:current", image.Digest)
+	assert.Equal(t, "centos-builder@sha256:current", b.GetBuilderImage())
+
+	// Pinned images are not resolved again
+	b = newBuild("5.0.0", testDigestResolver{})
+	_, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "pinned-centos-builder@sha256:pinned", b.GetBuilderImage())
+
+	b = newBuild("8.0.0", testDigestResolver{})
+	_, err = b.ResolveImage(context.Background())
+	assert.ErrorContains(t, err, "error resolving digest of builder image centos-builder:latest: manifest unknown")
+
+	// Without resolver, images are referred to by tag
+	b = newBuild("8.0.0", nil)
+	_, err = b.ResolveImage(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, "centos-builder:latest", b.GetBuilderImage())
+
+	digest, err := Image{Name: "centos-builder", Digest: "sha256:pinned"}.ResolveDigest(context.Background(), RegistryAuth{})
+	assert.NilError(t, err)
+	assert.Equal(t, "sha256:pinned", digest)
+}
+
+func TestLoadImagesDistroVersion(t *testing.T) {
+	regs, err := ImageRegexes("", "centos", "x86_64")
+	assert.NilError(t, err)
+	lister := testImagesLister(imagesFromNames(regs, nil, []string{
+		"myorg/driverkit-builder-centos-x86_64_gcc4.8.5",
+		"myorg/driverkit-builder-centos7-x86_64_gcc4.8.5",
+		"myorg/driverkit-builder-centos8-x86_64_gcc4.8.5",
+	}))
+
+	tests := map[string]struct {
+		kernelrelease string
+		expectedImg   string
+	}{
+		"centos 7 kernel": {
+			kernelrelease: "3.10.0-957.el7.x86_64",
+			expectedImg:   "myorg/driverkit-builder-centos7-x86_64_gcc4.8.5",
+		},
+		"centos 8 kernel": {
+			kernelrelease: "4.18.0-348.el8.x86_64",
+			expectedImg:   "myorg/driverkit-builder-centos8-x86_64_gcc4.8.5",
+		},
+		"other distro version": {
+			kernelrelease: "5.14.0-70.el9.x86_64",
+			expectedImg:   "myorg/driverkit-builder-centos-x86_64_gcc4.8.5",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &Build{TargetType: "centos", Architecture: "amd64", KernelRelease: test.kernelrelease, GCCVersion: "4.8.5", ImagesListers: []ImagesLister{lister}}
+			image, err := b.ResolveImage(context.Background())
+			assert.NilError(t, err)
+			assert.Equal(t, test.expectedImg, image.Name)
+		})
+	}
+}
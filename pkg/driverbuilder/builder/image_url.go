@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// URLImagesLister loads images from a yaml image list file served over http(s).
+// Images are skipped with a warning when the file cannot be fetched,
+// so that the other builder repos are still used.
+type URLImagesLister struct {
+	URL     string
+	Proxy   string        // proxy url used to fetch the file, if any
+	Timeout time.Duration // timeout of the request; no timeout when 0
+	Strict  bool          // see FileImagesLister
+	Aliases TargetAliases // see FileImagesLister
+	// Checksum is the expected sha256 of the file, in hex, verified before parsing it; see ParseRepoChecksum.
+	// Unlike fetch errors, a mismatch fails the load
+	Checksum string
+}
+
+func (u *URLImagesLister) String() string {
+	return u.URL
+}
+
+// IsURLRepo returns whether the builder repo is a remote images list, ie: an http(s) url.
+func IsURLRepo(repo string) bool {
+	return strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://")
+}
+
+func (u *URLImagesLister) LoadImages(ctx context.Context) ([]Image, error) {
+	file, err := u.fetch(ctx)
+	if err != nil {
+		logger.WithField("Repository", u.URL).WithError(err).Warnf("Skipping repo")
+		return []Image{}, nil
+	}
+	if err = verifyChecksum(u.URL, file, u.Checksum); err != nil {
+		return nil, err
+	}
+	return parseImagesList(u.URL, file, u.Strict, u.Aliases)
+}
+
+func (u *URLImagesLister) fetch(ctx context.Context) ([]byte, error) {
+	client, err := proxyClient(u.Proxy, u.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching builder repo file %s: %s", u.URL, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
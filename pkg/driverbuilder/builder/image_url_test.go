@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestURLImagesListerLoadImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/images.yaml":
+			w.Write([]byte(testImagesB))
+		case "/slow.yaml":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(testImagesA))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	lister := &URLImagesLister{URL: srv.URL + "/images.yaml"}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(images))
+	assert.Equal(t, "myorg/driverkit-builder-b", images[0].Name)
+
+	// Unreachable lists are skipped
+	for _, path := range []string{"/missing.yaml", "/slow.yaml"} {
+		lister = &URLImagesLister{URL: srv.URL + path, Timeout: 50 * time.Millisecond}
+		images, err = lister.LoadImages(context.Background())
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(images))
+	}
+
+	// Parent cancellation stops the fetch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lister = &URLImagesLister{URL: srv.URL + "/slow.yaml"}
+	images, err = lister.LoadImages(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(images))
+}
+
+func TestURLImagesListerChecksum(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testImagesB))
+	}))
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(testImagesB))
+	lister := &URLImagesLister{URL: srv.URL + "/images.yaml", Checksum: hex.EncodeToString(sum[:])}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(images))
+
+	// Unlike unreachable lists, tampered ones fail the load
+	lister.Checksum = strings.Repeat("00", 32)
+	_, err = lister.LoadImages(context.Background())
+	var mismatchErr *ChecksumMismatchError
+	assert.Assert(t, errors.As(err, &mismatchErr))
+}
+
+func TestURLImagesListerProxy(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		assert.Equal(t, "http://images.invalid/images.yaml", r.URL.String())
+		w.Write([]byte(testImagesA))
+	}))
+	defer proxy.Close()
+
+	lister := &URLImagesLister{URL: "http://images.invalid/images.yaml", Proxy: proxy.URL}
+	images, err := lister.LoadImages(context.Background())
+	assert.NilError(t, err)
+	assert.Assert(t, proxied)
+	assert.Equal(t, 1, len(images))
+}
+
+func TestIsURLRepo(t *testing.T) {
+	assert.Assert(t, IsURLRepo("https://example.com/images.yaml"))
+	assert.Assert(t, IsURLRepo("http://example.com/images.yaml"))
+	assert.Assert(t, !IsURLRepo("/path/to/images.yaml"))
+	assert.Assert(t, !IsURLRepo("docker.io/falcosecurity/driverkit"))
+}
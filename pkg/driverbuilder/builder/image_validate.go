@@ -0,0 +1,260 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestError is a problem found by ValidateManifest in an images list file.
+type ManifestError struct {
+	FilePath string
+	Line     int  // line of the problem in FilePath, or 0 when it concerns the whole file
+	Warning  bool // the images list can still be loaded, like when some targets are only covered by "any" images
+	Err      error
+}
+
+func (e *ManifestError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.FilePath)
+	if e.Line > 0 {
+		sb.WriteString(":" + strconv.Itoa(e.Line))
+	}
+	sb.WriteString(": ")
+	if e.Warning {
+		sb.WriteString("warning: ")
+	}
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// manifestLocation is where an image of an images list is declared.
+type manifestLocation struct {
+	filePath string
+	line     int
+}
+
+func (l manifestLocation) String() string {
+	return l.filePath + ":" + strconv.Itoa(l.line)
+}
+
+// manifestValidator holds the state of ValidateManifest across the images list files.
+type manifestValidator struct {
+	errs      []error
+	seen      map[string]manifestLocation // locations of the images keys, to report duplicates
+	targets   map[Type]bool               // targets having target-specific images
+	anyImages bool
+}
+
+// ValidateManifest checks the images list at path, like FileImagesLister would load it, without loading it into a Build:
+// path can be a single file, a directory or a glob pattern, and included files are checked too.
+// It reports every problem found, as a *ManifestError with its file and line:
+// unknown fields and targets, invalid gcc, clang and kernel versions,
+// the same target and gcc provided by more than one image, even across files,
+// and, as warnings, the supported targets only covered by "any" images.
+// A nil result means that the images list is valid.
+func ValidateManifest(path string) []error {
+	files, err := filePaths(path)
+	if err != nil {
+		return []error{&ManifestError{FilePath: path, Err: err}}
+	}
+	if len(files) == 0 {
+		return []error{&ManifestError{FilePath: path, Err: errors.New("no images list file found")}}
+	}
+	v := &manifestValidator{seen: make(map[string]manifestLocation), targets: make(map[Type]bool)}
+	for _, filePath := range files {
+		v.validateFile(filePath, make(map[string]bool))
+	}
+	if v.anyImages {
+		var uncovered []string
+		for _, target := range BuilderByTarget.Targets() {
+			if !v.targets[Type(target)] {
+				uncovered = append(uncovered, target)
+			}
+		}
+		sort.Strings(uncovered)
+		if len(uncovered) > 0 {
+			v.errs = append(v.errs, &ManifestError{FilePath: path, Warning: true, Err: fmt.Errorf("targets %v are only covered by \"any\" images", uncovered)})
+		}
+	}
+	return v.errs
+}
+
+func (v *manifestValidator) report(filePath string, line int, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ManifestError{FilePath: filePath, Line: line, Err: fmt.Errorf(format, args...)})
+}
+
+// yamlErrorLine matches the line prefix of yaml errors, like "line 3: field gcc_version not found in type builder.YAMLImage".
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): `)
+
+// reportYAMLError reports the yaml error message, moving its line, if any, to the ManifestError one.
+func (v *manifestValidator) reportYAMLError(filePath string, msg string) {
+	line := 0
+	if match := yamlErrorLine.FindStringSubmatch(msg); match != nil {
+		line, _ = strconv.Atoi(match[1])
+		msg = msg[len(match[0]):]
+	}
+	v.report(filePath, line, "%s", msg)
+}
+
+// validateFile checks the images list file and the files it includes, where including holds the absolute paths
+// of the files including filePath, to detect include cycles.
+func (v *manifestValidator) validateFile(filePath string, including map[string]bool) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		v.report(filePath, 0, "%v", err)
+		return
+	}
+	if including[absPath] {
+		v.report(filePath, 0, "include cycle")
+		return
+	}
+	including[absPath] = true
+	defer delete(including, absPath)
+
+	file, err := os.ReadFile(filePath)
+	if err != nil {
+		v.report(filePath, 0, "%v", err)
+		return
+	}
+	if file, err = decompressImagesList(filePath, file); err != nil {
+		v.report(filePath, 0, "%v", err)
+		return
+	}
+	var root yaml.Node
+	if err = yaml.Unmarshal(file, &root); err != nil {
+		v.reportYAMLError(filePath, err.Error())
+		return
+	}
+
+	// Same decoding as decodeImagesList in strict mode, but reporting every unknown field
+	var imageList YAMLImagesList
+	dec := yaml.NewDecoder(bytes.NewReader(file))
+	dec.KnownFields(true)
+	if err = dec.Decode(&imageList); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			v.reportYAMLError(filePath, err.Error())
+			return
+		}
+		for _, msg := range typeErr.Errors {
+			v.reportYAMLError(filePath, msg)
+		}
+		imageList = YAMLImagesList{}
+		if err = yaml.Unmarshal(file, &imageList); err != nil {
+			return
+		}
+	}
+	if len(imageList.Images) == 0 && len(imageList.Include) == 0 {
+		v.report(filePath, 0, "expected at least 1 image")
+	}
+
+	lines := imagesLines(&root)
+	for i, image := range imageList.Images {
+		line := 0
+		if i < len(lines) {
+			line = lines[i]
+		}
+		v.validateImage(manifestLocation{filePath: filePath, line: line}, image)
+	}
+	for _, include := range imageList.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filePath), include)
+		}
+		v.validateFile(include, including)
+	}
+}
+
+// validateImage checks the image declared at loc, like imagesFromList would load it.
+func (v *manifestValidator) validateImage(loc manifestLocation, image YAMLImage) {
+	if err := image.expandEnv(); err != nil {
+		v.report(loc.filePath, loc.line, "%v", err)
+	}
+	target := DefaultTargetAliases.Resolve(image.Target)
+	if _, ok := BuilderByTarget[target]; !ok && target != "any" {
+		v.report(loc.filePath, loc.line, "unknown target %q for image %s", image.Target, image.Name)
+	} else if target == "any" {
+		v.anyImages = true
+	} else {
+		v.targets[target] = true
+	}
+	if image.Name == "" {
+		v.report(loc.filePath, loc.line, "missing image name")
+	}
+	if len(image.GCCVersions) == 0 {
+		v.report(loc.filePath, loc.line, "expected at least 1 gcc version for image %s", image.Name)
+	}
+	if image.ClangVersion != "" {
+		if _, err := semver.ParseTolerant(image.ClangVersion); err != nil {
+			v.report(loc.filePath, loc.line, "wrong clang version %s for image %s: %v", image.ClangVersion, image.Name, err)
+		}
+	}
+	if _, err := parseKernelBound(image.MinKernel); err != nil {
+		v.report(loc.filePath, loc.line, "wrong min kernel for image %s: %v", image.Name, err)
+	}
+	if _, err := parseKernelBound(image.MaxKernel); err != nil {
+		v.report(loc.filePath, loc.line, "wrong max kernel for image %s: %v", image.Name, err)
+	}
+	if _, err := parseDefaultGCC(image); err != nil {
+		v.report(loc.filePath, loc.line, "%v", err)
+	}
+
+	for _, gcc := range image.GCCVersions {
+		img := Image{Target: target, MultiArch: image.MultiArch}
+		if gcc == WildcardGCC {
+			img.AnyGCC = true
+		} else {
+			gccVersion, err := semver.ParseTolerant(gcc)
+			if err != nil {
+				v.report(loc.filePath, loc.line, "wrong gcc version %s for image %s: %v", gcc, image.Name, err)
+				continue
+			}
+			img.GCCVersion = gccVersion
+		}
+		// Images specific to different distro versions do not clash
+		key := img.toKey().String()
+		if image.DistroVersion != "" {
+			key += "_" + image.DistroVersion
+		}
+		if first, ok := v.seen[key]; ok {
+			if first != loc {
+				v.report(loc.filePath, loc.line, "image %s provides %s, already provided at %s", image.Name, key, first)
+			}
+			continue
+		}
+		v.seen[key] = loc
+	}
+}
+
+// imagesLines returns the lines of the items of the "images" sequence of the yaml document root.
+func imagesLines(root *yaml.Node) []int {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	mapping := root.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != "images" {
+			continue
+		}
+		var lines []int
+		for _, item := range mapping.Content[i+1].Content {
+			lines = append(lines, item.Line)
+		}
+		return lines
+	}
+	return nil
+}
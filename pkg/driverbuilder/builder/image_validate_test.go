@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestValidateManifest(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected []string
+	}{
+		"valid": {
+			files: map[string]string{
+				"images.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos
+    gcc_versions: [ "8.0.0", "9.3.0" ]
+  - target: rhel
+    name: myorg/driverkit-builder-redhat
+    gcc_versions: [ "8.0.0" ]
+`,
+			},
+		},
+		"invalid fields": {
+			files: map[string]string{
+				"images.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos
+    gcc_version: [ "8.0.0" ]
+  - target: windows
+    name: myorg/driverkit-builder-windows
+    gcc_versions: [ "8.0.0", "wrong" ]
+    max_kernel: latest
+`,
+			},
+			expected: []string{
+				"images.yaml:4: field gcc_version not found in type builder.YAMLImage",
+				"images.yaml:2: expected at least 1 gcc version for image myorg/driverkit-builder-centos",
+				`images.yaml:5: unknown target "windows" for image myorg/driverkit-builder-windows`,
+				"images.yaml:5: wrong max kernel for image myorg/driverkit-builder-windows: latest is not a kernel release",
+				"images.yaml:5: wrong gcc version wrong for image myorg/driverkit-builder-windows: Invalid character(s) found in major number \"wrong\"",
+			},
+		},
+		"wrong default gcc": {
+			files: map[string]string{
+				"images.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos
+    gcc_versions: [ "8.0.0", "9.3.0" ]
+    default_gcc: "9.3"
+  - target: rhel
+    name: myorg/driverkit-builder-redhat
+    gcc_versions: [ "8.0.0" ]
+    default_gcc: "9.3.0"
+`,
+			},
+			expected: []string{
+				"images.yaml:6: default gcc 9.3.0 for image myorg/driverkit-builder-redhat is not one of its gcc versions",
+			},
+		},
+		"duplicates across files": {
+			files: map[string]string{
+				"a.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos
+    gcc_versions: [ "8.0.0" ]
+  - target: centos
+    name: myorg/driverkit-builder-centos8
+    distro_version: "8"
+    gcc_versions: [ "8.0.0" ]
+`,
+				"b.yaml": `include: [ fragments/c.yaml ]
+images:
+  - target: centos
+    name: myorg/driverkit-builder-centos-new
+    gcc_versions: [ "9.3.0", "8.0" ]
+`,
+				"fragments/c.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos-fragment
+    gcc_versions: [ "9.3.0" ]
+`,
+			},
+			expected: []string{
+				"b.yaml:3: image myorg/driverkit-builder-centos-new provides centos_8.0.0, already provided at DIR/a.yaml:2",
+				"c.yaml:2: image myorg/driverkit-builder-centos-fragment provides centos_9.3.0, already provided at DIR/b.yaml:3",
+			},
+		},
+		"any coverage gaps": {
+			files: map[string]string{
+				"images.yaml": `images:
+  - target: centos
+    name: myorg/driverkit-builder-centos
+    gcc_versions: [ "8.0.0" ]
+  - target: any
+    name: myorg/driverkit-builder-any
+    gcc_versions: [ "*" ]
+`,
+			},
+			expected: []string{
+				`warning: targets [almalinux amazonlinux`,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, content := range test.files {
+				assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755))
+				assert.NilError(t, os.WriteFile(filepath.Join(dir, file), []byte(content), 0644))
+			}
+			errs := ValidateManifest(dir)
+			assert.Equal(t, len(test.expected), len(errs), "%v", errs)
+			for i, expected := range test.expected {
+				assert.ErrorContains(t, errs[i], strings.ReplaceAll(expected, "DIR", dir))
+				var manifestErr *ManifestError
+				assert.Assert(t, errors.As(errs[i], &manifestErr))
+				assert.Equal(t, strings.HasPrefix(expected, "warning: "), manifestErr.Warning)
+			}
+		})
+	}
+}
+
+func TestValidateManifestMissing(t *testing.T) {
+	errs := ValidateManifest(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorContains(t, errs[0], "missing.yaml: ")
+}
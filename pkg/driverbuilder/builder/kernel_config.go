@@ -0,0 +1,107 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// kernelConfigOption matches the lines of kernel configs setting an option, like "CONFIG_MODULES=y".
+var kernelConfigOption = regexp.MustCompile(`^CONFIG_[A-Za-z0-9_]+=`)
+
+// LoadKernelConfig loads the kernel config (.config) at location, either a file path or an http(s) url,
+// fetched through proxy, if any, decompressing it when gzip compressed, like /proc/config.gz;
+// it fails when the file is not a plausible kernel config, see ValidateKernelConfig.
+func LoadKernelConfig(ctx context.Context, location string, proxy string, timeout time.Duration) ([]byte, error) {
+	var data []byte
+	var err error
+	if IsURLRepo(location) {
+		data, err = fetchKernelConfig(ctx, location, proxy, timeout)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading kernel config %s: %w", location, err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing kernel config %s: %w", location, err)
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("error decompressing kernel config %s: %w", location, err)
+		}
+	}
+	if err = ValidateKernelConfig(data); err != nil {
+		return nil, fmt.Errorf("invalid kernel config %s: %w", location, err)
+	}
+	return data, nil
+}
+
+func fetchKernelConfig(ctx context.Context, u string, proxy string, timeout time.Duration) ([]byte, error) {
+	client, err := proxyClient(proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// ValidateKernelConfig checks that data is a plausible kernel config:
+// apart from empty lines and comments, like "# CONFIG_DEBUG_INFO is not set", every line sets a CONFIG_ option,
+// and at least one does.
+func ValidateKernelConfig(data []byte) error {
+	options := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#"):
+		case kernelConfigOption.MatchString(text):
+			options++
+		default:
+			return fmt.Errorf("line %d does not set a CONFIG_ option: %q", line, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if options == 0 {
+		return fmt.Errorf("no CONFIG_ option found")
+	}
+	return nil
+}
+
+// kernelConfigFunc returns the "kernelconfig" function of build script templates: given the directory of the kernel
+// headers, it returns the bash lines applying the custom kernel config to them before building the drivers,
+// when CustomKernelConfig is set, and nothing otherwise.
+func (c Config) kernelConfigFunc() func(dir string) string {
+	return func(dir string) string {
+		if !c.CustomKernelConfig {
+			return ""
+		}
+		return fmt.Sprintf(`# Apply the custom kernel config
+cp /driverkit/kernel.config %[1]s/.config
+make -C %[1]s olddefconfig
+make -C %[1]s modules_prepare`, dir)
+	}
+}
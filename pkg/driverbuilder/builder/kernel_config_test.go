@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const testKernelConfig = `#
+# Automatically generated file; DO NOT EDIT.
+#
+CONFIG_MODULES=y
+CONFIG_HZ=250
+# CONFIG_DEBUG_INFO is not set
+CONFIG_LOCALVERSION="-custom"
+`
+
+func TestLoadKernelConfig(t *testing.T) {
+	dir := t.TempDir()
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte(testKernelConfig))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "config"), []byte(testKernelConfig), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "config.gz"), compressed.Bytes(), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "invalid"), []byte("CONFIG_MODULES=y\n<html>\n"), 0644))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testKernelConfig))
+	}))
+	defer server.Close()
+
+	tests := map[string]struct {
+		location string
+		err      string
+	}{
+		"file":            {location: filepath.Join(dir, "config")},
+		"compressed file": {location: filepath.Join(dir, "config.gz")},
+		"url":             {location: server.URL + "/config"},
+		"missing file":    {location: filepath.Join(dir, "missing"), err: "error loading kernel config"},
+		"missing url":     {location: server.URL + "/missing", err: "unexpected status: 404 Not Found"},
+		"invalid file":    {location: filepath.Join(dir, "invalid"), err: `line 2 does not set a CONFIG_ option: "<html>"`},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := LoadKernelConfig(context.Background(), test.location, "", 0)
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, testKernelConfig, string(data))
+		})
+	}
+}
+
+func TestValidateKernelConfig(t *testing.T) {
+	assert.NilError(t, ValidateKernelConfig([]byte(testKernelConfig)))
+	assert.Error(t, ValidateKernelConfig([]byte("# only comments\n\n")), "no CONFIG_ option found")
+	assert.Error(t, ValidateKernelConfig([]byte("MODULES=y\n")), `line 1 does not set a CONFIG_ option: "MODULES=y"`)
+}
+
+func TestKernelConfigFunc(t *testing.T) {
+	c := Config{Build: &Build{}}
+	assert.Equal(t, "", c.kernelConfigFunc()("/tmp/kernel"))
+	c.CustomKernelConfig = true
+	script := c.kernelConfigFunc()("$sourcedir")
+	assert.Assert(t, strings.Contains(script, "cp /driverkit/kernel.config $sourcedir/.config\n"))
+	assert.Assert(t, strings.Contains(script, "make -C $sourcedir olddefconfig\n"))
+}
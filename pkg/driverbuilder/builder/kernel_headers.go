@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KernelHeadersURL is a custom kernel headers package of a kernel release, like one stored in an internal artifact store,
+// used in place of the ones derived by builders; see Build.KernelHeadersURLs.
+type KernelHeadersURL struct {
+	KernelRelease string
+	URL           string
+	Checksum      string // hex sha256 verified by the build script after downloading the package; empty means none
+}
+
+// ParseKernelHeadersURL parses a "<kernelrelease>=<url>[#sha256=<hex>]" string,
+// like "5.10.0-custom=https://artifacts.example.com/linux-headers-5.10.0-custom.deb#sha256=e3b0c442...".
+func ParseKernelHeadersURL(s string) (KernelHeadersURL, error) {
+	kernelRelease, rawURL, ok := strings.Cut(s, "=")
+	if !ok || kernelRelease == "" || rawURL == "" {
+		return KernelHeadersURL{}, fmt.Errorf("kernel headers url must be in the <kernelrelease>=<url>[#sha256=<hex>] form: %s", s)
+	}
+	h := KernelHeadersURL{KernelRelease: kernelRelease, URL: rawURL}
+	if i := strings.LastIndex(rawURL, "#sha256="); i >= 0 {
+		h.URL = rawURL[:i]
+		h.Checksum = strings.ToLower(rawURL[i+len("#sha256="):])
+		if _, err := hex.DecodeString(h.Checksum); err != nil || len(h.Checksum) != sha256.Size*2 {
+			return KernelHeadersURL{}, fmt.Errorf("kernel headers checksum must be a sha256, like sha256=<64 hex digits>: %s", s)
+		}
+	}
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return KernelHeadersURL{}, fmt.Errorf("kernel headers url must be an http or https url: %s", s)
+	}
+	return h, nil
+}
+
+// kernelHeadersURLs returns the urls of the custom kernel headers packages of the kernel release of the build,
+// in order, and their checksums by resolved url, if any.
+func (b *Build) kernelHeadersURLs() ([]string, map[string]string, error) {
+	var urls []string
+	checksums := make(map[string]string)
+	for _, h := range b.KernelHeadersURLs {
+		if h.KernelRelease != b.KernelRelease {
+			continue
+		}
+		urls = append(urls, h.URL)
+		if h.Checksum != "" {
+			u, err := resolveURLReference(h.URL)
+			if err != nil {
+				return nil, nil, err
+			}
+			checksums[u] = h.Checksum
+		}
+	}
+	return urls, checksums, nil
+}
+
+// kernelHeadersRetryDelay is the delay, in seconds, of build scripts before the second round of attempts
+// to download a kernel headers package, doubled at each further round.
+const kernelHeadersRetryDelay = 5
+
+// MirrorURL returns u served by the mirror at the base url: the scheme and host of u are replaced by the ones of base,
+// whose path is prepended to the one of u, like "https://mirror.example.com/cache/ubuntu/pool/..."
+// for "https://archive.ubuntu.com/ubuntu/pool/..." and the "https://mirror.example.com/cache" mirror.
+func MirrorURL(base string, u string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	mirrored, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	mirrored.Scheme = baseURL.Scheme
+	mirrored.User = baseURL.User
+	mirrored.Host = baseURL.Host
+	mirrored.Path = strings.TrimSuffix(baseURL.Path, "/") + mirrored.Path
+	mirrored.RawPath = ""
+	return mirrored.String(), nil
+}
+
+// mirrorURLs returns u followed by the urls of its HeadersMirrors, in order, skipping the invalid ones.
+func (b *Build) mirrorURLs(u string) []string {
+	urls := []string{u}
+	for _, mirror := range b.HeadersMirrors {
+		if m, err := MirrorURL(mirror, u); err == nil && m != u {
+			urls = append(urls, m)
+		}
+	}
+	return urls
+}
+
+// downloadFunc returns the "download" function of build script templates: given the url of a kernel headers package
+// and the file to save it to, it returns the commands downloading it from its url and mirrors, in order,
+// for up to HeadersMaxAttempts rounds with an exponential backoff, verifying its checksum, if any,
+// and logging the url that ultimately served it.
+func (b *Build) downloadFunc(checksums map[string]string) func(u string, file string) string {
+	attempts := b.HeadersMaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	return func(u string, file string) string {
+		var urls []string
+		for _, m := range b.mirrorURLs(u) {
+			urls = append(urls, shellQuote(m))
+		}
+		verify := ""
+		if checksum, ok := checksums[u]; ok {
+			verify = fmt.Sprintf(" && echo %s | sha256sum -c -", shellQuote(checksum+"  "+file))
+		}
+		return fmt.Sprintf(`kernel_headers_url=""
+for attempt in $(seq 1 %[1]d); do
+  for url in %[2]s; do
+    if curl --silent --fail -o %[3]s -SL "$url"%[4]s; then
+      kernel_headers_url="$url"
+      break 2
+    fi
+  done
+  if [ "$attempt" -lt %[1]d ]; then
+    sleep $((%[5]d * 2 ** (attempt - 1)))
+  fi
+done
+if [ -z "$kernel_headers_url" ]; then
+  echo "error downloading kernel headers from "%[6]s" and its mirrors" >&2
+  exit 1
+fi
+echo "kernel headers downloaded from $kernel_headers_url"`, attempts, strings.Join(urls, " "), shellQuote(file), verify, kernelHeadersRetryDelay, shellQuote(u))
+	}
+}
+
+// shellQuote quotes s for bash.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
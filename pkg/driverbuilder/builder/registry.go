@@ -0,0 +1,409 @@
+package builder
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// RegistryAuth contains the credentials used to query private registries for builder images.
+// Token, when set, is sent as a bearer token and takes precedence over Username and Password.
+type RegistryAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+func (a RegistryAuth) isEmpty() bool {
+	return a.Username == "" && a.Password == "" && a.Token == ""
+}
+
+// encode returns the base64 encoded auth config expected by the docker daemon.
+func (a RegistryAuth) encode() (string, error) {
+	if a.isEmpty() {
+		return "", nil
+	}
+	buf, err := json.Marshal(types.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		RegistryToken: a.Token,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+func (a RegistryAuth) setHeader(req *http.Request) {
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	} else if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// RegistryAuths are the credentials of specific repos, like "registry.gitlab.com/myorg/driverkit",
+// or of whole registries, like "registry.gitlab.com", keyed by repo or registry host.
+// Docker Hub repos can be keyed with or without the "docker.io/" prefix.
+type RegistryAuths map[string]RegistryAuth
+
+// ParseRegistryAuth parses a "<repo>=<user>:<password>" or "<repo>=<token>" string,
+// like "registry.gitlab.com/myorg=deployer:secret"; "oci://" repos prefixes are ignored.
+// Errors never include the credentials.
+func ParseRegistryAuth(s string) (string, RegistryAuth, error) {
+	repo, creds, ok := strings.Cut(s, "=")
+	repo = strings.TrimSuffix(strings.TrimPrefix(repo, TagsRepoScheme), "/")
+	if !ok || repo == "" || creds == "" {
+		return "", RegistryAuth{}, fmt.Errorf("registry auth must be in the <repo>=<user>:<password> or <repo>=<token> form")
+	}
+	if user, password, ok := strings.Cut(creds, ":"); ok {
+		if user == "" {
+			return "", RegistryAuth{}, fmt.Errorf("registry auth of %s has an empty user", repo)
+		}
+		return repo, RegistryAuth{Username: user, Password: password}, nil
+	}
+	return repo, RegistryAuth{Token: creds}, nil
+}
+
+// lookup returns the credentials for name, either a repo or an image name, like "registry.gitlab.com/myorg/driverkit-builder-centos:latest":
+// the ones of its longest repo prefix, or of its registry host, if any.
+func (a RegistryAuths) lookup(name string) (RegistryAuth, bool) {
+	name = strings.TrimPrefix(name, TagsRepoScheme)
+	if domain, _ := splitRepo(name); domain == "" {
+		name = "docker.io/" + name
+	}
+	for prefix := name; prefix != ""; {
+		if auth, ok := a[prefix]; ok {
+			return auth, true
+		}
+		if auth, ok := a[strings.TrimPrefix(prefix, "docker.io/")]; ok {
+			return auth, true
+		}
+		i := strings.LastIndexAny(prefix, "/:@")
+		if i == -1 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return RegistryAuth{}, false
+}
+
+// registryAuth returns the credentials used to reach the registry of name, either a repo or an image name:
+// the ones given for it in RegistryAuths, if any, otherwise RegistryAuth.
+func (b *Build) registryAuth(name string) RegistryAuth {
+	if auth, ok := b.RegistryAuths.lookup(name); ok {
+		return auth
+	}
+	return b.RegistryAuth
+}
+
+// splitRepo splits a repo string, like "myregistry.io/falco", into its registry domain and path.
+// Domain is empty when the repo does not specify any registry host.
+func splitRepo(repo string) (string, string) {
+	i := strings.IndexRune(repo, '/')
+	if i == -1 {
+		return "", repo
+	}
+	domain := repo[:i]
+	// Same rule used by docker to tell a registry host apart from a docker hub user
+	if !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		return "", repo
+	}
+	return domain, repo[i+1:]
+}
+
+type registryCatalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+// catalogImages lists the images provided by a registry whose name starts with path, using the registry v2 "_catalog" API.
+func catalogImages(ctx context.Context, httpClient *http.Client, domain, path string, auth RegistryAuth) ([]string, error) {
+	repositories, err := listCatalog(ctx, httpClient, "https://"+domain, auth)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, repository := range repositories {
+		if strings.HasPrefix(repository, path) {
+			names = append(names, domain+"/"+repository)
+		}
+	}
+	return names, nil
+}
+
+// listCatalog lists the repositories of a registry, using the registry v2 "_catalog" API,
+// following pagination through the "Link" header.
+func listCatalog(ctx context.Context, httpClient *http.Client, baseURL string, auth RegistryAuth) ([]string, error) {
+	next, err := url.Parse(baseURL + "/v2/_catalog")
+	if err != nil {
+		return nil, err
+	}
+	var repositories []string
+	for next != nil {
+		res, err := registryGet(ctx, httpClient, next.String(), auth)
+		if err != nil {
+			return nil, err
+		}
+		var page registryCatalog
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, page.Repositories...)
+
+		link := nextLinkRegex.FindStringSubmatch(res.Header.Get("Link"))
+		if link == nil {
+			break
+		}
+		if next, err = next.Parse(link[1]); err != nil {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+// dockerHubRegistry is the registry v2 API host of docker hub.
+const dockerHubRegistry = "registry-1.docker.io"
+
+var (
+	challengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	nextLinkRegex       = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+)
+
+type registryTags struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type registryToken struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// registryURL returns the registry v2 API base url and repository path for repo,
+// defaulting to docker hub when repo does not specify any registry host.
+func registryURL(repo string) (string, string) {
+	domain, path := splitRepo(repo)
+	if domain == "" {
+		domain = dockerHubRegistry
+		if !strings.ContainsRune(path, '/') {
+			path = "library/" + path
+		}
+	}
+	return "https://" + domain, path
+}
+
+// listTags lists the tags of the path repository, using the registry v2 "tags/list" API,
+// following pagination through the "Link" header.
+func listTags(ctx context.Context, httpClient *http.Client, baseURL, path string, auth RegistryAuth) ([]string, error) {
+	next, err := url.Parse(fmt.Sprintf("%s/v2/%s/tags/list", baseURL, path))
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for next != nil {
+		res, err := registryGet(ctx, httpClient, next.String(), auth)
+		if err != nil {
+			return nil, err
+		}
+		var page registryTags
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		link := nextLinkRegex.FindStringSubmatch(res.Header.Get("Link"))
+		if link == nil {
+			break
+		}
+		if next, err = next.Parse(link[1]); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// registryGet issues a GET request to the registry,
+// answering the bearer token challenge of the registry, if any.
+func registryGet(ctx context.Context, httpClient *http.Client, u string, auth RegistryAuth) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth.setHeader(req)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized && auth.Token == "" {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return nil, fmt.Errorf("unexpected status from registry: %s", res.Status)
+		}
+		token, err := registryBearerToken(ctx, httpClient, challenge, auth)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		if res, err = httpClient.Do(req); err != nil {
+			return nil, err
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status from registry: %s", res.Status)
+	}
+	return res, nil
+}
+
+// registryBearerToken fetches a token from the realm of the bearer challenge,
+// either anonymously or using username and password, when set.
+func registryBearerToken(ctx context.Context, httpClient *http.Client, challenge string, auth RegistryAuth) (string, error) {
+	params := make(map[string]string)
+	for _, param := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[param[1]] = param[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry auth challenge: %s", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	auth.setHeader(req)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from registry auth: %s", res.Status)
+	}
+	var token registryToken
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// RegistryMirror rewrites Docker Hub images references to a registry mirror, like when Docker Hub is rate limiting pulls.
+// When Repos is set, only the repositories whose Docker Hub path starts with one of them,
+// like "falcosecurity/" or "library/ubuntu", are rewritten.
+type RegistryMirror struct {
+	Host  string
+	Repos []string
+}
+
+// Rewrite returns the reference of the name image, or repository, on the mirror,
+// or name itself when it is not a Docker Hub reference allowed by Repos.
+func (m RegistryMirror) Rewrite(name string) string {
+	if m.Host == "" {
+		return name
+	}
+	domain, path := splitRepo(name)
+	if domain != "" && domain != "docker.io" && domain != dockerHubRegistry {
+		return name
+	}
+	if !strings.ContainsRune(path, '/') {
+		path = "library/" + path
+	}
+	if len(m.Repos) > 0 {
+		allowed := false
+		for _, repo := range m.Repos {
+			if strings.HasPrefix(path, repo) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return name
+		}
+	}
+	return m.Host + "/" + path
+}
+
+// proxyClient returns an http client using the proxy url, if any, and with the timeout, if any.
+func proxyClient(proxy string, timeout time.Duration) (*http.Client, error) {
+	httpClient := &http.Client{Timeout: timeout}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	}
+	return httpClient, nil
+}
+
+// withProxy makes the docker client use the proxy of httpClient, if any, to reach the docker daemon.
+// It is a no-op when the daemon is reached through a unix socket or a named pipe,
+// and must follow client.FromEnv, that resets the proxy of the client transport.
+func withProxy(httpClient *http.Client) client.Opt {
+	return func(c *client.Client) error {
+		proxyTransport, ok := httpClient.Transport.(*http.Transport)
+		if !ok {
+			return nil
+		}
+		hostURL, err := client.ParseHostURL(c.DaemonHost())
+		if err != nil {
+			return err
+		}
+		if hostURL.Scheme == "unix" || hostURL.Scheme == "npipe" {
+			return nil
+		}
+		if transport, ok := c.HTTPClient().Transport.(*http.Transport); ok {
+			transport.Proxy = proxyTransport.Proxy
+		}
+		return nil
+	}
+}
+
+// registryRetryDelay is the delay before the second attempt of a registry call, doubled at each further attempt.
+var registryRetryDelay = time.Second
+
+// retry calls f up to maxAttempts times, with an exponential backoff between attempts,
+// until it succeeds or ctx is done. It returns the number of attempts made and the last error.
+func retry(ctx context.Context, maxAttempts int, f func() error) (int, error) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	delay := registryRetryDelay
+	attempt := 1
+	for ; ; attempt++ {
+		err := f()
+		if err == nil || attempt == maxAttempts {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,313 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/docker/docker/client"
+	"gotest.tools/assert"
+)
+
+func TestSplitRepo(t *testing.T) {
+	tests := map[string]struct {
+		domain string
+		path   string
+	}{
+		"falcosecurity/driverkit":           {"", "falcosecurity/driverkit"},
+		"docker.io/falcosecurity/driverkit": {"docker.io", "falcosecurity/driverkit"},
+		"myregistry.io/falco":               {"myregistry.io", "falco"},
+		"localhost:5000/falco/driverkit":    {"localhost:5000", "falco/driverkit"},
+		"localhost/driverkit":               {"localhost", "driverkit"},
+		"driverkit":                         {"", "driverkit"},
+	}
+
+	for repo, test := range tests {
+		domain, path := splitRepo(repo)
+		assert.Equal(t, test.domain, domain, repo)
+		assert.Equal(t, test.path, path, repo)
+	}
+}
+
+func TestRegistryURL(t *testing.T) {
+	tests := map[string]struct {
+		baseURL string
+		path    string
+	}{
+		"ghcr.io/falcosecurity/driverkit": {"https://ghcr.io", "falcosecurity/driverkit"},
+		"falcosecurity/driverkit":         {"https://registry-1.docker.io", "falcosecurity/driverkit"},
+		"driverkit":                       {"https://registry-1.docker.io", "library/driverkit"},
+	}
+
+	for repo, test := range tests {
+		baseURL, path := registryURL(repo)
+		assert.Equal(t, test.baseURL, baseURL, repo)
+		assert.Equal(t, test.path, path, repo)
+	}
+}
+
+func TestListTags(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.Equal(t, "registry.test", r.URL.Query().Get("service"))
+			assert.Equal(t, "repository:falco/driverkit:pull", r.URL.Query().Get("scope"))
+			json.NewEncoder(w).Encode(registryToken{Token: "anonymous"})
+		case "/v2/falco/driverkit/tags/list":
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry.test",scope="repository:falco/driverkit:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/falco/driverkit/tags/list?n=2&last=b>; rel="next"`)
+				json.NewEncoder(w).Encode(registryTags{Name: "falco/driverkit", Tags: []string{"a", "b"}})
+				return
+			}
+			json.NewEncoder(w).Encode(registryTags{Name: "falco/driverkit", Tags: []string{"c"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tags, err := listTags(context.Background(), http.DefaultClient, srv.URL, "falco/driverkit", RegistryAuth{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"a", "b", "c"}, tags)
+
+	_, err = listTags(context.Background(), http.DefaultClient, srv.URL, "falco/missing", RegistryAuth{})
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestListCatalog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/_catalog" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/_catalog?n=2&last=falco/driverkit-builder-b>; rel="next"`)
+			json.NewEncoder(w).Encode(registryCatalog{Repositories: []string{"falco/driverkit-builder-a", "falco/driverkit-builder-b"}})
+			return
+		}
+		json.NewEncoder(w).Encode(registryCatalog{Repositories: []string{"other/image"}})
+	}))
+	defer srv.Close()
+
+	repositories, err := listCatalog(context.Background(), http.DefaultClient, srv.URL, RegistryAuth{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"falco/driverkit-builder-a", "falco/driverkit-builder-b", "other/image"}, repositories)
+
+	_, err = listCatalog(context.Background(), http.DefaultClient, srv.URL+"/missing", RegistryAuth{})
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestRetry(t *testing.T) {
+	defer func(d time.Duration) { registryRetryDelay = d }(registryRetryDelay)
+	registryRetryDelay = time.Millisecond
+
+	errSearch := errors.New("503 service unavailable")
+	tests := map[string]struct {
+		maxAttempts int
+		failures    int
+		attempts    int
+		err         error
+	}{
+		"success":                {maxAttempts: 3, failures: 0, attempts: 1},
+		"success after failures": {maxAttempts: 3, failures: 2, attempts: 3},
+		"attempts exhausted":     {maxAttempts: 3, failures: 5, attempts: 3, err: errSearch},
+		"at least one attempt":   {maxAttempts: 0, failures: 5, attempts: 1, err: errSearch},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			attempts, err := retry(context.Background(), tt.maxAttempts, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errSearch
+				}
+				return nil
+			})
+			assert.Equal(t, tt.attempts, attempts)
+			assert.Equal(t, tt.attempts, calls)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, err := retry(ctx, 3, func() error { return errSearch })
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, errSearch, err)
+}
+
+func TestRegistryMirrorRewrite(t *testing.T) {
+	mirror := RegistryMirror{Host: "mirror.example.com:5000"}
+	allowlist := RegistryMirror{Host: "mirror.example.com:5000", Repos: []string{"falcosecurity/", "library/ubuntu"}}
+	tests := map[string]struct {
+		mirror   RegistryMirror
+		name     string
+		expected string
+	}{
+		"no mirror":         {RegistryMirror{}, "falcosecurity/driverkit", "falcosecurity/driverkit"},
+		"docker hub":        {mirror, "falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0:latest", "mirror.example.com:5000/falcosecurity/driverkit-builder-any-x86_64_gcc8.0.0:latest"},
+		"docker hub domain": {mirror, "docker.io/falcosecurity/driverkit", "mirror.example.com:5000/falcosecurity/driverkit"},
+		"official image":    {mirror, "ubuntu:20.04", "mirror.example.com:5000/library/ubuntu:20.04"},
+		"other registry":    {mirror, "ghcr.io/falcosecurity/driverkit:builder", "ghcr.io/falcosecurity/driverkit:builder"},
+		"allowed":           {allowlist, "docker.io/falcosecurity/driverkit", "mirror.example.com:5000/falcosecurity/driverkit"},
+		"allowed official":  {allowlist, "ubuntu", "mirror.example.com:5000/library/ubuntu"},
+		"not allowed":       {allowlist, "multiarch/qemu-user-static", "multiarch/qemu-user-static"},
+		"already rewritten": {mirror, "mirror.example.com:5000/falcosecurity/driverkit", "mirror.example.com:5000/falcosecurity/driverkit"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.mirror.Rewrite(tt.name))
+		})
+	}
+}
+
+func TestWithProxy(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	httpClient, err := proxyClient(proxyURL.String(), 0)
+	assert.NilError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "http://docker.example.com:2375/images/search", nil)
+
+	tests := map[string]*url.URL{
+		"tcp://docker.example.com:2375": proxyURL,
+		"unix:///var/run/docker.sock":   nil,
+	}
+
+	for host, expected := range tests {
+		cli, err := client.NewClientWithOpts(client.WithHost(host), withProxy(httpClient))
+		assert.NilError(t, err, host)
+		transport, ok := cli.HTTPClient().Transport.(*http.Transport)
+		assert.Assert(t, ok, host)
+		var proxy *url.URL
+		if transport.Proxy != nil {
+			proxy, err = transport.Proxy(req)
+			assert.NilError(t, err, host)
+		}
+		assert.DeepEqual(t, expected, proxy)
+	}
+}
+
+func TestListCatalogThroughProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a plain http request sent to a proxy has the absolute url as request target
+		proxied = append(proxied, r.URL.String())
+		_ = json.NewEncoder(w).Encode(map[string][]string{"repositories": {"falco/driverkit-builder-centos"}})
+	}))
+	defer proxy.Close()
+
+	httpClient, err := proxyClient(proxy.URL, time.Second)
+	assert.NilError(t, err)
+	repos, err := listCatalog(context.Background(), httpClient, "http://myregistry.example.com", RegistryAuth{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"falco/driverkit-builder-centos"}, repos)
+	assert.DeepEqual(t, []string{"http://myregistry.example.com/v2/_catalog"}, proxied)
+}
+
+func TestParseRegistryAuth(t *testing.T) {
+	tests := map[string]struct {
+		repo        string
+		auth        RegistryAuth
+		expectedErr string
+	}{
+		"registry.gitlab.com/myorg=deployer:se:cret": {repo: "registry.gitlab.com/myorg", auth: RegistryAuth{Username: "deployer", Password: "se:cret"}},
+		"oci://ghcr.io/myorg/driverkit=dG9rZW4=":     {repo: "ghcr.io/myorg/driverkit", auth: RegistryAuth{Token: "dG9rZW4="}},
+		"falcosecurity=user:":                        {repo: "falcosecurity", auth: RegistryAuth{Username: "user"}},
+		"registry.gitlab.com/myorg=:secret":          {expectedErr: "has an empty user"},
+		"registry.gitlab.com/myorg=":                 {expectedErr: "must be in the <repo>=<user>:<password> or <repo>=<token> form"},
+		"=deployer:secret":                           {expectedErr: "must be in the <repo>=<user>:<password> or <repo>=<token> form"},
+	}
+
+	for s, test := range tests {
+		t.Run(s, func(t *testing.T) {
+			repo, auth, err := ParseRegistryAuth(s)
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				assert.Assert(t, !strings.Contains(err.Error(), "secret"))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.repo, repo)
+			assert.Equal(t, test.auth, auth)
+		})
+	}
+}
+
+func TestRegistryAuthsLookup(t *testing.T) {
+	auths := RegistryAuths{
+		"registry.gitlab.com":                 {Username: "gitlab"},
+		"registry.gitlab.com/myorg/overrides": {Username: "overrides"},
+		"docker.io/falcosecurity":             {Token: "hub"},
+		"localhost:5000":                      {Username: "local"},
+	}
+
+	tests := map[string]string{
+		"registry.gitlab.com/myorg/overrides":                                 "overrides",
+		"registry.gitlab.com/myorg/overrides/driverkit-builder-centos:latest": "overrides",
+		"registry.gitlab.com/myorg/driverkit":                                 "gitlab",
+		"oci://registry.gitlab.com/myorg/driverkit":                           "gitlab",
+		"falcosecurity/driverkit":                                             "hub",
+		"docker.io/falcosecurity/driverkit-builder-centos:latest":             "hub",
+		"localhost:5000/driverkit":                                            "local",
+		"myorg/driverkit":                                                     "",
+		"ghcr.io/myorg/driverkit":                                             "",
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth, ok := auths.lookup(name)
+			assert.Equal(t, expected != "", ok)
+			assert.Equal(t, expected, auth.Username+auth.Token)
+		})
+	}
+}
+
+func TestListersRegistryAuths(t *testing.T) {
+	build := &Build{
+		TargetType:    "centos",
+		Architecture:  "amd64",
+		RegistryAuth:  RegistryAuth{Username: "default", Password: "default"},
+		RegistryAuths: RegistryAuths{"registry.gitlab.com/myorg": {Username: "deployer", Password: "secret"}},
+	}
+	assert.Equal(t, RegistryAuth{Username: "deployer", Password: "secret"}, NewTagsImagesLister("oci://registry.gitlab.com/myorg/driverkit", build).auth)
+	assert.Equal(t, RegistryAuth{Username: "deployer", Password: "secret"}, NewRepoImagesLister("registry.gitlab.com/myorg/driverkit", build).auth)
+	assert.Equal(t, build.RegistryAuth, NewRepoImagesLister("falcosecurity/driverkit", build).auth)
+}
+
+func TestDockerUnreachable(t *testing.T) {
+	host := "unix://" + filepath.Join(t.TempDir(), "docker.sock")
+	t.Setenv("DOCKER_HOST", host)
+
+	// Cached search results would not need the daemon
+	b := &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "8.0.0", ImagesCache: ImagesCache{Bypass: true}}
+	_, err := NewRepoImagesLister("myorg/driverkit", b).LoadImages(context.Background())
+	var unreachable *DockerUnreachableError
+	assert.Assert(t, errors.As(err, &unreachable))
+	assert.Error(t, err, "Docker daemon not reachable at "+host+"; is it running?")
+
+	// Alone, the repo fails the build
+	b.ImagesListers = []ImagesLister{NewRepoImagesLister("myorg/driverkit", b)}
+	assert.Assert(t, errors.As(b.LoadImages(context.Background()), &unreachable))
+
+	// Otherwise, the other repos provide the images
+	b = &Build{TargetType: "centos", Architecture: "amd64", GCCVersion: "8.0.0", ImagesCache: ImagesCache{Bypass: true}}
+	b.ImagesListers = []ImagesLister{
+		NewRepoImagesLister("myorg/driverkit", b),
+		testImagesLister{{Target: "centos", GCCVersion: semver.MustParse("8.0.0"), Name: "myorg/driverkit-builder-centos-gcc8"}},
+	}
+	assert.NilError(t, b.LoadImages(context.Background()))
+	assert.Equal(t, 1, len(b.Images))
+}
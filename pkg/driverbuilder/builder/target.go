@@ -1,5 +1,11 @@
 package builder
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // BuilderByTarget maps targets to their builder.
 var BuilderByTarget = Targets{}
 
@@ -21,3 +27,63 @@ func (t Targets) Targets() []string {
 	}
 	return res
 }
+
+// TargetAliases maps alternative names of targets, like "rhel", to their canonical Type, like "redhat".
+type TargetAliases map[string]Type
+
+// DefaultTargetAliases are the common alternative names of the supported targets,
+// always resolved in addition to any TargetAliases.
+var DefaultTargetAliases = TargetAliases{
+	"alma":        "almalinux",
+	"amzn":        "amazonlinux",
+	"amzn2":       "amazonlinux2",
+	"amzn2022":    "amazonlinux2022",
+	"archlinux":   "arch",
+	"oraclelinux": "ol",
+	"rhel":        "redhat",
+	"rockylinux":  "rocky",
+}
+
+// Resolve returns the canonical target named name, that is name itself when it is not an alias.
+func (a TargetAliases) Resolve(name string) Type {
+	if target, ok := a[name]; ok {
+		return target
+	}
+	if target, ok := DefaultTargetAliases[name]; ok {
+		return target
+	}
+	return Type(name)
+}
+
+// aliasesOf returns the aliases of target, in lexical order.
+func (a TargetAliases) aliasesOf(target Type) []string {
+	seen := make(map[string]bool)
+	var aliases []string
+	for _, m := range []TargetAliases{DefaultTargetAliases, a} {
+		for alias := range m {
+			if !seen[alias] && a.Resolve(alias) == target {
+				seen[alias] = true
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// ParseTargetAlias parses an "<alias>=<target>" string, like "rhel=redhat", where target must be a supported one,
+// while alias must not be, so that aliases never shadow targets.
+func ParseTargetAlias(s string) (string, Type, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("target alias must be in the <alias>=<target> form: %s", s)
+	}
+	if _, ok := BuilderByTarget[Type(s[:i])]; ok {
+		return "", "", fmt.Errorf("target alias must not be a supported target: %s", s)
+	}
+	target := Type(s[i+1:])
+	if _, ok := BuilderByTarget[target]; !ok {
+		return "", "", fmt.Errorf("target alias must refer to a supported target: %s", s)
+	}
+	return s[:i], target, nil
+}
@@ -15,12 +15,14 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -o kernel-devel.rpm -SL {{ .KernelDownloadURL }}
+{{ download .KernelDownloadURL "kernel-devel.rpm" }}
 rpm2cpio kernel-devel.rpm | cpio --extract --make-directories
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
 
+{{ kernelconfig "/tmp/kernel" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
@@ -16,7 +16,7 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{ range $url := .KernelDownloadURLs }}
-curl --silent -o kernel.rpm -SL {{ $url }}
+{{ download $url "kernel.rpm" }}
 rpm2cpio kernel.rpm | cpio --extract --make-directories
 rm -rf kernel.rpm
 {{ end }}
@@ -24,6 +24,8 @@ rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
 
+{{ kernelconfig "/tmp/kernel" }}
+
 {{ if .BuildModule }}
 # Build the kernel module
 cd {{ .DriverBuildDir }}
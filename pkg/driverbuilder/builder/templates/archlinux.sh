@@ -15,12 +15,14 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -o kernel-devel.pkg.tar.xz -SL {{ .KernelDownloadURL }}
+{{ download .KernelDownloadURL "kernel-devel.pkg.tar.xz" }}
 tar -xf kernel-devel.pkg.tar.xz
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/lib/modules/*/build/* /tmp/kernel
 
+{{ kernelconfig "/tmp/kernel" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
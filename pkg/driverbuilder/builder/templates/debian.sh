@@ -16,7 +16,7 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{ range $url := .KernelDownloadURLS }}
-curl --silent -o kernel.deb -SL {{ $url }}
+{{ download $url "kernel.deb" }}
 ar x kernel.deb
 tar -xvf data.tar.xz
 {{ end }}
@@ -29,6 +29,8 @@ cp -r lib/* /lib
 cd /usr/src
 sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
 
+{{ kernelconfig "$sourcedir" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
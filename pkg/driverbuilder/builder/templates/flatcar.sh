@@ -15,7 +15,8 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 # Fetch the kernel
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
+{{ download .KernelDownloadURL "/tmp/kernel.tar.xz" }}
+tar -Jxf /tmp/kernel.tar.xz -C /tmp/kernel-download
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv /tmp/kernel-download/*/* /tmp/kernel
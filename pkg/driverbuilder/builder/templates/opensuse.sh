@@ -16,7 +16,7 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{range $url := .KernelDownloadURLs}}
-curl --silent -o kernel-devel.rpm -SL {{ $url }}
+{{ download $url "kernel-devel.rpm" }}
 # cpio will warn *extremely verbose* when trying to duplicate over the same directory - redirect stderr to null
 rpm2cpio kernel-devel.rpm | cpio --quiet --extract --make-directories 2> /dev/null
 {{end}}
@@ -24,6 +24,8 @@ cd /tmp/kernel-download/usr/src
 ls -alh /tmp/kernel-download/usr/src
 sourcedir="$(find . -type d -name "linux-*-obj" | head -n 1 | xargs readlink -f)/*/default"
 
+{{ kernelconfig "$sourcedir" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
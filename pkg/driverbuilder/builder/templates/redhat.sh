@@ -23,6 +23,8 @@ rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv usr/src/kernels/*/* /tmp/kernel
 
+{{ kernelconfig "/tmp/kernel" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
@@ -16,7 +16,7 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 mkdir /tmp/kernel-download
 cd /tmp/kernel-download
 {{range $url := .KernelDownloadURLS}}
-curl --silent -o kernel.deb -SL {{ $url }}
+{{ download $url "kernel.deb" }}
 ar x kernel.deb
 tar -xf data.tar.*
 {{end}}
@@ -25,6 +25,8 @@ cd /tmp/kernel-download/usr/src/
 ls -altr
 sourcedir=$(find . -type d -name "{{ .KernelHeadersPattern }}" | head -n 1 | xargs readlink -f)
 
+{{ kernelconfig "$sourcedir" }}
+
 {{ if .BuildModule }}
 # Build the module
 cd {{ .DriverBuildDir }}
@@ -15,7 +15,8 @@ bash /driverkit/fill-driver-config.sh {{ .DriverBuildDir }}
 # Fetch the kernel
 cd /tmp
 mkdir /tmp/kernel-download
-curl --silent -SL {{ .KernelDownloadURL }} | tar -Jxf - -C /tmp/kernel-download
+{{ download .KernelDownloadURL "kernel.tar.xz" }}
+tar -Jxf kernel.tar.xz -C /tmp/kernel-download
 rm -Rf /tmp/kernel
 mkdir -p /tmp/kernel
 mv /tmp/kernel-download/*/* /tmp/kernel
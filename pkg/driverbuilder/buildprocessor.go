@@ -1,10 +1,88 @@
 package driverbuilder
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	logger "github.com/sirupsen/logrus"
 )
 
 type BuildProcessor interface {
 	Start(b *builder.Build) error
 	String() string
 }
+
+// BuildError is returned by build processors when the driver build itself fails, like on compilation errors,
+// as opposed to failures of the processor, like when the builder image cannot be pulled.
+type BuildError struct {
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// BuildTimeoutError is returned by build processors when the build step exceeds the build timeout,
+// like a stuck compilation, as opposed to the processor timeout governing the whole run.
+type BuildTimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *BuildTimeoutError) Error() string {
+	return fmt.Sprintf("build timed out after %s: %v", e.Timeout, e.Err)
+}
+
+func (e *BuildTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// OOMKilledError is returned by build processors when the build was killed for exceeding its memory limit,
+// as opposed to a failure of the build itself, like on compilation errors.
+type OOMKilledError struct {
+	MemoryLimit string // empty when unknown
+}
+
+func (e *OOMKilledError) Error() string {
+	if e.MemoryLimit == "" {
+		return "build killed for running out of memory"
+	}
+	return fmt.Sprintf("build killed for running out of memory, exceeding its memory limit of %s", e.MemoryLimit)
+}
+
+// withBuildTimeout returns the context of the build step of b, canceled after its build timeout, if any.
+func withBuildTimeout(ctx context.Context, b *builder.Build) (context.Context, context.CancelFunc) {
+	if b.BuildTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, b.BuildTimeout)
+}
+
+// buildTimedOut returns whether the build step context buildCtx, derived from ctx by withBuildTimeout,
+// expired for the build timeout of b, and not because ctx is done.
+func buildTimedOut(ctx context.Context, buildCtx context.Context, b *builder.Build) bool {
+	return b.BuildTimeout > 0 && ctx.Err() == nil && errors.Is(buildCtx.Err(), context.DeadlineExceeded)
+}
+
+// logDriverAvailable logs that a driver of the build is available, along with its output file,
+// unless the build has its own driver writer.
+func logDriverAvailable(b *builder.Build, msg string, filePath string) {
+	entry := buildLogger(b)
+	if b.DriverWriter == nil {
+		entry = entry.WithField("path", b.OutputFilePath(filePath))
+	}
+	entry.Info(msg)
+}
+
+// buildLogger returns a logger whose entries carry the target and kernel release of the build,
+// so that the logs of concurrent builds can be told apart.
+func buildLogger(b *builder.Build) *logger.Entry {
+	return logger.WithField("target", b.TargetType).WithField("kernelrelease", b.KernelRelease)
+}
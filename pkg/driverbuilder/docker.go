@@ -6,20 +6,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"github.com/docker/docker/pkg/archive"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/falcosecurity/driverkit/pkg/signals"
 	logger "github.com/sirupsen/logrus"
@@ -29,25 +32,155 @@ import (
 // DockerBuildProcessorName is a constant containing the docker name.
 const DockerBuildProcessorName = "docker"
 
+// PullPolicy tells the docker processor when to pull the builder image.
+type PullPolicy string
+
+const (
+	// PullAlways always pulls the builder image, to get the latest image its tag points to.
+	PullAlways PullPolicy = "Always"
+	// PullIfNotPresent only pulls the builder image when not present locally for the build architecture; it is the default,
+	// also used when the pull policy is empty.
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	// PullNever never pulls the builder image, failing when not present locally,
+	// like to verify that the required images are pre-loaded in air-gapped environments.
+	PullNever PullPolicy = "Never"
+)
+
+// DefaultContainerPrefix prefixes the names of the containers created by the docker processor, unless configured.
+const DefaultContainerPrefix = "driverkit"
+
+// Labels set by the docker processor on the containers it creates, so that they can be told apart,
+// like with `docker ps --filter label=driverkit.run=<id>`.
+const (
+	// RunLabel identifies the driverkit run that created the container.
+	RunLabel = "driverkit.run"
+	// TargetLabel is the target of the build of the container.
+	TargetLabel = "driverkit.target"
+	// KernelReleaseLabel is the kernel release of the build of the container.
+	KernelReleaseLabel = "driverkit.kernelrelease"
+)
+
+// ParseContainerLabel parses a container label in the <key>=<value> form, where value can be empty.
+func ParseContainerLabel(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("container label %q must be in the <key>=<value> form", s)
+	}
+	return key, value, nil
+}
+
 type DockerBuildProcessor struct {
-	clean   bool
-	timeout int
-	proxy   string
+	clean           bool
+	timeout         time.Duration
+	proxy           string
+	pullPolicy      PullPolicy
+	containerPrefix string
+	labels          map[string]string
 }
 
-// NewDockerBuildProcessor ...
-func NewDockerBuildProcessor(timeout int, proxy string) *DockerBuildProcessor {
+// NewDockerBuildProcessor creates a DockerBuildProcessor whose containers are named after containerPrefix,
+// or DefaultContainerPrefix when empty, and labeled with labels, in addition to the target and kernel release of their build.
+func NewDockerBuildProcessor(timeout time.Duration, proxy string, pullPolicy PullPolicy, containerPrefix string, labels map[string]string) *DockerBuildProcessor {
+	if containerPrefix == "" {
+		containerPrefix = DefaultContainerPrefix
+	}
 	return &DockerBuildProcessor{
-		timeout: timeout,
-		proxy:   proxy,
+		timeout:         timeout,
+		proxy:           proxy,
+		pullPolicy:      pullPolicy,
+		containerPrefix: containerPrefix,
+		labels:          labels,
 	}
 }
 
+// containerLabels returns the labels of the containers created for the build:
+// its target and kernel release, along with the processor labels, possibly overriding them.
+func (bp *DockerBuildProcessor) containerLabels(b *builder.Build) map[string]string {
+	labels := map[string]string{
+		TargetLabel:        b.TargetType.String(),
+		KernelReleaseLabel: b.KernelRelease,
+	}
+	for key, value := range bp.labels {
+		labels[key] = value
+	}
+	return labels
+}
+
 func (bp *DockerBuildProcessor) String() string {
 	return DockerBuildProcessorName
 }
 
-func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client) {
+// needsPull returns whether the builder image must be pulled, according to the pull policy:
+// unless always pulled, it is when not present locally for the build architecture.
+func (bp *DockerBuildProcessor) needsPull(ctx context.Context, cli *client.Client, image string, arch string) (bool, error) {
+	if bp.pullPolicy == PullAlways {
+		return true, nil
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	present := err == nil && inspect.Architecture == arch
+	if !present && bp.pullPolicy == PullNever {
+		if err != nil && !client.IsErrNotFound(err) {
+			return false, fmt.Errorf("error inspecting builder image %s: %w", image, err)
+		}
+		return false, fmt.Errorf("builder image %s is not present locally for architecture %s, and pull policy is %s", image, arch, PullNever)
+	}
+	return !present, nil
+}
+
+// Pull pulls the image for arch according to the pull policy, like before a build,
+// returning whether it was pulled and the size of the layers downloaded to pull it.
+func (bp *DockerBuildProcessor) Pull(ctx context.Context, image string, arch string) (bool, int64, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return false, 0, err
+	}
+	defer cli.Close()
+	pull, err := bp.needsPull(ctx, cli, image, arch)
+	if err != nil || !pull {
+		return false, 0, err
+	}
+	size, err := pullImage(ctx, cli, image, arch)
+	return err == nil, size, err
+}
+
+// pullImage pulls the image for arch, returning the size of the layers downloaded to pull it,
+// that is zero when all of them were already present locally.
+func pullImage(ctx context.Context, cli *client.Client, image string, arch string) (int64, error) {
+	pullRes, err := cli.ImagePull(ctx, image, types.ImagePullOptions{Platform: arch})
+	if err != nil {
+		return 0, err
+	}
+	defer pullRes.Close()
+	return downloadedSize(pullRes)
+}
+
+// downloadedSize consumes the progress messages of an image pull,
+// returning the total size of the layers it downloaded.
+func downloadedSize(r io.Reader) (int64, error) {
+	sizes := make(map[string]int64)
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if msg.Error != nil {
+			return 0, msg.Error
+		}
+		if msg.Status == "Downloading" && msg.Progress != nil && msg.Progress.Total > 0 {
+			sizes[msg.ID] = msg.Progress.Total
+		}
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total, nil
+}
+
+func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Client, name string, labels map[string]string) {
 	var err error
 	if b.Architecture == runtime.GOARCH {
 		// Nothing to do
@@ -59,9 +192,10 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 	}
 
 	logger.Debug("using qemu for cross build")
-	if _, _, err = cli.ImageInspectWithRaw(ctx, "multiarch/qemu-user-static"); client.IsErrNotFound(err) {
-		logger.WithField("image", "multiarch/qemu-user-static").Debug("pulling qemu static image")
-		pullRes, err := cli.ImagePull(ctx, "multiarch/qemu-user-static", types.ImagePullOptions{})
+	qemuImageName := b.RegistryMirror.Rewrite("multiarch/qemu-user-static")
+	if _, _, err = cli.ImageInspectWithRaw(ctx, qemuImageName); client.IsErrNotFound(err) {
+		logger.WithField("image", qemuImageName).Debug("pulling qemu static image")
+		pullRes, err := cli.ImagePull(ctx, qemuImageName, types.ImagePullOptions{})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -73,13 +207,14 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 	}
 	qemuImage, err := cli.ContainerCreate(ctx,
 		&container.Config{
-			Cmd:   []string{"--reset", "-p", "yes"},
-			Image: "multiarch/qemu-user-static",
+			Cmd:    []string{"--reset", "-p", "yes"},
+			Image:  qemuImageName,
+			Labels: labels,
 		},
 		&container.HostConfig{
 			AutoRemove: true,
 			Privileged: true,
-		}, nil, nil, "")
+		}, nil, nil, name)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -105,6 +240,11 @@ func mustCheckArchUseQemu(ctx context.Context, b *builder.Build, cli *client.Cli
 
 // Start the docker processor
 func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
+	return bp.StartContext(context.Background(), b)
+}
+
+// StartContext is like Start, stopping the build when ctx is done.
+func (bp *DockerBuildProcessor) StartContext(ctx context.Context, b *builder.Build) error {
 	logger.Debug("doing a new docker build")
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
@@ -120,8 +260,13 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 	}
 	c := b.ToConfig()
 
+	// The build timeout also governs the discovery of builder images
+	ctx = signals.WithStandardSignals(ctx)
+	ctx, cancel := context.WithTimeout(ctx, bp.timeout)
+	defer cancel()
+
 	// Generate the build script from the builder
-	driverkitScript, err := builder.Script(v, c, kr)
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
 	if err != nil {
 		return err
 	}
@@ -149,30 +294,23 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		return err
 	}
 
-	builderImage := b.GetBuilderImage()
+	builderImage := b.RegistryMirror.Rewrite(b.GetBuilderImage())
 
 	// Create the container
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
-
-	mustCheckArchUseQemu(ctx, b, cli)
-
-	var inspect types.ImageInspect
-	if inspect, _, err = cli.ImageInspectWithRaw(ctx, builderImage); client.IsErrNotFound(err) ||
-		inspect.Architecture != b.Architecture {
+	labels := bp.containerLabels(b)
+	mustCheckArchUseQemu(ctx, b, cli, fmt.Sprintf("%s-qemu-%s", bp.containerPrefix, string(uuid.NewUUID())), labels)
 
+	pull, err := bp.needsPull(ctx, cli, builderImage, b.Architecture)
+	if err != nil {
+		return err
+	}
+	if pull {
 		logger.
 			WithField("image", builderImage).
 			WithField("arch", b.Architecture).
 			Debug("pulling builder image")
 
-		pullRes, err := cli.ImagePull(ctx, builderImage, types.ImagePullOptions{Platform: b.Architecture})
-		if err != nil {
-			return err
-		}
-		defer pullRes.Close()
-		_, err = io.Copy(ioutil.Discard, pullRes)
-		if err != nil {
+		if _, err = pullImage(ctx, cli, builderImage, b.Architecture); err != nil {
 			return err
 		}
 	}
@@ -182,16 +320,17 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		Debug("starting container")
 
 	containerCfg := &container.Config{
-		Tty:   true,
-		Cmd:   []string{"/bin/sleep", strconv.Itoa(bp.timeout)},
-		Image: builderImage,
+		Tty:    true,
+		Cmd:    []string{"/bin/sleep", strconv.Itoa(int(math.Ceil(bp.timeout.Seconds())))},
+		Image:  builderImage,
+		Labels: labels,
 	}
 
 	hostCfg := &container.HostConfig{
 		AutoRemove: true,
 	}
 	uid := uuid.NewUUID()
-	name := fmt.Sprintf("driverkit-%s", string(uid))
+	name := fmt.Sprintf("%s-%s", bp.containerPrefix, string(uid))
 
 	cdata, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, nil, &v1.Platform{Architecture: b.Architecture, OS: "linux"}, name)
 	if err != nil {
@@ -242,7 +381,11 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		)
 	}
 
-	edata, err := cli.ContainerExecCreate(ctx, cdata.ID, types.ExecConfig{
+	// The build timeout only governs the build script run
+	buildCtx, cancelBuild := withBuildTimeout(ctx, b)
+	defer cancelBuild()
+
+	edata, err := cli.ContainerExecCreate(buildCtx, cdata.ID, types.ExecConfig{
 		Privileged:   false,
 		Tty:          false,
 		AttachStdin:  false,
@@ -250,55 +393,61 @@ func (bp *DockerBuildProcessor) Start(b *builder.Build) error {
 		AttachStdout: true,
 		Detach:       true,
 		Env:          envs,
-		Cmd: []string{
-			"/bin/bash",
-			"/driverkit/driverkit.sh",
-		},
+		Cmd:          b.GetBuildCommand(),
 	})
 
 	if err != nil {
 		return err
 	}
 
-	hr, err := cli.ContainerExecAttach(ctx, edata.ID, types.ExecStartCheck{})
+	hr, err := cli.ContainerExecAttach(buildCtx, edata.ID, types.ExecStartCheck{})
 	if err != nil {
 		return err
 	}
 	defer hr.Close()
+	// Stop following the build when it times out; the container is then cleaned up
+	go func() {
+		<-buildCtx.Done()
+		hr.Close()
+	}()
 
-	forwardLogs(hr.Reader)
+	forwardLogs(hr.Reader, buildLogger(b))
+	if buildTimedOut(ctx, buildCtx, b) {
+		return &BuildTimeoutError{Timeout: b.BuildTimeout, Err: buildCtx.Err()}
+	}
 
-	if len(b.ModuleFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.ModuleFilePath); err != nil {
-			return err
+	if b.BuildsModule() {
+		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ModuleFullPath, b.Writer().WriteModule); err != nil {
+			// The build script does not report its exit status: a missing kernel module means that the build failed
+			return &BuildError{Err: err}
 		}
-		logger.WithField("path", b.ModuleFilePath).Info("kernel module available")
+		logDriverAvailable(b, "kernel module available", b.ModuleFilePath)
 	}
 
-	if len(b.ProbeFilePath) > 0 {
-		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.ProbeFilePath); err != nil {
-			return err
+	if b.BuildsProbe() {
+		if err := copyFromContainer(ctx, cli, cdata.ID, builder.ProbeFullPath, b.Writer().WriteProbe); err != nil {
+			return &BuildError{Err: err}
 		}
-		logger.WithField("path", b.ProbeFilePath).Info("eBPF probe available")
+		logDriverAvailable(b, "eBPF probe available", b.ProbeFilePath)
 	}
 
 	return nil
 }
 
-func copyFromContainer(ctx context.Context, cli *client.Client, ID, from, to string) error {
-	content, stat, err := cli.CopyFromContainer(ctx, ID, from)
+// copyFromContainer gives the content of the from file of the container to write.
+func copyFromContainer(ctx context.Context, cli *client.Client, ID, from string, write func(r io.Reader) error) error {
+	content, _, err := cli.CopyFromContainer(ctx, ID, from)
 	if err != nil {
 		return err
 	}
 	defer content.Close()
 
-	srcInfo := archive.CopyInfo{
-		Path:   from,
-		Exists: true,
-		IsDir:  stat.Mode.IsDir(),
+	// The file is copied as a tar archive
+	tr := tar.NewReader(content)
+	if _, err := tr.Next(); err != nil {
+		return fmt.Errorf("error reading %s from the container: %w", from, err)
 	}
-	preArchive := content
-	return archive.CopyTo(preArchive, srcInfo, to)
+	return write(tr)
 }
 
 func (bp *DockerBuildProcessor) cleanup(cli *client.Client, ID string) {
@@ -336,19 +485,19 @@ func tarWriterFiles(buf io.Writer, files []dockerCopyFile) error {
 	return nil
 }
 
-func forwardLogs(logPipe io.Reader) {
+func forwardLogs(logPipe io.Reader, entry *logger.Entry) {
 	lineReader := bufio.NewReader(logPipe)
 	for {
 		line, err := lineReader.ReadBytes('\n')
 		if len(line) > 0 {
-			logger.Debugf("%s", line)
+			entry.Debugf("%s", line)
 		}
 		if err == io.EOF {
-			logger.WithError(err).Debug("log pipe close")
+			entry.WithError(err).Debug("log pipe close")
 			return
 		}
 		if err != nil {
-			logger.WithError(err).Error("log pipe error")
+			entry.WithError(err).Error("log pipe error")
 		}
 	}
 }
@@ -0,0 +1,196 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"gotest.tools/assert"
+)
+
+// fakeDockerDaemon serves the docker API endpoints used to pull builder images.
+type fakeDockerDaemon struct {
+	images     map[string]string // architecture of the images present locally, by name
+	inspectErr bool              // fail every image inspection
+	pullMsgs   []jsonmessage.JSONMessage
+	pulls      []string // pulled images, in the <image>@<platform> form
+}
+
+func (d *fakeDockerDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, path, _ := strings.Cut(r.URL.Path, "/images/")
+	switch {
+	case strings.HasSuffix(path, "/json"):
+		if d.inspectErr {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": "inspection failed"})
+			return
+		}
+		name := strings.TrimSuffix(path, "/json")
+		arch, ok := d.images[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such image: " + name})
+			return
+		}
+		json.NewEncoder(w).Encode(types.ImageInspect{ID: "sha256:" + strings.Repeat("ab", 32), Architecture: arch})
+	case path == "create":
+		query := r.URL.Query()
+		d.pulls = append(d.pulls, query.Get("fromImage")+":"+query.Get("tag")+"@"+query.Get("platform"))
+		enc := json.NewEncoder(w)
+		for _, msg := range d.pullMsgs {
+			enc.Encode(msg)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newFakeDockerClient returns a docker client of a fake docker daemon, closed at the end of the test.
+func newFakeDockerClient(t *testing.T, daemon http.Handler) *client.Client {
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.41"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func TestNeedsPull(t *testing.T) {
+	const image = "myorg/driverkit-builder:latest"
+	notPresentErr := "builder image myorg/driverkit-builder:latest is not present locally for architecture amd64, and pull policy is Never"
+
+	tests := map[string]struct {
+		policy      PullPolicy
+		arch        string // of the image present locally, if any
+		inspectErr  bool
+		expected    bool
+		expectedErr string
+	}{
+		"always, present":                    {policy: PullAlways, arch: "amd64", expected: true},
+		"always, inspection failing":         {policy: PullAlways, inspectErr: true, expected: true},
+		"if not present, present":            {policy: PullIfNotPresent, arch: "amd64"},
+		"if not present, other arch":         {policy: PullIfNotPresent, arch: "arm64", expected: true},
+		"if not present, missing":            {policy: PullIfNotPresent, expected: true},
+		"default, present":                   {arch: "amd64"},
+		"default, missing":                   {expected: true},
+		"never, present":                     {policy: PullNever, arch: "amd64"},
+		"never, missing":                     {policy: PullNever, expectedErr: notPresentErr},
+		"never, other arch":                  {policy: PullNever, arch: "arm64", expectedErr: notPresentErr},
+		"never, inspection failing":          {policy: PullNever, inspectErr: true, expectedErr: "error inspecting builder image myorg/driverkit-builder:latest"},
+		"if not present, inspection failing": {policy: PullIfNotPresent, inspectErr: true, expected: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			daemon := &fakeDockerDaemon{images: map[string]string{}, inspectErr: test.inspectErr}
+			if test.arch != "" {
+				daemon.images[image] = test.arch
+			}
+			bp := NewDockerBuildProcessor(0, "", test.policy, "", nil)
+			pull, err := bp.needsPull(context.Background(), newFakeDockerClient(t, daemon), image, "amd64")
+			if test.expectedErr != "" {
+				assert.ErrorContains(t, err, test.expectedErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expected, pull)
+		})
+	}
+}
+
+func TestPullNever(t *testing.T) {
+	cli := newFakeDockerClient(t, &fakeDockerDaemon{images: map[string]string{"myorg/driverkit-builder:present": "amd64"}})
+	t.Setenv("DOCKER_HOST", cli.DaemonHost())
+	t.Setenv("DOCKER_API_VERSION", "1.41")
+	bp := NewDockerBuildProcessor(0, "", PullNever, "", nil)
+
+	pulled, size, err := bp.Pull(context.Background(), "myorg/driverkit-builder:present", "amd64")
+	assert.NilError(t, err)
+	assert.Assert(t, !pulled)
+	assert.Equal(t, int64(0), size)
+
+	_, _, err = bp.Pull(context.Background(), "myorg/driverkit-builder:missing", "amd64")
+	assert.Error(t, err, "builder image myorg/driverkit-builder:missing is not present locally for architecture amd64, and pull policy is Never")
+}
+
+// pullMsgs are the progress messages of a pull of two layers, one already present locally.
+var pullMsgs = []jsonmessage.JSONMessage{
+	{Status: "Pulling from myorg/driverkit-builder", ID: "latest"},
+	{Status: "Already exists", ID: "layer0"},
+	{Status: "Pulling fs layer", ID: "layer1"},
+	{Status: "Pulling fs layer", ID: "layer2"},
+	{Status: "Downloading", ID: "layer1", Progress: &jsonmessage.JSONProgress{Current: 100, Total: 1000}},
+	{Status: "Downloading", ID: "layer2", Progress: &jsonmessage.JSONProgress{Current: 10, Total: 24}},
+	{Status: "Downloading", ID: "layer1", Progress: &jsonmessage.JSONProgress{Current: 1000, Total: 1000}},
+	{Status: "Download complete", ID: "layer1"},
+	{Status: "Extracting", ID: "layer1", Progress: &jsonmessage.JSONProgress{Current: 1000, Total: 5000}},
+	{Status: "Pull complete", ID: "layer1"},
+	{Status: "Digest: sha256:" + strings.Repeat("ab", 32)},
+}
+
+func TestDownloadedSize(t *testing.T) {
+	tests := map[string]struct {
+		msgs        []jsonmessage.JSONMessage
+		expected    int64
+		expectedErr string
+	}{
+		"layers downloaded once": {msgs: pullMsgs, expected: 1024},
+		"every layer present":    {msgs: pullMsgs[:2]},
+		"no progress total":      {msgs: []jsonmessage.JSONMessage{{Status: "Downloading", ID: "layer1", Progress: &jsonmessage.JSONProgress{Current: 10}}}},
+		"pull error": {
+			msgs:        append(append([]jsonmessage.JSONMessage{}, pullMsgs[:5]...), jsonmessage.JSONMessage{Error: &jsonmessage.JSONError{Message: "unauthorized"}}),
+			expectedErr: "unauthorized",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			for _, msg := range test.msgs {
+				assert.NilError(t, enc.Encode(msg))
+			}
+			size, err := downloadedSize(&buf)
+			if test.expectedErr != "" {
+				assert.Error(t, err, test.expectedErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, test.expected, size)
+		})
+	}
+
+	_, err := downloadedSize(strings.NewReader("{"))
+	assert.ErrorContains(t, err, "unexpected EOF")
+}
+
+func TestPull(t *testing.T) {
+	daemon := &fakeDockerDaemon{images: map[string]string{"myorg/driverkit-builder:present": "amd64"}, pullMsgs: pullMsgs}
+	cli := newFakeDockerClient(t, daemon)
+	t.Setenv("DOCKER_HOST", cli.DaemonHost())
+	t.Setenv("DOCKER_API_VERSION", "1.41")
+
+	size, err := pullImage(context.Background(), cli, "myorg/driverkit-builder:latest", "arm64")
+	assert.NilError(t, err)
+	assert.Equal(t, int64(1024), size)
+	assert.DeepEqual(t, []string{"myorg/driverkit-builder:latest@arm64"}, daemon.pulls)
+
+	// Images present locally are not pulled again
+	bp := NewDockerBuildProcessor(0, "", PullIfNotPresent, "", nil)
+	pulled, size, err := bp.Pull(context.Background(), "myorg/driverkit-builder:present", "amd64")
+	assert.NilError(t, err)
+	assert.Assert(t, !pulled)
+	assert.Equal(t, int64(0), size)
+	pulled, size, err = bp.Pull(context.Background(), "myorg/driverkit-builder:present", "arm64")
+	assert.NilError(t, err)
+	assert.Assert(t, pulled)
+	assert.Equal(t, int64(1024), size)
+	assert.DeepEqual(t, []string{"myorg/driverkit-builder:latest@arm64", "myorg/driverkit-builder:present@arm64"}, daemon.pulls)
+}
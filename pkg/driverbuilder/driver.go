@@ -0,0 +1,79 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+)
+
+// ContextBuildProcessor is a BuildProcessor whose builds can be stopped through a context.
+type ContextBuildProcessor interface {
+	BuildProcessor
+	StartContext(ctx context.Context, b *builder.Build) error
+}
+
+// DriverArtifacts are the drivers built by BuildDriver, along with the build metadata.
+type DriverArtifacts struct {
+	Module        []byte // nil when the kernel module was not built
+	Probe         []byte // nil when the eBPF probe was not built
+	Target        builder.Type
+	KernelRelease string
+	Architecture  string
+	GCCVersion    string // gcc the drivers were built with
+	Image         string // builder image the drivers were built in
+}
+
+// memoryDriverWriter keeps the built drivers in memory.
+type memoryDriverWriter struct {
+	module []byte
+	probe  []byte
+}
+
+func (w *memoryDriverWriter) WriteModule(r io.Reader) (err error) {
+	w.module, err = readAll(r)
+	return err
+}
+
+func (w *memoryDriverWriter) WriteProbe(r io.Reader) (err error) {
+	w.probe, err = readAll(r)
+	return err
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BuildDriver builds the drivers of b with bp, returning them in memory instead of writing them to files.
+// The drivers built are the ones of b.Drivers or, without Drivers, the ones whose output file path is set,
+// only telling which drivers to build: nothing is written to them.
+// ctx stops the build when bp is a ContextBuildProcessor, otherwise it is only checked before starting it.
+// The drivers are built by a copy of b, whose DriverWriter is replaced.
+func BuildDriver(ctx context.Context, bp BuildProcessor, b builder.Build) (*DriverArtifacts, error) {
+	w := &memoryDriverWriter{}
+	b.DriverWriter = w
+	var err error
+	if cbp, ok := bp.(ContextBuildProcessor); ok {
+		err = cbp.StartContext(ctx, &b)
+	} else if err = ctx.Err(); err == nil {
+		err = bp.Start(&b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	image, _ := b.ResolvedBuilderImage()
+	return &DriverArtifacts{
+		Module:        w.module,
+		Probe:         w.probe,
+		Target:        b.TargetType,
+		KernelRelease: b.KernelRelease,
+		Architecture:  b.Architecture,
+		GCCVersion:    b.GCCVersion,
+		Image:         image,
+	}, nil
+}
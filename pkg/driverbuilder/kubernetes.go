@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/signals"
-	"os"
+	"io"
+	"math"
+	"strings"
 	"time"
 
 	logger "github.com/sirupsen/logrus"
@@ -17,6 +19,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	restclient "k8s.io/client-go/rest"
@@ -28,21 +31,88 @@ const KubernetesBuildProcessorName = "kubernetes"
 
 const falcoBuilderUIDLabel = "org.falcosecurity/driverkit-uid"
 
+// PodScheduling constrains the nodes the build pods are scheduled on,
+// like to run the builds for an architecture on the nodes of that architecture.
+type PodScheduling struct {
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	Affinity     *corev1.Affinity
+}
+
+// DefaultBuildPodResources are the resources of the build pods, unless configured.
+var DefaultBuildPodResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1000m"),
+		corev1.ResourceMemory: resource.MustParse("2000Mi"),
+	},
+	Limits: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("4"),
+		corev1.ResourceMemory: resource.MustParse("4G"),
+	},
+}
+
+// ParseNodeSelector parses a node selector label in the <key>=<value> form.
+func ParseNodeSelector(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("node selector %q must be in the <key>=<value> form", s)
+	}
+	return key, value, nil
+}
+
+// ParseToleration parses a toleration in the <key>[=<value>][:<effect>] form, like "arch=arm64:NoSchedule":
+// without a value, the toleration tolerates the taints with the key whatever their value, and, without an effect,
+// whatever their effect. An empty key without a value tolerates every taint.
+func ParseToleration(s string) (corev1.Toleration, error) {
+	spec, effect, _ := strings.Cut(s, ":")
+	key, value, hasValue := strings.Cut(spec, "=")
+	t := corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffect(effect)}
+	if hasValue {
+		if key == "" {
+			return corev1.Toleration{}, fmt.Errorf("toleration %q must have a key, since it has a value", s)
+		}
+		t.Operator = corev1.TolerationOpEqual
+		t.Value = value
+	}
+	switch t.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return corev1.Toleration{}, fmt.Errorf("toleration %q effect must be one of [%s,%s,%s]", s, corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute)
+	}
+	return t, nil
+}
+
+// ParseAffinity parses a pod affinity, either as json or yaml, like the affinity field of pod specs.
+func ParseAffinity(s string) (*corev1.Affinity, error) {
+	affinity := &corev1.Affinity{}
+	if err := yaml.NewYAMLOrJSONDecoder(strings.NewReader(s), len(s)).Decode(affinity); err != nil {
+		return nil, fmt.Errorf("invalid affinity: %w", err)
+	}
+	return affinity, nil
+}
+
 type KubernetesBuildProcessor struct {
 	coreV1Client    v1.CoreV1Interface
 	clientConfig    *restclient.Config
 	runAsUser       int64
 	namespace       string
 	imagePullSecret string
-	timeout         int
+	timeout         time.Duration
 	proxy           string
+	scheduling      PodScheduling
+	resources       corev1.ResourceRequirements
 }
 
 // NewKubernetesBuildProcessor constructs a KubernetesBuildProcessor
 // starting from a kubernetes.Clientset. bufferSize represents the length of the
 // channel we use to do the builds. A bigger bufferSize will mean that we can save more Builds
-// for processing, however setting this to a big value will have impacts
-func NewKubernetesBuildProcessor(corev1Client v1.CoreV1Interface, clientConfig *restclient.Config, runAsUser int64, namespace string, imagePullSecret string, timeout int, proxy string) *KubernetesBuildProcessor {
+// for processing, however setting this to a big value will have impacts.
+// Build pods are scheduled according to scheduling, and their builder container gets resources,
+// or DefaultBuildPodResources when it has neither requests nor limits.
+func NewKubernetesBuildProcessor(corev1Client v1.CoreV1Interface, clientConfig *restclient.Config, runAsUser int64, namespace string, imagePullSecret string, timeout time.Duration, proxy string, scheduling PodScheduling, resources corev1.ResourceRequirements) *KubernetesBuildProcessor {
+	if resources.Requests == nil && resources.Limits == nil {
+		resources = DefaultBuildPodResources
+	}
 	return &KubernetesBuildProcessor{
 		coreV1Client:    corev1Client,
 		clientConfig:    clientConfig,
@@ -51,6 +121,8 @@ func NewKubernetesBuildProcessor(corev1Client v1.CoreV1Interface, clientConfig *
 		imagePullSecret: imagePullSecret,
 		timeout:         timeout,
 		proxy:           proxy,
+		scheduling:      scheduling,
+		resources:       resources,
 	}
 }
 
@@ -59,12 +131,17 @@ func (bp *KubernetesBuildProcessor) String() string {
 }
 
 func (bp *KubernetesBuildProcessor) Start(b *builder.Build) error {
+	return bp.StartContext(context.Background(), b)
+}
+
+// StartContext is like Start, deleting the build pod when ctx is done.
+func (bp *KubernetesBuildProcessor) StartContext(ctx context.Context, b *builder.Build) error {
 	logger.Debug("doing a new kubernetes build")
-	return bp.buildModule(b)
+	return bp.buildModule(ctx, b)
 }
 
-func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
-	deadline := int64(bp.timeout)
+func (bp *KubernetesBuildProcessor) buildModule(ctx context.Context, b *builder.Build) error {
+	deadline := int64(math.Ceil(bp.timeout.Seconds()))
 	namespace := bp.namespace
 	uid := uuid.NewUUID()
 	name := fmt.Sprintf("driverkit-%s", string(uid))
@@ -82,17 +159,21 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 
 	c := b.ToConfig()
 
-	// generate the build script from the builder
-	res, err := builder.Script(v, c, kr)
+	ctx = signals.WithStandardSignals(ctx)
+
+	// generate the build script from the builder, giving the build timeout to the discovery of builder images
+	discoveryCtx, cancel := context.WithTimeout(ctx, bp.timeout)
+	defer cancel()
+	res, err := builder.Script(discoveryCtx, v, c, kr)
 	if err != nil {
 		return err
 	}
 
-	if builder.ModuleFullPath != "" {
+	if b.BuildsModule() {
 		res = fmt.Sprintf("%s\n%s", "touch "+moduleLockFile, res)
 		res = fmt.Sprintf("%s\n%s", res, "rm "+moduleLockFile)
 	}
-	if builder.ProbeFullPath != "" {
+	if b.BuildsProbe() {
 		res = fmt.Sprintf("%s\n%s", "touch "+probeLockFile, res)
 		res = fmt.Sprintf("%s\n%s", res, "rm "+probeLockFile)
 	}
@@ -101,11 +182,6 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 	// for the module to be ready before exiting PID 1
 	res = fmt.Sprintf("%s\n%s", res, waitForLockScript)
 
-	buildCmd := []string{
-		"/bin/bash",
-		"/driverkit/driverkit.sh",
-	}
-
 	commonMeta := metav1.ObjectMeta{
 		Name:      name,
 		Namespace: namespace,
@@ -176,24 +252,17 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 			RestartPolicy:         corev1.RestartPolicyNever,
 			SecurityContext:       &secuContext,
 			ImagePullSecrets:      []corev1.LocalObjectReference{{Name: bp.imagePullSecret}},
+			NodeSelector:          bp.scheduling.NodeSelector,
+			Tolerations:           bp.scheduling.Tolerations,
+			Affinity:              bp.scheduling.Affinity,
 			Containers: []corev1.Container{
 				{
 					Name:            name,
 					Image:           builderImage,
-					Command:         buildCmd,
+					Command:         b.GetBuildCommand(),
 					Env:             envs,
 					ImagePullPolicy: corev1.PullIfNotPresent,
-
-					Resources: corev1.ResourceRequirements{
-						Requests: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("1000m"),
-							corev1.ResourceMemory: resource.MustParse("2000Mi"),
-						},
-						Limits: corev1.ResourceList{
-							corev1.ResourceCPU:    resource.MustParse("4"),
-							corev1.ResourceMemory: resource.MustParse("4G"),
-						},
-					},
+					Resources:       bp.resources,
 					VolumeMounts: []corev1.VolumeMount{
 						{
 							Name:      "driverkit",
@@ -218,8 +287,6 @@ func (bp *KubernetesBuildProcessor) buildModule(b *builder.Build) error {
 		},
 	}
 
-	ctx := context.Background()
-	ctx = signals.WithStandardSignals(ctx)
 	_, err = configClient.Create(ctx, cm, metav1.CreateOptions{})
 	if err != nil {
 		return err
@@ -241,16 +308,25 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	if err != nil {
 		return err
 	}
-	// Give it ten minutes to complete, if it doesn't give an error
-	// TODO(fntlnz): maybe pass this from the outside?
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer watch.Stop()
+	// Give it the build timeout to complete, if it doesn't give an error
+	ctx, cancel := context.WithTimeout(ctx, bp.timeout)
 	defer cancel()
+	// The build timeout of the build, if any, only governs the wait for the build in the pod
+	buildCtx, cancelBuild := withBuildTimeout(ctx, build)
+	defer cancelBuild()
 	for {
 		select {
-		case <-ctx.Done():
-			return errors.New("module copy from pod interrupted before the copy was complete")
-		default:
-			event := <-watch.ResultChan()
+		case <-buildCtx.Done():
+			err := errors.New("module copy from pod interrupted before the copy was complete")
+			if buildTimedOut(ctx, buildCtx, build) {
+				return &BuildTimeoutError{Timeout: build.BuildTimeout, Err: err}
+			}
+			return err
+		case event, ok := <-watch.ResultChan():
+			if !ok {
+				return errors.New("watch of the build pod closed before the copy was complete")
+			}
 			p, ok := event.Object.(*corev1.Pod)
 			if !ok {
 				logger.Error("unexpected type when watching pods")
@@ -259,19 +335,22 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 			if p.Status.Phase == corev1.PodPending {
 				continue
 			}
+			if oomKilled(p) {
+				return bp.oomKilledError()
+			}
 			if p.Status.Phase == corev1.PodRunning {
 				logger.WithField(falcoBuilderUIDLabel, falcoBuilderUID).Info("start downloading module and probe from pod")
-				if builder.ModuleFullPath != "" {
-					err = copySingleFileFromPod(build.ModuleFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ModuleFullPath, moduleLockFile)
+				if build.BuildsModule() {
+					err = copySingleFileFromPod(build.Writer().WriteModule, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ModuleFullPath, moduleLockFile)
 					if err != nil {
-						return err
+						return bp.copyError(ctx, p, err)
 					}
 					logger.Info("Kernel Module extraction successful")
 				}
-				if builder.ProbeFullPath != "" {
-					err = copySingleFileFromPod(build.ProbeFilePath, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ProbeFullPath, probeLockFile)
+				if build.BuildsProbe() {
+					err = copySingleFileFromPod(build.Writer().WriteProbe, bp.coreV1Client, bp.clientConfig, p.Namespace, p.Name, builder.ProbeFullPath, probeLockFile)
 					if err != nil {
-						return err
+						return bp.copyError(ctx, p, err)
 					}
 					logger.Info("Probe Module extraction successful")
 				}
@@ -286,6 +365,37 @@ func (bp *KubernetesBuildProcessor) copyModuleAndProbeFromPodWithUID(ctx context
 	}
 }
 
+// copyError returns the error of a failed copy of the drivers from the pod:
+// an OOMKilledError when the builder container was killed for running out of memory in the meantime,
+// otherwise a BuildError.
+func (bp *KubernetesBuildProcessor) copyError(ctx context.Context, pod *corev1.Pod, err error) error {
+	if p, getErr := bp.coreV1Client.Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{}); getErr == nil && oomKilled(p) {
+		return bp.oomKilledError()
+	}
+	return &BuildError{Err: err}
+}
+
+// oomKilledError returns the OOMKilledError of the build pods, reporting their memory limit, if any.
+func (bp *KubernetesBuildProcessor) oomKilledError() error {
+	e := &OOMKilledError{}
+	if limit, ok := bp.resources.Limits[corev1.ResourceMemory]; ok {
+		e.MemoryLimit = limit.String()
+	}
+	return e
+}
+
+// oomKilled returns whether any container of the pod was killed for running out of memory.
+func oomKilled(p *corev1.Pod) bool {
+	for _, status := range p.Status.ContainerStatuses {
+		for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+			if state.Terminated != nil && state.Terminated.Reason == "OOMKilled" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *corev1.Pod) error {
 	options := &exec.ExecOptions{
 		PodClient: podClient,
@@ -315,7 +425,8 @@ func unlockPod(podClient v1.PodsGetter, clientConfig *restclient.Config, pod *co
 	return nil
 }
 
-func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
+// copySingleFileFromPod gives the content of the fileNameToCopy file of the pod to write, once fully downloaded.
+func copySingleFileFromPod(write func(r io.Reader) error, podClient v1.PodsGetter, clientConfig *restclient.Config, namespace string, podName string, fileNameToCopy string, lockFilename string) error {
 	if len(namespace) == 0 {
 		return errors.New("need a namespace to copy from pod")
 	}
@@ -324,11 +435,7 @@ func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig
 		return errors.New("need a podName to copy from pod")
 	}
 
-	out, err := os.Create(dstFile)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
+	out := bytes.NewBuffer(nil)
 
 	options := &exec.ExecOptions{
 		PodClient: podClient,
@@ -358,5 +465,5 @@ func copySingleFileFromPod(dstFile string, podClient v1.PodsGetter, clientConfig
 		return err
 	}
 
-	return nil
+	return write(out)
 }
@@ -0,0 +1,44 @@
+package driverbuilder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+)
+
+func TestCopyFromPodTimeoutWithoutEvents(t *testing.T) {
+	tests := map[string]struct {
+		timeout      time.Duration
+		buildTimeout time.Duration
+		timedOut     bool
+	}{
+		"timeout":       {timeout: 50 * time.Millisecond},
+		"build timeout": {timeout: time.Minute, buildTimeout: 50 * time.Millisecond, timedOut: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The pod never sends any event
+			client := fake.NewSimpleClientset()
+			bp := NewKubernetesBuildProcessor(client.CoreV1(), &restclient.Config{}, 0, "default", "", test.timeout, "", PodScheduling{}, corev1.ResourceRequirements{})
+			done := make(chan error)
+			go func() {
+				done <- bp.copyModuleAndProbeFromPodWithUID(context.Background(), &builder.Build{BuildTimeout: test.buildTimeout}, "default", "uid")
+			}()
+			select {
+			case err := <-done:
+				assert.ErrorContains(t, err, "module copy from pod interrupted before the copy was complete")
+				var timeoutErr *BuildTimeoutError
+				assert.Equal(t, test.timedOut, errors.As(err, &timeoutErr))
+			case <-time.After(5 * time.Second):
+				t.Fatal("copy from pod not interrupted by the timeout")
+			}
+		})
+	}
+}
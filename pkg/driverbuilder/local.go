@@ -0,0 +1,280 @@
+package driverbuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/falcosecurity/driverkit/pkg/signals"
+	logger "github.com/sirupsen/logrus"
+)
+
+// LocalBuildProcessorName is a constant containing the local name.
+const LocalBuildProcessorName = "local"
+
+// localImageName is the name of the fake builder image representing the host toolchain.
+const localImageName = "local"
+
+// localRequiredTools are the tools that every build script needs on the host.
+var localRequiredTools = []string{"bash", "curl", "tar", "make", "gcc", "strip", "modinfo"}
+
+// localOptionalTools are the tools needed on the host only when used by the target build script.
+var localOptionalTools = []string{"rpm2cpio", "cpio", "ar", "xz", "zstd", "unzstd"}
+
+// localProbeTools are the tools needed on the host to build the eBPF probe.
+var localProbeTools = []string{"clang", "llc"}
+
+// localPackagesInstall matches the package installations of build scripts, that would change the host.
+var localPackagesInstall = regexp.MustCompile(`(?m)^\s*(yum|dnf|apt-get|apt|zypper|apk)\s.*\binstall\b`)
+
+// localTmpPath matches the /tmp paths of build scripts.
+var localTmpPath = regexp.MustCompile(`(?m)(^|[\s="'])/tmp\b`)
+
+type LocalBuildProcessor struct {
+	timeout time.Duration
+	proxy   string
+}
+
+// NewLocalBuildProcessor ...
+func NewLocalBuildProcessor(timeout time.Duration, proxy string) *LocalBuildProcessor {
+	return &LocalBuildProcessor{
+		timeout: timeout,
+		proxy:   proxy,
+	}
+}
+
+func (bp *LocalBuildProcessor) String() string {
+	return LocalBuildProcessorName
+}
+
+// localImagesLister provides a single builder image, representing the toolchain installed on the host.
+type localImagesLister struct {
+	gccPath string
+}
+
+// NewLocalImagesLister returns an ImagesLister providing the toolchain installed on the host as the only builder image.
+func NewLocalImagesLister() (builder.ImagesLister, error) {
+	return newLocalImagesLister()
+}
+
+func newLocalImagesLister() (*localImagesLister, error) {
+	gccPath, err := exec.LookPath("gcc")
+	if err != nil {
+		return nil, fmt.Errorf("missing required tools on the host: gcc")
+	}
+	return &localImagesLister{gccPath: gccPath}, nil
+}
+
+func (l *localImagesLister) LoadImages(ctx context.Context) ([]builder.Image, error) {
+	out, err := exec.CommandContext(ctx, l.gccPath, "-dumpfullversion", "-dumpversion").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching local gcc version: %w", err)
+	}
+	gccVersion, err := semver.ParseTolerant(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing local gcc version: %w", err)
+	}
+	image := builder.Image{
+		Target:     "any",
+		GCCVersion: gccVersion,
+		Name:       localImageName,
+	}
+	// clang is only needed for the eBPF probe: ignore it when missing
+	if out, err = exec.CommandContext(ctx, "clang", "-dumpversion").Output(); err == nil {
+		if clangVersion, err := semver.ParseTolerant(strings.TrimSpace(string(out))); err == nil {
+			image.ClangVersion = clangVersion
+		}
+	}
+	return []builder.Image{image}, nil
+}
+
+// checkTools verifies that all the tools needed by the build script are installed on the host.
+func (bp *LocalBuildProcessor) checkTools(b *builder.Build, script string) error {
+	tools := append([]string{}, localRequiredTools...)
+	for _, tool := range localOptionalTools {
+		if strings.Contains(script, tool+" ") {
+			tools = append(tools, tool)
+		}
+	}
+	if b.BuildsProbe() {
+		tools = append(tools, localProbeTools...)
+	}
+
+	var missing []string
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required tools on the host: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// localScript returns the build script run on the host, with the /tmp paths, like the driver build and kernel download
+// directories, and the /driverkit files moved under dir, so that builds never clash nor find the leftovers of previous
+// ones, and with the gcc at gccPath in place of the one of gccVersion.
+func localScript(script string, dir string, gccVersion string, gccPath string) string {
+	script = localTmpPath.ReplaceAllString(script, "${1}"+filepath.Join(dir, "tmp"))
+	script = strings.ReplaceAll(script, "/driverkit/", dir+"/")
+	return strings.ReplaceAll(script, "/usr/bin/gcc-"+gccVersion, gccPath)
+}
+
+// Start the local processor
+func (bp *LocalBuildProcessor) Start(b *builder.Build) error {
+	return bp.StartContext(context.Background(), b)
+}
+
+// StartContext is like Start, killing the build when ctx is done.
+func (bp *LocalBuildProcessor) StartContext(ctx context.Context, b *builder.Build) error {
+	logger.Debug("doing a new local build")
+
+	lister, err := newLocalImagesLister()
+	if err != nil {
+		return err
+	}
+	// Builder images are useless here: only the host toolchain is available
+	b.ImagesListers = []builder.ImagesLister{lister}
+
+	kr := b.KernelReleaseFromBuildConfig()
+
+	// create a builder based on the choosen build type
+	v, err := builder.Factory(b.TargetType)
+	if err != nil {
+		return err
+	}
+	c := b.ToConfig()
+
+	// The build timeout also governs the discovery of the host toolchain
+	ctx = signals.WithStandardSignals(ctx)
+	ctx, cancel := context.WithTimeout(ctx, bp.timeout)
+	defer cancel()
+
+	// Generate the build script from the builder
+	driverkitScript, err := builder.Script(ctx, v, c, kr)
+	if err != nil {
+		return err
+	}
+
+	if localPackagesInstall.MatchString(driverkitScript) {
+		return fmt.Errorf("target %s not supported by the local processor: its build script installs packages", b.TargetType)
+	}
+	if err = bp.checkTools(b, driverkitScript); err != nil {
+		return err
+	}
+
+	// Build scripts expect their files under /driverkit and work under /tmp:
+	// move both to a temporary directory of the run instead
+	dir, err := os.MkdirTemp("", "driverkit-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err = os.Mkdir(filepath.Join(dir, "tmp"), 0700); err != nil {
+		return err
+	}
+	driverkitScript = localScript(driverkitScript, dir, b.GCCVersion, lister.gccPath)
+
+	// Prepare driver config template
+	bufFillDriverConfig := bytes.NewBuffer(nil)
+	err = renderFillDriverConfig(bufFillDriverConfig, driverConfigData{DriverVersion: c.DriverVersion, DriverName: c.DriverName, DeviceName: c.DeviceName})
+	if err != nil {
+		return err
+	}
+
+	// Prepare makefile template
+	objList, err := LoadMakefileObjList(c)
+	if err != nil {
+		return err
+	}
+	bufMakefile := bytes.NewBuffer(nil)
+	err = renderMakefile(bufMakefile, makefileData{ModuleName: c.DriverName, ModuleBuildDir: filepath.Join(dir, builder.DriverDirectory), MakeObjList: objList})
+	if err != nil {
+		return err
+	}
+
+	configDecoded, err := base64.StdEncoding.DecodeString(b.KernelConfigData)
+	if err != nil {
+		return err
+	}
+
+	files := []dockerCopyFile{
+		{"driverkit.sh", driverkitScript},
+		{"kernel.config", string(configDecoded)},
+		{"module-Makefile", bufMakefile.String()},
+		{"fill-driver-config.sh", bufFillDriverConfig.String()},
+	}
+	for _, file := range files {
+		if err = os.WriteFile(filepath.Join(dir, file.Name), []byte(file.Body), 0600); err != nil {
+			return err
+		}
+	}
+
+	// The build timeout only governs the build script run
+	buildCtx, cancelBuild := withBuildTimeout(ctx, b)
+	defer cancelBuild()
+	cmd := exec.CommandContext(buildCtx, "/bin/bash", filepath.Join(dir, "driverkit.sh"))
+	cmd.Env = os.Environ()
+	// Add http_proxy and https_proxy environment variable
+	if bp.proxy != "" {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("http_proxy=%s", bp.proxy),
+			fmt.Sprintf("https_proxy=%s", bp.proxy),
+		)
+	}
+	logPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+
+	logger.WithField("gcc", b.GCCVersion).Debug("starting local build")
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	forwardLogs(logPipe, buildLogger(b))
+	if err = cmd.Wait(); err != nil {
+		if buildTimedOut(ctx, buildCtx, b) {
+			return &BuildTimeoutError{Timeout: b.BuildTimeout, Err: fmt.Errorf("local build killed: %w", err)}
+		}
+		return &BuildError{Err: fmt.Errorf("local build failed: %w", err)}
+	}
+
+	if b.BuildsModule() {
+		if err := copyLocalFile(filepath.Join(dir, builder.ModuleFullPath), b.Writer().WriteModule); err != nil {
+			return err
+		}
+		logDriverAvailable(b, "kernel module available", b.ModuleFilePath)
+	}
+
+	if b.BuildsProbe() {
+		if err := copyLocalFile(filepath.Join(dir, builder.ProbeFullPath), b.Writer().WriteProbe); err != nil {
+			return err
+		}
+		logDriverAvailable(b, "eBPF probe available", b.ProbeFilePath)
+	}
+
+	return nil
+}
+
+// copyLocalFile gives the content of the from file to write.
+func copyLocalFile(from string, write func(r io.Reader) error) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return write(in)
+}
@@ -0,0 +1,59 @@
+package driverbuilder
+
+import (
+	"testing"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"gotest.tools/assert"
+)
+
+func TestLocalScript(t *testing.T) {
+	script := `rm -Rf /tmp/driver
+mkdir /tmp/driver
+mkdir /tmp/kernel-download
+cd /tmp
+tar -Jxf kernel.tar.xz -C /tmp/kernel-download
+cp /driverkit/module-Makefile /tmp/driver/Makefile
+cp /driverkit/kernel.config "/tmp/kernel/.config"
+make CC=/usr/bin/gcc-8.3.0 KERNELDIR=/tmp/kernel
+ls /tmpfs /var/tmp/cache`
+	expect := `rm -Rf /run/1/tmp/driver
+mkdir /run/1/tmp/driver
+mkdir /run/1/tmp/kernel-download
+cd /run/1/tmp
+tar -Jxf kernel.tar.xz -C /run/1/tmp/kernel-download
+cp /run/1/module-Makefile /run/1/tmp/driver/Makefile
+cp /run/1/kernel.config "/run/1/tmp/kernel/.config"
+make CC=/usr/local/bin/gcc KERNELDIR=/run/1/tmp/kernel
+ls /tmpfs /var/tmp/cache`
+	assert.Equal(t, expect, localScript(script, "/run/1", "8.3.0", "/usr/local/bin/gcc"))
+}
+
+func TestLocalScriptTemplates(t *testing.T) {
+	for target, b := range builder.BuilderByTarget {
+		t.Run(target.String(), func(t *testing.T) {
+			// Only the files of the run directory are left
+			script := localScript(b.TemplateScript(), "/run/1", "8.3.0", "/usr/bin/gcc")
+			assert.Assert(t, !localTmpPath.MatchString(script), script)
+		})
+	}
+}
+
+func TestLocalPackagesInstall(t *testing.T) {
+	tests := map[string]bool{
+		"yum install -y --downloadonly kernel-devel": true,
+		"  apt-get -y install linux-headers":         true,
+		"dnf -q install kernel-devel":                true,
+		"zypper install kernel-default-devel":        true,
+		"curl -SL https://example.com/install.sh":    false,
+		"make modules_install":                       false,
+		"rpm2cpio kernel-devel.rpm | cpio --extract": false,
+	}
+	for line, expect := range tests {
+		assert.Equal(t, expect, localPackagesInstall.MatchString(line), line)
+	}
+	// redhat is the only target installing packages
+	for target, b := range builder.BuilderByTarget {
+		assert.Equal(t, target == builder.TargetTypeRedhat, localPackagesInstall.MatchString(b.TemplateScript()), target)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -11,6 +12,9 @@ import (
 )
 
 var (
+	// distroVersionPattern matches the distro major version of rpm based distros kernel releases,
+	// like "el7" in "3.10.0-957.el7.x86_64", "fc35" in "5.14.10-300.fc35.x86_64" or "amzn2".
+	distroVersionPattern = regexp.MustCompile(`\.(?:el|fc|amzn)([0-9]+)`)
 	kernelVersionPattern = regexp.MustCompile(`(?P<fullversion>^(?P<version>0|[1-9]\d*)\.(?P<patchlevel>0|[1-9]\d*)[.+]?(?P<sublevel>0|[1-9]\d*)?)(?P<fullextraversion>[-.+](?P<extraversion>0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)([\.+~](0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-_]*))*)?(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
 )
 
@@ -54,6 +58,7 @@ func init() {
 		supportedArchsSlice[i] = k.String()
 		i++
 	}
+	sort.Strings(supportedArchsSlice)
 }
 
 func (aa Architectures) String() string {
@@ -64,12 +69,26 @@ func (aa Architectures) Strings() []string {
 	return supportedArchsSlice
 }
 
+// Describe lists the architectures together with their non-deb aliases, like "amd64 (x86_64), arm64 (aarch64)".
+func (aa Architectures) Describe() string {
+	descs := make([]string, 0, len(supportedArchsSlice))
+	for _, arch := range supportedArchsSlice {
+		descs = append(descs, fmt.Sprintf("%s (%s)", arch, aa[Architecture(arch)]))
+	}
+	return strings.Join(descs, ", ")
+}
+
 type Architecture string
 
+// ToNonDeb returns the non-deb name of the architecture, like "x86_64" for "amd64";
+// architectures already in their non-deb form are returned as they are.
 func (a Architecture) ToNonDeb() string {
 	if val, ok := SupportedArchs[a]; ok {
 		return val
 	}
+	if arch, err := ParseArchitecture(a.String()); err == nil {
+		return SupportedArchs[arch]
+	}
 	panic(fmt.Errorf("missing non-deb name for arch: %s", a.String()))
 }
 
@@ -77,6 +96,17 @@ func (a Architecture) String() string {
 	return string(a)
 }
 
+// ParseArchitecture returns the supported Architecture named s,
+// accepting both its deb name (eg: "amd64") and its non-deb alias (eg: "x86_64").
+func ParseArchitecture(s string) (Architecture, error) {
+	for arch, nonDeb := range SupportedArchs {
+		if s == arch.String() || s == nonDeb {
+			return arch, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported architecture %q, valid values are %s", s, SupportedArchs.Describe())
+}
+
 // KernelRelease contains all the version parts.
 // NOTE: we cannot fetch Architecture from kernel string
 // because it is not always provided.
@@ -131,3 +161,13 @@ func (k *KernelRelease) SupportsModule() bool {
 func (k *KernelRelease) SupportsProbe() bool {
 	return k.GTE(probeMinKernelVersion[k.Architecture])
 }
+
+// DistroVersion returns the major version of the distro shipping the kernel release, like "7" for "3.10.0-957.el7.x86_64",
+// or an empty string when it cannot be derived from the kernel release.
+func (k *KernelRelease) DistroVersion() string {
+	match := distroVersionPattern.FindStringSubmatch(k.FullExtraversion)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
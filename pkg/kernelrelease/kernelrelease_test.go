@@ -340,3 +340,53 @@ func TestSupportsProbe(t *testing.T) {
 		}
 	}
 }
+
+func TestParseArchitecture(t *testing.T) {
+	tests := map[string]Architecture{
+		"amd64":   ArchitectureAmd64,
+		"x86_64":  ArchitectureAmd64,
+		"arm64":   ArchitectureArm64,
+		"aarch64": ArchitectureArm64,
+	}
+	for s, want := range tests {
+		t.Run(s, func(t *testing.T) {
+			got, err := ParseArchitecture(s)
+			assert.NilError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	_, err := ParseArchitecture("x86")
+	assert.Error(t, err, `unsupported architecture "x86", valid values are amd64 (x86_64), arm64 (aarch64)`)
+}
+
+func TestToNonDeb(t *testing.T) {
+	tests := map[Architecture]string{
+		"amd64":   "x86_64",
+		"x86_64":  "x86_64",
+		"arm64":   "aarch64",
+		"aarch64": "aarch64",
+	}
+	for arch, want := range tests {
+		t.Run(arch.String(), func(t *testing.T) {
+			assert.Equal(t, want, arch.ToNonDeb())
+		})
+	}
+}
+
+func TestDistroVersion(t *testing.T) {
+	tests := map[string]string{
+		"3.10.0-957.el7.x86_64":         "7",
+		"4.18.0-348.7.1.el8_5.x86_64":   "8",
+		"5.14.10-300.fc35.x86_64":       "35",
+		"4.14.268-205.500.amzn2.x86_64": "2",
+		"5.4.0-1063-aws":                "",
+		"5.10.0-0.bpo.12-cloud-amd64":   "",
+	}
+	for kr, expected := range tests {
+		t.Run(kr, func(t *testing.T) {
+			k := FromString(kr)
+			assert.Equal(t, expected, k.DistroVersion())
+		})
+	}
+}
@@ -12,12 +12,8 @@ func isArchitectureSupported(fl validator.FieldLevel) bool {
 
 	switch field.Kind() {
 	case reflect.String:
-		for arch := range kernelrelease.SupportedArchs {
-			if arch.String() == field.String() {
-				return true
-			}
-		}
-		return false
+		_, err := kernelrelease.ParseArchitecture(field.String())
+		return err == nil
 	}
 
 	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
@@ -0,0 +1,37 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder"
+	"github.com/go-playground/validator/v10"
+)
+
+// containerPrefixRegex matches the valid prefixes of docker container names.
+var containerPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// isContainerPrefix validates the prefix of the names of the containers created by the docker processor.
+func isContainerPrefix(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		return containerPrefixRegex.MatchString(field.String())
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
+
+func isContainerLabel(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		_, _, err := driverbuilder.ParseContainerLabel(field.String())
+		return err == nil
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -0,0 +1,35 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/go-playground/validator/v10"
+)
+
+// isGCCVersion validates a gcc version constraint: either auto,
+// or a comma separated list of semver-ish strings and semver ranges.
+func isGCCVersion(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		if field.String() == "auto" {
+			return true
+		}
+		for _, constraint := range strings.Split(field.String(), ",") {
+			constraint = strings.TrimSpace(constraint)
+			if _, err := semver.ParseTolerant(constraint); err == nil {
+				continue
+			}
+			if _, err := semver.ParseRange(constraint); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -0,0 +1,37 @@
+package validate
+
+import (
+	"os"
+
+	"github.com/go-playground/validator/v10"
+	logger "github.com/sirupsen/logrus"
+)
+
+// LogFormatters are the logrus formatters available for the log format.
+// The text one is only colored when logs are written to a terminal, unless overridden by the CLICOLOR_FORCE
+// and CLICOLOR environment variables (see https://bixense.com/clicolors); see DisableLogColors too.
+var LogFormatters = map[string]logger.Formatter{
+	"text": &logger.TextFormatter{
+		DisableLevelTruncation:    false,
+		EnvironmentOverrideColors: true,
+		DisableTimestamp:          true,
+	},
+	"json": &logger.JSONFormatter{
+		DisableTimestamp: true,
+	},
+}
+
+func isLogrusFormat(fl validator.FieldLevel) bool {
+	formatter, ok := LogFormatters[fl.Field().String()]
+	if !ok {
+		return false
+	}
+	logger.SetFormatter(formatter)
+	return true
+}
+
+// DisableLogColors disables the colors of the text log format when disable is set,
+// or when the NO_COLOR environment variable is set to a non empty value (see https://no-color.org).
+func DisableLogColors(disable bool) {
+	LogFormatters["text"].(*logger.TextFormatter).DisableColors = disable || os.Getenv("NO_COLOR") != ""
+}
@@ -0,0 +1,42 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Processors contains the canonical names of the build processors.
+var Processors = []string{"docker", "kubernetes", "kubernetes-in-cluster", "local"}
+
+// ProcessorAliases maps the aliases of the build processors to their canonical names.
+var ProcessorAliases = map[string]string{
+	"k8s":    "kubernetes",
+	"k8s-ic": "kubernetes-in-cluster",
+}
+
+// isProcessor accepts both canonical processor names and their aliases,
+// normalizing the latter to the canonical names when the field is settable.
+func isProcessor(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		name := field.String()
+		if canonical, ok := ProcessorAliases[name]; ok {
+			if field.CanSet() {
+				field.SetString(canonical)
+			}
+			return true
+		}
+		for _, processor := range Processors {
+			if name == processor {
+				return true
+			}
+		}
+		return false
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
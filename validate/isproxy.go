@@ -2,20 +2,30 @@ package validate
 
 import (
 	"fmt"
+	"net/url"
 	"reflect"
-	"regexp"
 
 	"github.com/go-playground/validator/v10"
 )
 
-var proxyRegex = regexp.MustCompile("^(http://|https://|socks5://)")
+// ProxySchemes contains the supported proxy url schemes, mapped to their default ports.
+var ProxySchemes = map[string]string{
+	"http":   "80",
+	"https":  "443",
+	"socks5": "1080",
+}
 
 func isProxy(fl validator.FieldLevel) bool {
 	field := fl.Field()
 
 	switch field.Kind() {
 	case reflect.String:
-		return proxyRegex.MatchString(field.String())
+		u, err := url.Parse(field.String())
+		if err != nil {
+			return false
+		}
+		_, ok := ProxySchemes[u.Scheme]
+		return ok && u.Hostname() != ""
 	}
 
 	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
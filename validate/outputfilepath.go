@@ -0,0 +1,20 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
+	"github.com/go-playground/validator/v10"
+)
+
+func isOutputFilePath(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		return builder.ValidateOutputFilePath(field.String()) == nil
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
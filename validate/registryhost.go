@@ -0,0 +1,32 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var registryHostRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*$`)
+
+// isRegistryHost validates a registry host, with an optional port, like "mirror.example.com:5000".
+func isRegistryHost(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		host := field.String()
+		if h, port, err := net.SplitHostPort(host); err == nil {
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				return false
+			}
+			host = h
+		}
+		return registryHostRegex.MatchString(host)
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -31,3 +31,15 @@ func isSemVer(fl validator.FieldLevel) bool {
 func isSemVerTolerant(fl validator.FieldLevel) bool {
 	return checkSemver(fl.Field(), true)
 }
+
+func isSemVerRange(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.String:
+		_, err := semver.ParseRange(field.String())
+		return err == nil
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
@@ -3,6 +3,7 @@ package validate
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
 	"github.com/go-playground/validator/v10"
@@ -13,8 +14,13 @@ func isTargetSupported(fl validator.FieldLevel) bool {
 
 	switch field.Kind() {
 	case reflect.String:
-		_, ok := builder.BuilderByTarget[builder.Type(field.String())]
-		return ok
+		// Multiple targets can be passed as a comma separated list
+		for _, target := range strings.Split(field.String(), ",") {
+			if _, ok := builder.BuilderByTarget[builder.Type(target)]; !ok {
+				return false
+			}
+		}
+		return true
 	}
 
 	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
@@ -0,0 +1,27 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// MinTimeout is the minimum allowed timeout for a build.
+const MinTimeout = 30 * time.Second
+
+// MinKubernetesTimeout is the minimum allowed timeout for a build running in a kubernetes pod,
+// that needs more time to be scheduled and to start.
+const MinKubernetesTimeout = time.Minute
+
+func isTimeout(fl validator.FieldLevel) bool {
+	field := fl.Field()
+
+	switch field.Kind() {
+	case reflect.Int64:
+		return time.Duration(field.Int()) >= MinTimeout
+	}
+
+	panic(fmt.Sprintf("Bad field type %T", field.Interface()))
+}
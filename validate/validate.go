@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/falcosecurity/driverkit/pkg/kernelrelease"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/falcosecurity/driverkit/pkg/driverbuilder/builder"
@@ -34,14 +35,32 @@ func init() {
 	})
 
 	V.RegisterValidation("logrus", isLogrusLevel)
+	V.RegisterValidation("logformat", isLogrusFormat)
 	V.RegisterValidation("filepath", isFilePath)
 	V.RegisterValidation("sha1", isSHA1)
 	V.RegisterValidation("target", isTargetSupported)
 	V.RegisterValidation("architecture", isArchitectureSupported)
 	V.RegisterValidation("semver", isSemVer)
 	V.RegisterValidation("semvertolerant", isSemVerTolerant)
+	V.RegisterValidation("semverrange", isSemVerRange)
+	V.RegisterValidation("gccversion", isGCCVersion)
 	V.RegisterValidation("proxy", isProxy)
 	V.RegisterValidation("imagename", isImageName)
+	V.RegisterValidation("timeout", isTimeout)
+	V.RegisterValidation("imagepattern", isImagePattern)
+	V.RegisterValidation("repopriority", isRepoPriority)
+	V.RegisterValidation("repochecksum", isRepoChecksum)
+	V.RegisterValidation("registryhost", isRegistryHost)
+	V.RegisterValidation("targetalias", isTargetAlias)
+	V.RegisterValidation("processor", isProcessor)
+	V.RegisterValidation("regex", isRegex)
+	V.RegisterValidation("pinnedimage", isPinnedImage)
+	V.RegisterValidation("outputfilepath", isOutputFilePath)
+	V.RegisterValidation("registryauth", isRegistryAuth)
+	V.RegisterValidation("containerprefix", isContainerPrefix)
+	V.RegisterValidation("containerlabel", isContainerLabel)
+	V.RegisterValidation("quantity", isQuantity)
+	V.RegisterValidation("kernelheadersurl", isKernelHeadersURL)
 
 	eng := en.New()
 	uni := ut.New(eng, eng)
@@ -78,7 +97,7 @@ func init() {
 		"architecture",
 		T,
 		func(ut ut.Translator) error {
-			return ut.Add("architecture", fmt.Sprintf("{0} must be a valid architecture (%s)", kernelrelease.SupportedArchs.String()), true)
+			return ut.Add("architecture", fmt.Sprintf("{0} must be a valid architecture, one of %s", kernelrelease.SupportedArchs.Describe()), true)
 		},
 		func(ut ut.Translator, fe validator.FieldError) string {
 			t, _ := ut.T(fe.Tag(), fe.Field())
@@ -126,6 +145,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"timeout_with_processor_kubernetes",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("timeout_with_processor_kubernetes", fmt.Sprintf("{0} must be at least %s when processor is kubernetes or kubernetes-in-cluster", MinKubernetesTimeout), true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("timeout_with_processor_kubernetes", "timeout") // fixme ? tag "name" does not work when used at struct level
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"logrus",
 		T,
@@ -139,6 +171,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"logformat",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("logformat", "{0} must be a valid log format (text, json)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("logformat", fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"eq=dev|sha1|semver",
 		T,
@@ -165,6 +210,32 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"semvertolerant|semverrange",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("semvertolerant|semverrange", "{0} must be a semver-ish string or a semver range", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"gccversion",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("gccversion", "{0} must be a semver-ish string, a semver range, a comma separated list of them, or auto", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"required_without",
 		T,
@@ -178,6 +249,19 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"required_with",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("required_with", "{0} is required when {1} is set", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field(), strings.ToLower(fe.Param()))
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"endswith",
 		T,
@@ -191,6 +275,210 @@ func init() {
 		},
 	)
 
+	V.RegisterTranslation(
+		"imagepattern",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("imagepattern", "{0} must be a valid template rendering a regex with a gccVers named group", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"repopriority",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("repopriority", "{0} must be in the <repo>=<priority> form, with an integer priority", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"repochecksum",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("repochecksum", "{0} must be in the <repo>=sha256:<hex> form", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"kernelheadersurl",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("kernelheadersurl", "{0} must be in the <kernelrelease>=<url>[#sha256=<hex>] form, with an http or https url", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"containerprefix",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("containerprefix", "{0} must start with a letter or a digit, followed by letters, digits, '_', '.' or '-'", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"containerlabel",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("containerlabel", "{0} must be in the <key>=<value> form", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"quantity",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("quantity", "{0} must be a kubernetes resource quantity (eg: 500m, 2Gi)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"registryhost",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("registryhost", "{0} must be a registry host, with an optional port (eg: mirror.example.com:5000)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"targetalias",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("targetalias", "{0} must be in the <alias>=<target> form, with a supported target and an alias that is not one", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"processor",
+		T,
+		func(ut ut.Translator) error {
+			aliases := make([]string, 0, len(ProcessorAliases))
+			for alias := range ProcessorAliases {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			return ut.Add("processor", fmt.Sprintf("{0} must be one of [%s], or one of their aliases [%s]", strings.Join(Processors, ","), strings.Join(aliases, ",")), true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"regex",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("regex", "{0} must be a valid regex", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"pinnedimage",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("pinnedimage", "{0} must be in the <target>_<gcc>=<name>@<digest> form (eg: centos_4.8.5=falcosecurity/driverkit-builder-centos@sha256:<hex>)", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"outputfilepath",
+		T,
+		func(ut ut.Translator) error {
+			placeholders := make([]string, 0, len(builder.OutputPlaceholders))
+			for _, p := range builder.OutputPlaceholders {
+				placeholders = append(placeholders, strings.Trim(p, "{}"))
+			}
+			return ut.Add("outputfilepath", fmt.Sprintf("{0} can only contain the %s placeholders, enclosed in braces", strings.Join(placeholders, ", ")), true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"registryauth",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("registryauth", "{0} must be in the <repo>=<user>:<password> or <repo>=<token> form, with a non empty user", true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
+	V.RegisterTranslation(
+		"timeout",
+		T,
+		func(ut ut.Translator) error {
+			return ut.Add("timeout", fmt.Sprintf("{0} must be at least %s", MinTimeout), true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T(fe.Tag(), fe.Field())
+
+			return t
+		},
+	)
+
 	V.RegisterTranslation(
 		"proxy",
 		T,